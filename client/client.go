@@ -3,10 +3,12 @@
 package client
 
 import (
+	"encoding/json"
 	"errors"
 	"fmt"
 	"net"
 	"net/http"
+	"net/url"
 	"strings"
 )
 
@@ -42,28 +44,54 @@ func NewHTTPClient(socketPath string) (*HTTPClient, error) {
 }
 
 // Reload makes a request to the reload endpoint of a ContainerPilot process.
+// Returns the validation error if the process rejected the new config.
 func (c HTTPClient) Reload() error {
 	resp, err := c.Post("http://control/v3/reload", "application/json", nil)
 	if err != nil {
 		return err
 	}
 	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		var body struct {
+			Error string `json:"error"`
+		}
+		if err := json.NewDecoder(resp.Body).Decode(&body); err != nil || body.Error == "" {
+			return fmt.Errorf("reload failed: %s", resp.Status)
+		}
+		return fmt.Errorf("reload failed: %s", body.Error)
+	}
 	return nil
 }
 
 // SetMaintenance makes a request to either the enable or disable maintenance
-// endpoint of a ContainerPilot process.
-func (c HTTPClient) SetMaintenance(isEnabled bool) error {
+// endpoint of a ContainerPilot process, for all its jobs or only for the job
+// with the given name.
+func (c HTTPClient) SetMaintenance(isEnabled bool, job string) error {
 	flag := "disable"
 	if isEnabled {
 		flag = "enable"
 	}
+	endpoint := "http://control/v3/maintenance/" + flag
+	if job != "" {
+		endpoint += "?job=" + url.QueryEscape(job)
+	}
 
-	resp, err := c.Post("http://control/v3/maintenance/"+flag, "application/json", nil)
+	resp, err := c.Post(endpoint, "application/json", nil)
 	if err != nil {
 		return err
 	}
 	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		var body struct {
+			Error string `json:"error"`
+		}
+		if err := json.NewDecoder(resp.Body).Decode(&body); err != nil || body.Error == "" {
+			return fmt.Errorf("maintenance failed: %s", resp.Status)
+		}
+		return fmt.Errorf("maintenance failed: %s", body.Error)
+	}
 	return nil
 }
 
@@ -0,0 +1,12 @@
+//go:build linux && !go1.20
+// +build linux,!go1.20
+
+package commands
+
+import "syscall"
+
+// startsInCgroup is false because SysProcAttr.CgroupFD requires Go 1.20,
+// so the process is moved into its cgroup after it starts instead
+const startsInCgroup = false
+
+func setCgroupFD(attr *syscall.SysProcAttr, fd int) {}
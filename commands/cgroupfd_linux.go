@@ -0,0 +1,15 @@
+//go:build linux && go1.20
+// +build linux,go1.20
+
+package commands
+
+import "syscall"
+
+// startsInCgroup is true if the process can be started directly in its
+// cgroup with clone3, which requires Linux 5.7 or later
+const startsInCgroup = true
+
+func setCgroupFD(attr *syscall.SysProcAttr, fd int) {
+	attr.UseCgroupFD = true
+	attr.CgroupFD = fd
+}
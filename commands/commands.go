@@ -6,6 +6,7 @@ package commands
 import (
 	"context"
 	"fmt"
+	"io"
 	"os"
 	"os/exec"
 	"path/filepath"
@@ -13,6 +14,7 @@ import (
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"syscall"
 	"time"
 
@@ -27,9 +29,47 @@ type Command struct {
 	Exec    string
 	Args    []string
 	Timeout time.Duration
-	logger  log.Entry
-	lock    *sync.Mutex
-	fields  log.Fields
+
+	Dir      string            // working directory, defaults to ContainerPilot's
+	Env      map[string]string // added to (or overrides) the environment
+	EnvFile  string            // file of KEY=VALUE lines added to the environment
+	CleanEnv bool              // don't inherit ContainerPilot's environment
+
+	// CaptureOutput keeps the stdout of each process, in addition to
+	// logging it, so that it's available as ProcessState.Output
+	CaptureOutput bool
+
+	// Credentials and Limits are optional and must be validated by
+	// the caller
+	Credentials *Credentials
+	Limits      *Limits
+
+	logger    log.Entry
+	lock      *sync.Mutex
+	fields    log.Fields
+	state     ProcessState
+	pgid      int // process group of the last process, kept after it exits
+	stateLock *sync.RWMutex
+	running   sync.WaitGroup
+	active    int32 // 1 from Run until the exit events are published
+}
+
+// ProcessState is a snapshot of the state of a Command's most recent
+// process, for reporting metrics
+type ProcessState struct {
+	Pid       int           // pid of the running process, or 0
+	StartTime time.Time     // start time of the running process
+	Exited    bool          // true once any process has exited
+	ExitCode  int           // exit code of the last process, or -1 if signaled or not started
+	Duration  time.Duration // how long the last process ran
+	Output    string        // stdout of the last process, if captured
+}
+
+// ProcessUsage is the resource usage of a Command's process group
+type ProcessUsage struct {
+	CPUSeconds float64
+	RSSBytes   uint64
+	OpenFDs    int
 }
 
 // NewCommand parses JSON config into a Command
@@ -39,11 +79,12 @@ func NewCommand(rawArgs interface{}, timeout time.Duration, fields log.Fields) (
 		return nil, err
 	}
 	cmd := &Command{
-		Name:    exec, // override this in caller
-		Exec:    exec,
-		Args:    args,
-		Timeout: timeout,
-		lock:    &sync.Mutex{},
+		Name:      exec, // override this in caller
+		Exec:      exec,
+		Args:      args,
+		Timeout:   timeout,
+		lock:      &sync.Mutex{},
+		stateLock: &sync.RWMutex{},
 	} // exec.Cmd created at Run
 
 	if fields != nil {
@@ -101,9 +142,18 @@ func (c *Command) Run(pctx context.Context, bus *events.EventBus) {
 		cmd.Stdout = os.Stdout
 		cmd.Stderr = os.Stderr
 	}
+	var output *outputBuffer
+	if c.CaptureOutput {
+		output = &outputBuffer{}
+		cmd.Stdout = io.MultiWriter(cmd.Stdout, output)
+	}
+	cmd.Dir = c.Dir
 	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	c.Credentials.setSysProcAttr(cmd.SysProcAttr)
 	c.Cmd = cmd
 	ctx, cancel := getContext(pctx, c.Timeout)
+	c.running.Add(1)
+	atomic.StoreInt32(&c.active, 1)
 
 	go func() {
 		// Children may have side-effects so we don't want to wait for them
@@ -122,14 +172,26 @@ func (c *Command) Run(pctx context.Context, bus *events.EventBus) {
 	}()
 
 	go func() {
+		defer c.running.Done()
+		defer atomic.StoreInt32(&c.active, 0)
 		defer cancel()
 		defer log.Debugf("%s.Run end", c.Name)
-		if err := c.Cmd.Start(); err != nil {
+		if err := c.start(); err != nil {
 			log.Errorf("unable to start %s: %v", c.Name, err)
+			c.setStartFailed(err)
 			bus.Publish(events.Event{Code: events.ExitFailed, Source: c.Name})
 			bus.Publish(events.Event{Code: events.Error, Source: err.Error()})
 			return
 		}
+		c.setStarted(c.Cmd.Process.Pid)
+		// the context may have been done while the process was starting,
+		// when there was no pid yet to signal
+		switch ctx.Err() {
+		case context.DeadlineExceeded:
+			c.Kill()
+		case context.Canceled:
+			c.Term()
+		}
 
 		// if we're able to, log the PID of our Command's exec process through
 		// our logger fields
@@ -148,7 +210,9 @@ func (c *Command) Run(pctx context.Context, bus *events.EventBus) {
 
 		// blocks this goroutine here; if the context gets cancelled
 		// we'll return from Wait() and publish events
-		if err := c.Cmd.Wait(); err != nil {
+		err := c.Cmd.Wait()
+		c.setExited(output)
+		if err != nil {
 			log.Errorf("%s exited with error: %v", c.Name, err)
 			bus.Publish(events.Event{Code: events.ExitFailed, Source: c.Name})
 			bus.Publish(events.Event{Code: events.Error,
@@ -160,6 +224,69 @@ func (c *Command) Run(pctx context.Context, bus *events.EventBus) {
 	}()
 }
 
+// start sets up the environment for the exec.Cmd and starts it
+func (c *Command) start() error {
+	env, err := c.environ()
+	if err != nil {
+		return err
+	}
+	c.Cmd.Env = env
+	started, err := c.Limits.prepare(c.Cmd)
+	if err != nil {
+		return err
+	}
+	err = c.Credentials.start(c.Cmd)
+	if err != nil {
+		started(0) // closes the cgroup
+		return err
+	}
+	if err := started(c.Cmd.Process.Pid); err != nil {
+		// we don't want to run the process without its limits
+		c.Cmd.Process.Kill()
+		c.Cmd.Wait()
+		return err
+	}
+	return nil
+}
+
+// State returns a snapshot of the state of the Command's most recent
+// process
+func (c *Command) State() ProcessState {
+	c.stateLock.RLock()
+	defer c.stateLock.RUnlock()
+	return c.state
+}
+
+func (c *Command) setStarted(pid int) {
+	c.stateLock.Lock()
+	defer c.stateLock.Unlock()
+	c.state.Pid = pid
+	c.state.StartTime = time.Now()
+	c.pgid = pid
+}
+
+func (c *Command) setExited(output *outputBuffer) {
+	c.stateLock.Lock()
+	defer c.stateLock.Unlock()
+	c.state.Exited = true
+	c.state.Output = ""
+	if output != nil {
+		c.state.Output = output.String()
+	}
+	c.state.ExitCode = c.Cmd.ProcessState.ExitCode()
+	c.state.Duration = time.Since(c.state.StartTime)
+	c.state.Pid = 0
+}
+
+// setStartFailed records a process that never ran, so that its exit code
+// and output aren't mistaken for those of the previous process
+func (c *Command) setStartFailed(err error) {
+	c.stateLock.Lock()
+	defer c.stateLock.Unlock()
+	c.state.ExitCode = -1
+	c.state.Output = err.Error()
+}
+
 func getContext(pctx context.Context, timeout time.Duration) (context.Context, context.CancelFunc) {
 	if timeout > 0 {
 		return context.WithTimeout(pctx, timeout)
@@ -167,22 +294,56 @@ func getContext(pctx context.Context, timeout time.Duration) (context.Context, c
 	return context.WithCancel(pctx)
 }
 
+// IsRunning returns true from the time Run is called until the Command's
+// process has exited and its exit events have been published
+func (c *Command) IsRunning() bool {
+	if c == nil {
+		return false
+	}
+	return atomic.LoadInt32(&c.active) == 1
+}
+
+// Wait blocks until the Command's process, if it's running, has exited
+// and its exit events have been published
+func (c *Command) Wait() {
+	if c == nil {
+		return
+	}
+	c.running.Wait()
+}
+
 // Kill sends a kill signal to the underlying process if it still exists,
 // as well as all its children
 func (c *Command) Kill() {
 	log.Debugf("%s.kill", c.Name)
-	if c.Cmd != nil && c.Cmd.Process != nil {
-		log.Debugf("killing command '%v' at pid: %d", c.Name, c.Cmd.Process.Pid)
-		syscall.Kill(-c.Cmd.Process.Pid, syscall.SIGKILL)
-	}
+	c.signal(syscall.SIGKILL)
 }
 
 // Term sends a terminate signal to the underlying process if it still exists,
 // as well as all its children
 func (c *Command) Term() {
 	log.Debugf("%s.term", c.Name)
-	if c.Cmd != nil && c.Cmd.Process != nil {
-		log.Debugf("terminating command '%v' at pid: %d", c.Name, c.Cmd.Process.Pid)
-		syscall.Kill(-c.Cmd.Process.Pid, syscall.SIGTERM)
+	c.signal(syscall.SIGTERM)
+}
+
+// Signal sends the signal to the underlying process if it still exists,
+// as well as all its children
+func (c *Command) Signal(sig syscall.Signal) {
+	log.Debugf("%s.signal %v", c.Name, sig)
+	c.signal(sig)
+}
+
+// signal sends the signal to the process group of the last process, which
+// may have children left after it exits. We use the pid recorded once the
+// process has started rather than c.Cmd.Process, which is still being set
+// while the process starts.
+func (c *Command) signal(sig syscall.Signal) {
+	c.stateLock.RLock()
+	pgid := c.pgid
+	c.stateLock.RUnlock()
+	if pgid == 0 {
+		return
 	}
+	log.Debugf("sending %v to command '%v' at pid: %d", sig, c.Name, pgid)
+	syscall.Kill(-pgid, sig)
 }
@@ -4,6 +4,7 @@ import (
 	"context"
 	"fmt"
 	"os"
+	"strings"
 	"testing"
 	"time"
 
@@ -12,6 +13,13 @@ import (
 	"github.com/stretchr/testify/assert"
 )
 
+func TestMain(m *testing.M) {
+	// the test binary stands in for ContainerPilot when a Command with
+	// rlimits runs it
+	ExecWithRlimits(os.Args)
+	os.Exit(m.Run())
+}
+
 func TestCommandRunWithTimeoutZero(t *testing.T) {
 	cmd, _ := NewCommand("sleep 2", time.Duration(0), nil)
 	got := runtestCommandRun(cmd)
@@ -89,6 +97,51 @@ func TestCommandPassthru(t *testing.T) {
 	assert.NotEqual(t, cmd.Cmd.Stdout, os.Stdout)
 }
 
+func TestCommandState(t *testing.T) {
+	cmd, _ := NewCommand("./testdata/test.sh failStuff", time.Duration(0), nil)
+	assert.Equal(t, ProcessState{}, cmd.State())
+	runtestCommandRun(cmd)
+	state := cmd.State()
+	assert.True(t, state.Exited, "expected process to have exited")
+	assert.Equal(t, 255, state.ExitCode)
+	assert.Equal(t, 0, state.Pid)
+	assert.True(t, state.Duration > 0, "expected process duration")
+
+	cmd, _ = NewCommand("sleep 2", time.Duration(100*time.Millisecond), nil)
+	runtestCommandRun(cmd)
+	assert.Equal(t, -1, cmd.State().ExitCode, "expected killed process")
+}
+
+func TestCommandCaptureOutput(t *testing.T) {
+	cmd, _ := NewCommand("./testdata/test.sh failStuff", time.Duration(0), nil)
+	runtestCommandRun(cmd)
+	assert.Equal(t, "", cmd.State().Output, "expected output not to be captured")
+
+	cmd.CaptureOutput = true
+	runtestCommandRun(cmd)
+	assert.Equal(t, "Running failStuff with args: \n", cmd.State().Output)
+
+	cmd, _ = NewCommand("./testdata/invalidCommand", time.Duration(0), nil)
+	cmd.CaptureOutput = true
+	runtestCommandRun(cmd)
+	state := cmd.State()
+	assert.Equal(t, -1, state.ExitCode, "expected command not to start")
+	assert.Equal(t,
+		"fork/exec ./testdata/invalidCommand: no such file or directory",
+		state.Output)
+}
+
+func TestOutputBuffer(t *testing.T) {
+	buf := &outputBuffer{}
+	line := strings.Repeat("x", 1000)
+	for i := 0; i < 5; i++ {
+		n, err := buf.Write([]byte(line))
+		assert.NoError(t, err)
+		assert.Equal(t, len(line), n)
+	}
+	assert.Equal(t, maxOutputSize, len(buf.String()))
+}
+
 func TestEnvName(t *testing.T) {
 	tests := []struct {
 		name, input, output string
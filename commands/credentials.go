@@ -0,0 +1,127 @@
+package commands
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"os/user"
+	"strconv"
+	"syscall"
+)
+
+// Credentials configures the user, groups, and privileges that a
+// Command's process runs with. A zero-value Credentials runs the process
+// with the same credentials as ContainerPilot.
+type Credentials struct {
+	User       string   // user name or UID
+	Group      string   // group name or GID, defaults to the user's group
+	Groups     []string // supplementary group names or GIDs
+	Umask      string   // octal file mode creation mask
+	NoNewPrivs bool     // set the no_new_privs bit for the process
+
+	credential *syscall.Credential
+	umask      int
+}
+
+// Validate resolves the user and group names into IDs and parses the
+// umask. Users and groups are resolved once here rather than on every
+// Run so that a missing user is a configuration error.
+func (c *Credentials) Validate() error {
+	if c.Umask != "" {
+		umask, err := strconv.ParseUint(c.Umask, 8, 32)
+		if err != nil || umask > 0777 {
+			return fmt.Errorf("umask '%s' must be an octal value between 0 and 0777",
+				c.Umask)
+		}
+		c.umask = int(umask)
+	}
+	if c.User == "" && c.Group == "" && len(c.Groups) == 0 {
+		return nil
+	}
+
+	uid := uint32(os.Getuid())
+	gid := uint32(os.Getgid())
+	if c.User != "" {
+		u, err := lookupUser(c.User)
+		switch {
+		case err == nil:
+			uid, _ = parseID(u.Uid)
+			gid, _ = parseID(u.Gid)
+		case isNumeric(c.User):
+			// numeric UIDs don't need to exist in /etc/passwd, in which
+			// case we keep our own group unless one is configured
+			uid, _ = parseID(c.User)
+		default:
+			return fmt.Errorf("user '%s' not found: %v", c.User, err)
+		}
+	}
+	if c.Group != "" {
+		id, err := lookupGroup(c.Group)
+		if err != nil {
+			return fmt.Errorf("group '%s' not found: %v", c.Group, err)
+		}
+		gid = id
+	}
+	// we always set the supplementary groups so that the process doesn't
+	// inherit ContainerPilot's groups when we switch users
+	groups := []uint32{}
+	for _, name := range c.Groups {
+		id, err := lookupGroup(name)
+		if err != nil {
+			return fmt.Errorf("groups '%s' not found: %v", name, err)
+		}
+		groups = append(groups, id)
+	}
+	c.credential = &syscall.Credential{Uid: uid, Gid: gid, Groups: groups}
+	return nil
+}
+
+// setSysProcAttr sets the user and groups for the process
+func (c *Credentials) setSysProcAttr(attr *syscall.SysProcAttr) {
+	if c == nil || c.credential == nil {
+		return
+	}
+	attr.Credential = c.credential
+}
+
+// start starts the process, setting the umask and no_new_privs bit for
+// the process if they've been configured.
+func (c *Credentials) start(cmd *exec.Cmd) error {
+	if c == nil || (c.Umask == "" && !c.NoNewPrivs) {
+		return cmd.Start()
+	}
+	umask := -1
+	if c.Umask != "" {
+		umask = c.umask
+	}
+	return startIsolated(cmd, umask, c.NoNewPrivs)
+}
+
+func lookupUser(name string) (*user.User, error) {
+	if isNumeric(name) {
+		return user.LookupId(name)
+	}
+	return user.Lookup(name)
+}
+
+func lookupGroup(name string) (uint32, error) {
+	if isNumeric(name) {
+		// numeric GIDs don't need to exist in /etc/group
+		return parseID(name)
+	}
+	g, err := user.LookupGroup(name)
+	if err != nil {
+		return 0, err
+	}
+	return parseID(g.Gid)
+}
+
+func parseID(id string) (uint32, error) {
+	i, err := strconv.ParseUint(id, 10, 32)
+	return uint32(i), err
+}
+
+func isNumeric(id string) bool {
+	_, err := parseID(id)
+	return err == nil
+}
@@ -0,0 +1,43 @@
+//go:build linux
+// +build linux
+
+package commands
+
+import (
+	"fmt"
+	"os/exec"
+	"runtime"
+	"syscall"
+)
+
+const prSetNoNewPrivs = 38 // PR_SET_NO_NEW_PRIVS from linux/prctl.h
+
+// startIsolated starts the process from a dedicated OS thread so that the
+// umask and no_new_privs bit are inherited by the child without changing
+// them for the rest of ContainerPilot. We never unlock the thread, so the
+// Go runtime throws it away when the goroutine exits.
+func startIsolated(cmd *exec.Cmd, umask int, noNewPrivs bool) error {
+	errCh := make(chan error, 1)
+	go func() {
+		runtime.LockOSThread()
+		if umask >= 0 {
+			// the umask is shared by all threads in the process unless
+			// we unshare the filesystem attributes for this thread
+			if err := syscall.Unshare(syscall.CLONE_FS); err != nil {
+				errCh <- fmt.Errorf("unable to set umask: %v", err)
+				return
+			}
+			syscall.Umask(umask)
+		}
+		if noNewPrivs {
+			_, _, errno := syscall.RawSyscall6(syscall.SYS_PRCTL,
+				prSetNoNewPrivs, 1, 0, 0, 0, 0)
+			if errno != 0 {
+				errCh <- fmt.Errorf("unable to set no_new_privs: %v", errno)
+				return
+			}
+		}
+		errCh <- cmd.Start()
+	}()
+	return <-errCh
+}
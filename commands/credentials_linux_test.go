@@ -0,0 +1,55 @@
+package commands
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/asokolov365/containerpilot/events"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCommandRunUmaskAndNoNewPrivs(t *testing.T) {
+	out := filepath.Join(t.TempDir(), "out")
+	cmd, _ := NewCommand([]string{"sh", "-c",
+		"umask > " + out + "; grep NoNewPrivs /proc/self/status >> " + out},
+		time.Duration(0), nil)
+	cmd.Credentials = &Credentials{Umask: "0077", NoNewPrivs: true}
+	if err := cmd.Credentials.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	before := syscall.Umask(022)
+	syscall.Umask(before)
+
+	got := runtestCommandRun(cmd)
+	if got[events.Event{Code: events.ExitSuccess, Source: "sh"}] != 1 {
+		t.Fatalf("expected command to succeed, got events %v", got)
+	}
+	result, _ := ioutil.ReadFile(out)
+	lines := strings.Split(strings.TrimSpace(string(result)), "\n")
+	assert.Equal(t, []string{"0077", "NoNewPrivs:\t1"}, lines)
+
+	// the umask for ContainerPilot itself is unchanged
+	after := syscall.Umask(before)
+	assert.Equal(t, before, after)
+}
+
+func TestCommandRunAsUser(t *testing.T) {
+	if syscall.Getuid() != 0 {
+		t.Skip("changing users requires running tests as root")
+	}
+	cmd, _ := NewCommand([]string{"sh", "-c",
+		`[ "$(id -u) $(id -g) $(id -G)" = "54321 4321 4321 4322" ]`},
+		time.Duration(0), nil)
+	cmd.Credentials = &Credentials{User: "54321", Group: "4321", Groups: []string{"4322"}}
+	if err := cmd.Credentials.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	got := runtestCommandRun(cmd)
+	if got[events.Event{Code: events.ExitSuccess, Source: "sh"}] != 1 {
+		t.Fatalf("expected command to run as user, got events %v", got)
+	}
+}
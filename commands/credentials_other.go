@@ -0,0 +1,15 @@
+//go:build !linux
+// +build !linux
+
+package commands
+
+import (
+	"errors"
+	"os/exec"
+)
+
+// startIsolated is only supported on Linux, where we can set the umask
+// and no_new_privs bit for a single thread.
+func startIsolated(cmd *exec.Cmd, umask int, noNewPrivs bool) error {
+	return errors.New("umask and no_new_privs are only supported on Linux")
+}
@@ -0,0 +1,59 @@
+package commands
+
+import (
+	"os"
+	"syscall"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCredentialsValidate(t *testing.T) {
+	creds := &Credentials{User: "root", Groups: []string{"0", "1234"}, Umask: "027"}
+	if err := creds.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, &syscall.Credential{Uid: 0, Gid: 0, Groups: []uint32{0, 1234}},
+		creds.credential)
+	assert.Equal(t, 027, creds.umask)
+
+	// numeric users don't need to exist
+	creds = &Credentials{User: "54321", Group: "4321"}
+	if err := creds.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, &syscall.Credential{Uid: 54321, Gid: 4321, Groups: []uint32{}},
+		creds.credential)
+
+	// only the group is changed
+	creds = &Credentials{Group: "4321"}
+	if err := creds.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, uint32(os.Getuid()), creds.credential.Uid)
+	assert.Equal(t, uint32(4321), creds.credential.Gid)
+
+	creds = &Credentials{}
+	if err := creds.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	assert.Nil(t, creds.credential)
+}
+
+func TestCredentialsValidateErrors(t *testing.T) {
+	expectErr := func(creds *Credentials, errMsg string) {
+		t.Helper()
+		err := creds.Validate()
+		if assert.Error(t, err) {
+			assert.Contains(t, err.Error(), errMsg)
+		}
+	}
+	expectErr(&Credentials{User: "no-such-user"}, "user 'no-such-user' not found")
+	expectErr(&Credentials{Group: "no-such-group"}, "group 'no-such-group' not found")
+	expectErr(&Credentials{Groups: []string{"no-such-group"}},
+		"groups 'no-such-group' not found")
+	expectErr(&Credentials{Umask: "999"},
+		"umask '999' must be an octal value between 0 and 0777")
+	expectErr(&Credentials{Umask: "1000"},
+		"umask '1000' must be an octal value between 0 and 0777")
+}
@@ -0,0 +1,86 @@
+package commands
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+)
+
+// environ returns the environment for the process, or nil if the
+// process should inherit ContainerPilot's environment unmodified. The
+// envFile is read on every run so that it can be written by another job.
+// Variables in Env take precedence over variables in EnvFile, because
+// exec.Cmd uses the last value for duplicate keys.
+func (c *Command) environ() ([]string, error) {
+	if !c.CleanEnv && c.EnvFile == "" && len(c.Env) == 0 {
+		return nil, nil
+	}
+	var env []string
+	if !c.CleanEnv {
+		env = os.Environ()
+	}
+	if c.EnvFile != "" {
+		fileEnv, err := ReadEnvFile(c.EnvFile)
+		if err != nil {
+			return nil, err
+		}
+		env = append(env, fileEnv...)
+	}
+	keys := make([]string, 0, len(c.Env))
+	for key := range c.Env {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		env = append(env, key+"="+c.Env[key])
+	}
+	// a nil Env would inherit our environment
+	if env == nil {
+		env = []string{}
+	}
+	return env, nil
+}
+
+// ReadEnvFile parses a file of KEY=VALUE lines into environment variables.
+// Blank lines and lines starting with '#' are ignored, and values may be
+// wrapped in single or double quotes.
+func ReadEnvFile(path string) ([]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read envFile: %v", err)
+	}
+	defer f.Close()
+
+	var env []string
+	scanner := bufio.NewScanner(f)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		line = strings.TrimPrefix(line, "export ")
+		parts := strings.SplitN(line, "=", 2)
+		key := strings.TrimSpace(parts[0])
+		if len(parts) != 2 || key == "" {
+			return nil, fmt.Errorf("invalid envFile %s line %d: expected KEY=VALUE",
+				path, n)
+		}
+		env = append(env, key+"="+unquote(strings.TrimSpace(parts[1])))
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("unable to read envFile: %v", err)
+	}
+	return env, nil
+}
+
+func unquote(val string) string {
+	if len(val) >= 2 {
+		if (val[0] == '"' && val[len(val)-1] == '"') ||
+			(val[0] == '\'' && val[len(val)-1] == '\'') {
+			return val[1 : len(val)-1]
+		}
+	}
+	return val
+}
@@ -0,0 +1,73 @@
+package commands
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/asokolov365/containerpilot/events"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReadEnvFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "env")
+	ioutil.WriteFile(path, []byte(`
+# comment
+FOO=bar
+export BAZ = "quoted value"
+EMPTY=
+SINGLE='a=b'
+`), 0644)
+	env, err := ReadEnvFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, []string{"FOO=bar", "BAZ=quoted value", "EMPTY=", "SINGLE=a=b"}, env)
+
+	ioutil.WriteFile(path, []byte("FOO=bar\nnotAVar\n"), 0644)
+	_, err = ReadEnvFile(path)
+	assert.EqualError(t, err, "invalid envFile "+path+" line 2: expected KEY=VALUE")
+
+	_, err = ReadEnvFile(filepath.Join(t.TempDir(), "missing"))
+	assert.Contains(t, err.Error(), "unable to read envFile")
+}
+
+func TestCommandEnviron(t *testing.T) {
+	cmd, _ := NewCommand("true", time.Duration(0), nil)
+	env, _ := cmd.environ()
+	assert.Nil(t, env, "expected inherited environment")
+
+	path := filepath.Join(t.TempDir(), "env")
+	ioutil.WriteFile(path, []byte("FOO=file\nBAR=file\n"), 0644)
+	cmd.CleanEnv = true
+	cmd.EnvFile = path
+	cmd.Env = map[string]string{"FOO": "env", "BAZ": "env"}
+	env, _ = cmd.environ()
+	assert.Equal(t, []string{"FOO=file", "BAR=file", "BAZ=env", "FOO=env"}, env)
+
+	cmd.Env = nil
+	cmd.EnvFile = ""
+	env, _ = cmd.environ()
+	assert.Equal(t, []string{}, env, "expected clean environment")
+}
+
+func TestCommandRunWithDirAndEnv(t *testing.T) {
+	dir := t.TempDir()
+	cmd, _ := NewCommand([]string{"/bin/sh", "-c",
+		`[ "$(pwd)" = "` + dir + `" ] && [ "$FOO" = "bar" ] && [ -z "$HOME" ]`},
+		time.Duration(0), nil)
+	cmd.Dir = dir
+	cmd.Env = map[string]string{"FOO": "bar"}
+	cmd.CleanEnv = true
+	got := runtestCommandRun(cmd)
+	if got[events.Event{Code: events.ExitSuccess, Source: "/bin/sh"}] != 1 {
+		t.Fatalf("expected command to run with dir and env, got events %v", got)
+	}
+
+	cmd.EnvFile = filepath.Join(dir, "missing")
+	got = runtestCommandRun(cmd)
+	if got[events.Event{Code: events.ExitFailed, Source: "/bin/sh"}] != 1 {
+		t.Fatalf("expected missing envFile to fail, got events %v", got)
+	}
+}
@@ -0,0 +1,82 @@
+package commands
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Limits configures the resource limits for a Command's process. The
+// rlimits are applied to the process itself, whereas the memory and CPU
+// limits are applied to a cgroup v2 sub-group shared by every process
+// started with the same Cgroup name.
+type Limits struct {
+	NoFile    *uint64 // RLIMIT_NOFILE
+	NProc     *uint64 // RLIMIT_NPROC
+	Core      *uint64 // RLIMIT_CORE
+	Memory    string  // memory.max in bytes or with a K, M, G, or T suffix
+	CPUWeight int     // cpu.weight from 1 to 10000
+	Cgroup    string  // name of the cgroup, required for Memory and CPUWeight
+
+	memory uint64
+}
+
+// Usage is the resource usage of a cgroup
+type Usage struct {
+	MemoryBytes uint64  // memory.current
+	CPUSeconds  float64 // usage_usec from cpu.stat
+}
+
+// Validate ensures that Limits meets all constraints
+func (l *Limits) Validate() error {
+	if l.Memory != "" {
+		memory, err := parseSize(l.Memory)
+		if err != nil {
+			return fmt.Errorf("memory '%s' is invalid: %v", l.Memory, err)
+		}
+		l.memory = memory
+	}
+	if l.CPUWeight != 0 && (l.CPUWeight < 1 || l.CPUWeight > 10000) {
+		return fmt.Errorf("cpuWeight '%d' must be between 1 and 10000", l.CPUWeight)
+	}
+	if l.usesCgroup() && l.Cgroup == "" {
+		return fmt.Errorf("memory and cpuWeight require a cgroup name")
+	}
+	return nil
+}
+
+func (l *Limits) usesCgroup() bool {
+	return l != nil && (l.Memory != "" || l.CPUWeight != 0)
+}
+
+func (l *Limits) usesRlimits() bool {
+	return l != nil && (l.NoFile != nil || l.NProc != nil || l.Core != nil)
+}
+
+// parseSize parses a size in bytes with an optional binary K, M, G, or
+// T suffix, such as "512M"
+func parseSize(size string) (uint64, error) {
+	multipliers := map[string]uint64{
+		"K": 1 << 10,
+		"M": 1 << 20,
+		"G": 1 << 30,
+		"T": 1 << 40,
+	}
+	size = strings.TrimSpace(strings.ToUpper(size))
+	size = strings.TrimSuffix(size, "B")
+	multiplier := uint64(1)
+	if len(size) > 0 {
+		if m, ok := multipliers[size[len(size)-1:]]; ok {
+			multiplier = m
+			size = size[:len(size)-1]
+		}
+	}
+	n, err := strconv.ParseUint(size, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("expected a number of bytes with an optional K, M, G, or T suffix")
+	}
+	if n == 0 {
+		return 0, fmt.Errorf("must be greater than zero")
+	}
+	return n * multiplier, nil
+}
@@ -0,0 +1,333 @@
+//go:build linux
+// +build linux
+
+package commands
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+)
+
+// these are vars so that we can point them at a fake cgroup hierarchy
+// in tests
+var (
+	cgroupRoot     = "/sys/fs/cgroup"
+	procSelfCgroup = "/proc/self/cgroup"
+)
+
+const (
+	rlimitNProc  = 6                // RLIMIT_NPROC from asm-generic/resource.h
+	cgroupPrefix = "job-"           // prefix for job cgroup names
+	cgroupLeaf   = "containerpilot" // cgroup for ContainerPilot itself
+
+	// rlimitsArg is the first argument when ContainerPilot runs itself to
+	// set the rlimits for a process before it execs that process
+	rlimitsArg = "--containerpilot-exec-with-rlimits"
+)
+
+var (
+	cgroupOnce sync.Once
+	cgroupBase string
+	cgroupErr  error
+)
+
+// prepare sets up the cgroup for the process, if any, so that the process
+// starts in it and the children it forks right away can't escape it. The
+// stdlib has no way to set the rlimits between fork and exec, so if there
+// are any the process is started by ContainerPilot itself, which sets them
+// and then execs it. It returns a func that finishes applying the limits
+// once the process has started.
+func (l *Limits) prepare(cmd *exec.Cmd) (func(pid int) error, error) {
+	if l.usesRlimits() {
+		if err := l.execWithRlimits(cmd); err != nil {
+			return nil, err
+		}
+	}
+	var cgroup *os.File
+	if l.usesCgroup() {
+		path, err := l.setupCgroup()
+		if err != nil {
+			return nil, err
+		}
+		if startsInCgroup {
+			cgroup, err = os.Open(path)
+			if err != nil {
+				return nil, fmt.Errorf("unable to open cgroup: %v", err)
+			}
+			setCgroupFD(cmd.SysProcAttr, int(cgroup.Fd()))
+		}
+	}
+	return func(pid int) error {
+		if cgroup != nil {
+			cgroup.Close()
+		}
+		if pid == 0 {
+			return nil // the process failed to start
+		}
+		if cgroup == nil && l.usesCgroup() {
+			// built with a Go toolchain that can't start the process in
+			// the cgroup, so we have to move it there
+			return writeCgroupFile(l.cgroupPath(), "cgroup.procs", strconv.Itoa(pid))
+		}
+		return nil
+	}, nil
+}
+
+// execWithRlimits updates the cmd to run ContainerPilot with the rlimits
+// and the original path and args, for ExecWithRlimits
+func (l *Limits) execWithRlimits(cmd *exec.Cmd) error {
+	self, err := os.Executable()
+	if err != nil {
+		return fmt.Errorf("unable to set rlimits: %v", err)
+	}
+	values := []struct {
+		name  string
+		value *uint64
+	}{{"nofile", l.NoFile}, {"nproc", l.NProc}, {"core", l.Core}}
+	var rlimits []string
+	for _, v := range values {
+		if v.value != nil {
+			rlimits = append(rlimits, fmt.Sprintf("%s=%d", v.name, *v.value))
+		}
+	}
+	args := []string{self, rlimitsArg, strings.Join(rlimits, ","), cmd.Path}
+	cmd.Args = append(args, cmd.Args...)
+	cmd.Path = self
+	return nil
+}
+
+// rlimitResources are the rlimits we can set, by their name in the config
+var rlimitResources = map[string]int{
+	"nofile": syscall.RLIMIT_NOFILE,
+	"nproc":  rlimitNProc,
+	"core":   syscall.RLIMIT_CORE,
+}
+
+// ExecWithRlimits must be called before anything else when ContainerPilot
+// starts. If ContainerPilot has been run by a Command to set the rlimits
+// of its process, this sets them and execs the Command's executable, so
+// that it never returns. Otherwise it does nothing.
+func ExecWithRlimits(args []string) {
+	if len(args) < 5 || args[1] != rlimitsArg {
+		return
+	}
+	if err := setRlimits(args[2]); err != nil {
+		fmt.Fprintf(os.Stderr, "unable to start %s: %v\n", args[3], err)
+		os.Exit(126)
+	}
+	err := syscall.Exec(args[3], args[4:], os.Environ())
+	fmt.Fprintf(os.Stderr, "unable to start %s: %v\n", args[3], err)
+	os.Exit(127)
+}
+
+// setRlimits sets the rlimits for this process from a list like
+// "nofile=1024,core=0"
+func setRlimits(raw string) error {
+	for _, field := range strings.Split(raw, ",") {
+		parts := strings.SplitN(field, "=", 2)
+		if len(parts) != 2 {
+			return fmt.Errorf("invalid rlimit '%s'", field)
+		}
+		resource, ok := rlimitResources[parts[0]]
+		value, err := strconv.ParseUint(parts[1], 10, 64)
+		if !ok || err != nil {
+			return fmt.Errorf("invalid rlimit '%s'", field)
+		}
+		// we set the hard limit as well so that the process can't
+		// raise its own limit
+		lim := &syscall.Rlimit{Cur: value, Max: value}
+		if err := syscall.Setrlimit(resource, lim); err != nil {
+			return fmt.Errorf("unable to set %s limit: %v", parts[0], err)
+		}
+	}
+	return nil
+}
+
+// setupCgroup creates the cgroup for these Limits and sets its limits,
+// and returns its path
+func (l *Limits) setupCgroup() (string, error) {
+	if _, err := setupCgroups(); err != nil {
+		return "", fmt.Errorf("unable to set up cgroup: %v", err)
+	}
+	path := l.cgroupPath()
+	if err := os.Mkdir(path, 0755); err != nil && !os.IsExist(err) {
+		return "", fmt.Errorf("unable to create cgroup: %v", err)
+	}
+	if l.Memory != "" {
+		err := writeCgroupFile(path, "memory.max", strconv.FormatUint(l.memory, 10))
+		if err != nil {
+			return "", err
+		}
+	}
+	if l.CPUWeight != 0 {
+		err := writeCgroupFile(path, "cpu.weight", strconv.Itoa(l.CPUWeight))
+		if err != nil {
+			return "", err
+		}
+	}
+	return path, nil
+}
+
+// cgroupPath must only be called once the cgroups have been set up
+func (l *Limits) cgroupPath() string {
+	return filepath.Join(cgroupBase, cgroupPrefix+l.Cgroup)
+}
+
+// RemoveCgroup removes the cgroup for these Limits, if any, once its
+// processes have exited, as when its job has been removed by a reload
+func (l *Limits) RemoveCgroup() error {
+	if !l.usesCgroup() {
+		return nil
+	}
+	if _, err := setupCgroups(); err != nil {
+		return nil // the cgroup was never created
+	}
+	if err := os.Remove(l.cgroupPath()); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("unable to remove cgroup: %v", err)
+	}
+	return nil
+}
+
+// Usage returns the resource usage of the cgroup for these Limits, if any
+func (l *Limits) Usage() (*Usage, error) {
+	if !l.usesCgroup() {
+		return nil, nil
+	}
+	if _, err := setupCgroups(); err != nil {
+		return nil, err
+	}
+	path := l.cgroupPath()
+	usage := &Usage{}
+	// memory.current is missing if the memory controller isn't enabled
+	raw, err := ioutil.ReadFile(filepath.Join(path, "memory.current"))
+	if err == nil {
+		usage.MemoryBytes, _ = strconv.ParseUint(string(bytes.TrimSpace(raw)), 10, 64)
+	}
+	raw, err = ioutil.ReadFile(filepath.Join(path, "cpu.stat"))
+	if err != nil {
+		return nil, err
+	}
+	scanner := bufio.NewScanner(bytes.NewReader(raw))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 2 && fields[0] == "usage_usec" {
+			usec, _ := strconv.ParseUint(fields[1], 10, 64)
+			usage.CPUSeconds = float64(usec) / 1e6
+		}
+	}
+	return usage, nil
+}
+
+// setupCgroups prepares ContainerPilot's cgroup to have job sub-groups.
+// A cgroup v2 hierarchy can only enable controllers for its children if
+// it has no processes of its own, so we move ContainerPilot and its
+// processes into a leaf cgroup first. This only happens once per process,
+// because after the move /proc/self/cgroup points to the leaf.
+func setupCgroups() (string, error) {
+	cgroupOnce.Do(func() {
+		cgroupBase, cgroupErr = findCgroup()
+		if cgroupErr != nil {
+			return
+		}
+		cgroupErr = delegateCgroup(cgroupBase)
+	})
+	return cgroupBase, cgroupErr
+}
+
+func findCgroup() (string, error) {
+	raw, err := ioutil.ReadFile(procSelfCgroup)
+	if err != nil {
+		return "", err
+	}
+	scanner := bufio.NewScanner(bytes.NewReader(raw))
+	for scanner.Scan() {
+		line := scanner.Text()
+		if strings.HasPrefix(line, "0::") {
+			base := filepath.Join(cgroupRoot, strings.TrimPrefix(line, "0::"))
+			if _, err := os.Stat(filepath.Join(base, "cgroup.controllers")); err != nil {
+				return "", errors.New("cgroup v2 controllers are not available")
+			}
+			return base, nil
+		}
+	}
+	return "", errors.New("cgroup v2 hierarchy is not mounted")
+}
+
+func delegateCgroup(base string) error {
+	leaf := filepath.Join(base, cgroupLeaf)
+	if err := os.Mkdir(leaf, 0755); err != nil && !os.IsExist(err) {
+		return err
+	}
+	raw, err := ioutil.ReadFile(filepath.Join(base, "cgroup.procs"))
+	if err != nil {
+		return err
+	}
+	for _, pid := range strings.Fields(string(raw)) {
+		owned, err := isOwnProcess(pid)
+		if os.IsNotExist(err) {
+			continue // the process has exited
+		}
+		if !owned {
+			return fmt.Errorf("process %s in cgroup %s wasn't started by ContainerPilot",
+				pid, base)
+		}
+		// processes may exit before we move them, so we ignore errors
+		writeCgroupFile(leaf, "cgroup.procs", pid)
+	}
+	raw, err = ioutil.ReadFile(filepath.Join(base, "cgroup.controllers"))
+	if err != nil {
+		return err
+	}
+	var enable []string
+	for _, controller := range strings.Fields(string(raw)) {
+		if controller == "memory" || controller == "cpu" {
+			enable = append(enable, "+"+controller)
+		}
+	}
+	if len(enable) == 0 {
+		return errors.New("memory and cpu controllers have not been delegated")
+	}
+	return writeCgroupFile(base, "cgroup.subtree_control", strings.Join(enable, " "))
+}
+
+// isOwnProcess returns true if the process is ContainerPilot, one of its
+// descendants, or the PID 1 supervisor that started it
+func isOwnProcess(pid string) (bool, error) {
+	self := os.Getpid()
+	id, err := strconv.Atoi(pid)
+	if err != nil {
+		return false, err
+	}
+	if id == 1 && os.Getppid() == 1 {
+		return true, nil
+	}
+	for id > 1 {
+		if id == self {
+			return true, nil
+		}
+		stat, err := readProcStat(filepath.Join(procRoot, strconv.Itoa(id)))
+		if err != nil {
+			return false, err
+		}
+		id = stat.ppid
+	}
+	return false, nil
+}
+
+func writeCgroupFile(dir, name, value string) error {
+	err := ioutil.WriteFile(filepath.Join(dir, name), []byte(value), 0644)
+	if err != nil {
+		return fmt.Errorf("unable to write %s: %v", name, err)
+	}
+	return nil
+}
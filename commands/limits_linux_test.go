@@ -0,0 +1,123 @@
+package commands
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCommandRunWithRlimits(t *testing.T) {
+	nofile, core := uint64(64), uint64(0)
+	// the limits are read by a child of the process, which has to
+	// inherit them
+	cmd, _ := NewCommand([]string{"sh", "-c", "cat /proc/self/limits"},
+		time.Duration(0), nil)
+	cmd.Limits = &Limits{NoFile: &nofile, Core: &core}
+	cmd.CaptureOutput = true
+	if err := cmd.Limits.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	runtestCommandRun(cmd)
+	state := cmd.State()
+	assert.Equal(t, 0, state.ExitCode, state.Output)
+	assert.Regexp(t, `Max open files\s+64\s+64\s`, state.Output)
+	assert.Regexp(t, `Max core file size\s+0\s+0\s`, state.Output)
+
+	err := setRlimits("nofile=64,memory=1")
+	assert.EqualError(t, err, "invalid rlimit 'memory=1'")
+}
+
+func TestLimitsCgroup(t *testing.T) {
+	root := setupTestCgroup(t)
+	limits := &Limits{Memory: "1K", CPUWeight: 200, Cgroup: "app"}
+	if err := limits.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	cmd := exec.Command("true")
+	cmd.SysProcAttr = &syscall.SysProcAttr{}
+	started, err := limits.prepare(cmd)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := started(12345); err != nil {
+		t.Fatal(err)
+	}
+	read := func(path ...string) string {
+		raw, _ := ioutil.ReadFile(filepath.Join(append([]string{root}, path...)...))
+		return string(raw)
+	}
+	assert.Equal(t, strconv.Itoa(os.Getpid()), read(cgroupLeaf, "cgroup.procs"),
+		"expected ContainerPilot to be moved into a leaf cgroup")
+	assert.Equal(t, "+cpu +memory", read("cgroup.subtree_control"))
+	assert.Equal(t, "1024", read("job-app", "memory.max"))
+	assert.Equal(t, "200", read("job-app", "cpu.weight"))
+	if startsInCgroup {
+		attr := reflect.ValueOf(cmd.SysProcAttr).Elem()
+		assert.True(t, attr.FieldByName("UseCgroupFD").Bool(),
+			"expected the process to start in its cgroup")
+		assert.Equal(t, "", read("job-app", "cgroup.procs"))
+	} else {
+		assert.Equal(t, "12345", read("job-app", "cgroup.procs"))
+	}
+
+	ioutil.WriteFile(filepath.Join(root, "job-app", "memory.current"), []byte("2048\n"), 0644)
+	ioutil.WriteFile(filepath.Join(root, "job-app", "cpu.stat"),
+		[]byte("usage_usec 1500000\nuser_usec 1000000\n"), 0644)
+	usage, err := limits.Usage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, &Usage{MemoryBytes: 2048, CPUSeconds: 1.5}, usage)
+
+	os.Remove(filepath.Join(root, "job-app", "memory.current"))
+	os.Remove(filepath.Join(root, "job-app", "cpu.stat"))
+	os.Remove(filepath.Join(root, "job-app", "memory.max"))
+	os.Remove(filepath.Join(root, "job-app", "cpu.weight"))
+	assert.NoError(t, limits.RemoveCgroup())
+	assert.NoDirExists(t, filepath.Join(root, "job-app"))
+}
+
+func TestLimitsCgroupForeignProcess(t *testing.T) {
+	if os.Getppid() == 1 {
+		t.Skip("our parent is treated as the ContainerPilot supervisor")
+	}
+	root := setupTestCgroup(t)
+	ioutil.WriteFile(filepath.Join(root, "cgroup.procs"),
+		[]byte(fmt.Sprintf("%d\n%d\n", os.Getpid(), os.Getppid())), 0644)
+	limits := &Limits{Memory: "1K", Cgroup: "app"}
+	limits.Validate()
+	_, err := limits.prepare(exec.Command("true"))
+	assert.EqualError(t, err, fmt.Sprintf("unable to set up cgroup: process %d "+
+		"in cgroup %s wasn't started by ContainerPilot", os.Getppid(), root))
+}
+
+// setupTestCgroup creates a fake cgroup v2 hierarchy with delegated cpu
+// and memory controllers
+func setupTestCgroup(t *testing.T) string {
+	dir := t.TempDir()
+	root := filepath.Join(dir, "cgroup")
+	os.Mkdir(root, 0755)
+	ioutil.WriteFile(filepath.Join(dir, "self"), []byte("0::/\n"), 0644)
+	ioutil.WriteFile(filepath.Join(root, "cgroup.controllers"), []byte("cpuset cpu io memory pids\n"), 0644)
+	ioutil.WriteFile(filepath.Join(root, "cgroup.procs"),
+		[]byte(strconv.Itoa(os.Getpid())+"\n"), 0644)
+
+	origRoot, origSelf := cgroupRoot, procSelfCgroup
+	cgroupRoot, procSelfCgroup = root, filepath.Join(dir, "self")
+	cgroupOnce = sync.Once{}
+	t.Cleanup(func() {
+		cgroupRoot, procSelfCgroup = origRoot, origSelf
+		cgroupOnce = sync.Once{}
+	})
+	return root
+}
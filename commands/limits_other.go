@@ -0,0 +1,30 @@
+//go:build !linux
+// +build !linux
+
+package commands
+
+import (
+	"errors"
+	"os/exec"
+)
+
+// prepare is only supported on Linux, where we have cgroups
+func (l *Limits) prepare(cmd *exec.Cmd) (func(pid int) error, error) {
+	if l.usesRlimits() || l.usesCgroup() {
+		return nil, errors.New("resource limits are only supported on Linux")
+	}
+	return func(pid int) error { return nil }, nil
+}
+
+// ExecWithRlimits does nothing, because rlimits are only supported on Linux
+func ExecWithRlimits(args []string) {}
+
+// RemoveCgroup is only supported on Linux
+func (l *Limits) RemoveCgroup() error {
+	return nil
+}
+
+// Usage is only supported on Linux
+func (l *Limits) Usage() (*Usage, error) {
+	return nil, nil
+}
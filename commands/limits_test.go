@@ -0,0 +1,48 @@
+package commands
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLimitsValidate(t *testing.T) {
+	limits := &Limits{Memory: "256M", CPUWeight: 50, Cgroup: "app"}
+	if err := limits.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, uint64(256<<20), limits.memory)
+
+	expectErr := func(limits *Limits, errMsg string) {
+		t.Helper()
+		assert.EqualError(t, limits.Validate(), errMsg)
+	}
+	expectErr(&Limits{Memory: "lots", Cgroup: "app"},
+		"memory 'lots' is invalid: expected a number of bytes with an optional K, M, G, or T suffix")
+	expectErr(&Limits{Memory: "0", Cgroup: "app"},
+		"memory '0' is invalid: must be greater than zero")
+	expectErr(&Limits{CPUWeight: 10001, Cgroup: "app"},
+		"cpuWeight '10001' must be between 1 and 10000")
+	expectErr(&Limits{CPUWeight: 100},
+		"memory and cpuWeight require a cgroup name")
+}
+
+func TestParseSize(t *testing.T) {
+	tests := []struct {
+		input  string
+		output uint64
+	}{
+		{"1024", 1024},
+		{"1k", 1024},
+		{"512M", 512 << 20},
+		{"2GB", 2 << 30},
+		{"1T", 1 << 40},
+	}
+	for _, test := range tests {
+		t.Run(test.input, func(t *testing.T) {
+			size, err := parseSize(test.input)
+			assert.NoError(t, err)
+			assert.Equal(t, test.output, size)
+		})
+	}
+}
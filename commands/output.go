@@ -0,0 +1,32 @@
+package commands
+
+import "bytes"
+
+// maxOutputSize is the most output we keep from a process, which matches
+// the default limit the Consul agent puts on the output of a check
+const maxOutputSize = 4096
+
+// outputBuffer keeps the first maxOutputSize bytes written to it and
+// silently discards the rest, so that a chatty process can't make us
+// hold onto its whole output
+type outputBuffer struct {
+	buf bytes.Buffer
+}
+
+// Write satisfies io.Writer, and never fails so that the process
+// writing to it isn't interrupted once the buffer is full
+func (b *outputBuffer) Write(p []byte) (int, error) {
+	if remain := maxOutputSize - b.buf.Len(); remain > 0 {
+		if len(p) > remain {
+			b.buf.Write(p[:remain])
+		} else {
+			b.buf.Write(p)
+		}
+	}
+	return len(p), nil
+}
+
+// String returns the output that was kept
+func (b *outputBuffer) String() string {
+	return b.buf.String()
+}
@@ -0,0 +1,84 @@
+//go:build linux
+// +build linux
+
+package commands
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+const (
+	procRoot = "/proc"
+
+	// clockTicks is the number of clock ticks per second for utime and
+	// stime in /proc/<pid>/stat. This is USER_HZ, which is 100 on every
+	// architecture Linux supports.
+	clockTicks = 100
+)
+
+// ProcessGroupUsage returns the resource usage of every process in the
+// process group of the Command's running process, or nil if the Command
+// isn't running
+func (c *Command) ProcessGroupUsage() (*ProcessUsage, error) {
+	pgid := c.State().Pid
+	if pgid == 0 {
+		return nil, nil
+	}
+	entries, err := ioutil.ReadDir(procRoot)
+	if err != nil {
+		return nil, err
+	}
+	usage := &ProcessUsage{}
+	pageSize := uint64(os.Getpagesize())
+	for _, entry := range entries {
+		if _, err := strconv.Atoi(entry.Name()); err != nil {
+			continue // not a process
+		}
+		dir := filepath.Join(procRoot, entry.Name())
+		stat, err := readProcStat(dir)
+		if err != nil || stat.pgrp != pgid {
+			continue // processes can exit while we're reading them
+		}
+		usage.CPUSeconds += float64(stat.utime+stat.stime) / clockTicks
+		usage.RSSBytes += stat.rss * pageSize
+		if fds, err := ioutil.ReadDir(filepath.Join(dir, "fd")); err == nil {
+			usage.OpenFDs += len(fds)
+		}
+	}
+	return usage, nil
+}
+
+type procStat struct {
+	ppid  int
+	pgrp  int
+	utime uint64
+	stime uint64
+	rss   uint64
+}
+
+// readProcStat parses the fields we need from /proc/<pid>/stat. See
+// proc(5) for the format; the command name may contain spaces or
+// parens, so we parse the fields after the last closing paren.
+func readProcStat(dir string) (*procStat, error) {
+	raw, err := ioutil.ReadFile(filepath.Join(dir, "stat"))
+	if err != nil {
+		return nil, err
+	}
+	data := string(raw)
+	// fields after the command name start at field 3 (state)
+	fields := strings.Fields(data[strings.LastIndex(data, ")")+1:])
+	if len(fields) < 22 {
+		return nil, os.ErrInvalid
+	}
+	stat := &procStat{}
+	stat.ppid, _ = strconv.Atoi(fields[1])                // field 4
+	stat.pgrp, _ = strconv.Atoi(fields[2])                // field 5
+	stat.utime, _ = strconv.ParseUint(fields[11], 10, 64) // field 14
+	stat.stime, _ = strconv.ParseUint(fields[12], 10, 64) // field 15
+	stat.rss, _ = strconv.ParseUint(fields[21], 10, 64)   // field 24
+	return stat, nil
+}
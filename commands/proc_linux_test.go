@@ -0,0 +1,33 @@
+package commands
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/asokolov365/containerpilot/events"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestProcessGroupUsage(t *testing.T) {
+	cmd, _ := NewCommand("./testdata/test.sh sleepStuff",
+		time.Duration(300*time.Millisecond), nil)
+	usage, err := cmd.ProcessGroupUsage()
+	assert.Nil(t, usage, "expected no usage before the process starts")
+	assert.NoError(t, err)
+
+	bus := events.NewEventBus()
+	ctx, cancel := context.WithCancel(context.Background())
+	cmd.Run(ctx, bus)
+	time.Sleep(100 * time.Millisecond)
+	usage, err = cmd.ProcessGroupUsage()
+	time.Sleep(300 * time.Millisecond) // wait for the timeout to kill it
+	cancel()
+	bus.Wait()
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.True(t, usage.RSSBytes > 0, "expected resident memory")
+	// test.sh and its sleep child both have stdin, stdout, and stderr open
+	assert.True(t, usage.OpenFDs >= 6, "expected open fds for process group")
+}
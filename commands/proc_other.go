@@ -0,0 +1,9 @@
+//go:build !linux
+// +build !linux
+
+package commands
+
+// ProcessGroupUsage is only supported on Linux, where we have procfs
+func (c *Command) ProcessGroupUsage() (*ProcessUsage, error) {
+	return nil, nil
+}
@@ -7,6 +7,8 @@ import (
 	"errors"
 	"fmt"
 	"os"
+	"reflect"
+	"sort"
 	"strings"
 
 	"github.com/flynn/json5"
@@ -14,23 +16,28 @@ import (
 	"github.com/asokolov365/containerpilot/config/decode"
 	"github.com/asokolov365/containerpilot/config/logger"
 	"github.com/asokolov365/containerpilot/config/template"
+	"github.com/asokolov365/containerpilot/config/validation"
 	"github.com/asokolov365/containerpilot/control"
 	"github.com/asokolov365/containerpilot/discovery"
 	"github.com/asokolov365/containerpilot/jobs"
+	"github.com/asokolov365/containerpilot/signals"
 	"github.com/asokolov365/containerpilot/surveillee"
 	"github.com/asokolov365/containerpilot/telemetry"
 	"github.com/asokolov365/containerpilot/watches"
 )
 
 type rawConfig struct {
-	consul      interface{}
-	vault       interface{}
-	logConfig   *logger.Config
-	stopTimeout int
-	jobs        []interface{}
-	watches     []interface{}
-	telemetry   interface{}
-	control     interface{}
+	consul         interface{}
+	etcd           interface{}
+	vault          interface{}
+	logConfig      *logger.Config
+	stopTimeout    int
+	reloadOnChange bool
+	jobs           []interface{}
+	watches        []interface{}
+	telemetry      interface{}
+	control        interface{}
+	signals        []interface{}
 }
 
 // Config contains the parsed config elements
@@ -42,6 +49,18 @@ type Config struct {
 	Watches     []*watches.Config
 	Telemetry   *telemetry.Config
 	Control     *control.Config
+	Signals     []*signals.Config
+
+	// ReloadOnChange reloads the config whenever the config file changes
+	ReloadOnChange bool
+
+	// raw backend configs, so that we can tell if they change on reload
+	consul interface{}
+	etcd   interface{}
+	vault  interface{}
+
+	// paths and globs of the config files this config was loaded from
+	patterns []string
 }
 
 const (
@@ -57,6 +76,49 @@ func (cfg *Config) InitLogging() error {
 	return nil
 }
 
+// SameBackends returns true if the other Config has the same discovery
+// and secrets backends as this one, so that the services registered with
+// them and any watches on them can be kept across a reload
+func (cfg *Config) SameBackends(other *Config) bool {
+	return reflect.DeepEqual(cfg.consul, other.consul) &&
+		reflect.DeepEqual(cfg.etcd, other.etcd) &&
+		reflect.DeepEqual(cfg.vault, other.vault)
+}
+
+// WatchedFiles returns the config files that this config was loaded
+// from, including any included files and any files that have since
+// been added to a config directory or matched by an include glob
+func (cfg *Config) WatchedFiles() []string {
+	return expandPatterns(cfg.patterns)
+}
+
+// Preflight checks that the discovery and secrets backends are reachable,
+// so that we don't tear down the running jobs on a reload for a config
+// that can't work. Backends configured the same way as in the previous
+// Config (if any) are already in use and aren't checked again.
+func (cfg *Config) Preflight(prev *Config) error {
+	sameDiscovery := prev != nil &&
+		reflect.DeepEqual(cfg.consul, prev.consul) &&
+		reflect.DeepEqual(cfg.etcd, prev.etcd)
+	sameVault := prev != nil && reflect.DeepEqual(cfg.vault, prev.vault)
+	if consul, ok := cfg.Surveillees.Discovery.(*discovery.Consul); ok && consul != nil && !sameDiscovery {
+		if err := consul.Ping(); err != nil {
+			return fmt.Errorf("unable to reach consul: %v", err)
+		}
+	}
+	if etcd, ok := cfg.Surveillees.Discovery.(*discovery.Etcd); ok && etcd != nil && !sameDiscovery {
+		if err := etcd.Ping(); err != nil {
+			return fmt.Errorf("unable to reach etcd: %v", err)
+		}
+	}
+	if vault, ok := cfg.Surveillees.SecretStorage.(*surveillee.Vault); ok && vault != nil && !sameVault {
+		if err := vault.Ping(); err != nil {
+			return fmt.Errorf("unable to reach vault: %v", err)
+		}
+	}
+	return nil
+}
+
 // parseStopTimeout makes sure we have a safe default
 func (cfg *rawConfig) parseStopTimeout() (int, error) {
 	if cfg.stopTimeout == 0 {
@@ -66,14 +128,47 @@ func (cfg *rawConfig) parseStopTimeout() (int, error) {
 }
 
 // RenderConfig renders the templated config in configFlag to renderFlag.
-func RenderConfig(configFlag, renderFlag string) error {
-	configData, err := loadConfigFile(configFlag)
-	if err != nil {
-		return err
+// If configFlag is a config directory then each of its files is rendered
+// in turn. If showVars is set, the environment variables used by the
+// templates are printed to stderr so that they don't end up in the
+// rendered config.
+func RenderConfig(configFlag, renderFlag string, showVars bool) error {
+	var renderedConfig []byte
+	paths := []string{configFlag}
+	if info, err := os.Stat(configFlag); err == nil && info.IsDir() {
+		paths = configDirFiles(configFlag)
 	}
-	renderedConfig, err := renderConfigTemplate(configData)
-	if err != nil {
-		return err
+	strict := strictTemplates
+	var undefined []string
+	for _, path := range paths {
+		configData, err := loadConfigFile(path)
+		if err != nil {
+			return err
+		}
+		rendered, tmpl, err := renderTemplate(configData)
+		if err != nil {
+			return err
+		}
+		prefix := ""
+		if path != configFlag {
+			prefix = path + ": "
+			rendered = append([]byte(fmt.Sprintf("// %s\n", path)), rendered...)
+		}
+		if showVars {
+			printTemplateVars(prefix, tmpl)
+		}
+		if err := tmpl.CheckDefined(); err != nil {
+			undefined = append(undefined, prefix+err.Error())
+		}
+		// a file that can't be parsed can still be rendered
+		if configMap, err := unmarshalFormat(rendered, formatOf(path)); err == nil {
+			strict = strict || isTemplateStrict(configMap)
+		}
+		renderedConfig = append(renderedConfig, rendered...)
+	}
+	if strict && len(undefined) > 0 {
+		return fmt.Errorf("could not apply template to config: %s",
+			strings.Join(undefined, "\n"))
 	}
 
 	// Save the rendered template, either to stdout or to file
@@ -89,20 +184,34 @@ func RenderConfig(configFlag, renderFlag string) error {
 	return nil
 }
 
-// LoadConfig loads, parses, and validates the configuration
+// printTemplateVars prints every use of an environment variable in the
+// template, and whether it's set
+func printTemplateVars(prefix string, tmpl *template.Template) {
+	for _, ref := range tmpl.References() {
+		status := ""
+		if _, ok := tmpl.Env[ref.Name]; !ok {
+			status = ": not set"
+		}
+		fmt.Fprintf(os.Stderr, "%s%s%s\n", prefix, ref, status)
+	}
+}
+
+// LoadConfig loads, parses, and validates the configuration. If the
+// configuration is invalid, the error is validation.Errors with every
+// problem found and the line it was found on.
 func LoadConfig(configFlag string) (*Config, error) {
-	configData, err := loadConfigFile(configFlag)
+	configMap, source, err := loadConfigMap(configFlag)
 	if err != nil {
 		return nil, err
 	}
-	renderedConfig, err := renderConfigTemplate(configData)
-	if err != nil {
-		return nil, err
+	config, err := newConfigFromMap(configMap)
+	if errs, ok := err.(validation.Errors); ok {
+		errs.Locate(source.lookup)
 	}
-	config, err := newConfig(renderedConfig)
 	if err != nil {
 		return nil, err
 	}
+	config.patterns = source.patterns
 	return config, nil
 }
 
@@ -118,11 +227,40 @@ func loadConfigFile(configFlag string) ([]byte, error) {
 }
 
 func renderConfigTemplate(configData []byte) ([]byte, error) {
-	templ, err := template.Apply(configData)
+	rendered, _, err := renderTemplate(configData)
+	return rendered, err
+}
+
+// renderTemplate renders the config template, and returns the template
+// so that we can check the environment variables it used
+func renderTemplate(configData []byte) ([]byte, *template.Template, error) {
+	tmpl, err := template.NewTemplate(configData)
 	if err != nil {
-		err = fmt.Errorf("could not apply template to config: %v", err)
+		return nil, nil, fmt.Errorf("could not apply template to config: %v", err)
 	}
-	return templ, err
+	rendered, err := tmpl.Execute()
+	if err != nil {
+		return nil, nil, fmt.Errorf("could not apply template to config: %v", err)
+	}
+	return rendered, tmpl, nil
+}
+
+// strictTemplates is set by SetTemplateStrict
+var strictTemplates bool
+
+// SetTemplateStrict makes loading or rendering the config fail if its
+// templates use any environment variables that aren't set, as though
+// the config set `templateStrict: true`
+func SetTemplateStrict(strict bool) {
+	strictTemplates = strict
+}
+
+// isTemplateStrict returns true if the config map sets templateStrict.
+// An invalid value is reported by validation instead.
+func isTemplateStrict(configMap map[string]interface{}) bool {
+	var strict bool
+	decode.ToStruct(configMap["templateStrict"], &strict)
+	return strict
 }
 
 // newConfig unmarshals the textual configuration data into the
@@ -132,29 +270,51 @@ func newConfig(configData []byte) (*Config, error) {
 	if err != nil {
 		return nil, err
 	}
+	return newConfigFromMap(configMap)
+}
 
-	raw := &rawConfig{}
-	if err = validateConfigByDecode(configMap, raw); err != nil {
-		return nil, err
+// newConfigFromMap validates the parsed configuration map and builds the
+// Config struct from it. Every section is validated even if an earlier
+// one has problems, so that they can all be reported together as
+// validation.Errors.
+func newConfigFromMap(configMap map[string]interface{}) (*Config, error) {
+	// the sections can't be validated until they can be decoded
+	if errs := ValidateSchema(configMap); len(errs) > 0 {
+		return nil, errs
 	}
-	cfg := &Config{}
+	raw := &rawConfig{}
+	errs := validateConfigByDecode(configMap, raw)
+	cfg := &Config{consul: raw.consul, etcd: raw.etcd, vault: raw.vault}
 
 	// Surveillees
-	var disc *discovery.Consul
-	if raw.consul != nil {
-		disc, err = discovery.NewConsul(raw.consul)
-		if err != nil {
-			return nil, err
+	var disc discovery.Backend
+	var err error
+	backendErrs := len(errs)
+	switch {
+	case raw.consul != nil && raw.etcd != nil:
+		errs.Addf("etcd", "can't be set along with 'consul'")
+	case raw.consul != nil:
+		var consul *discovery.Consul
+		consul, err = discovery.NewConsul(raw.consul)
+		errs.Add("consul", err)
+		if err == nil {
+			disc = consul
+		}
+	case raw.etcd != nil:
+		var etcd *discovery.Etcd
+		etcd, err = discovery.NewEtcd(raw.etcd)
+		errs.Add("etcd", err)
+		if err == nil {
+			disc = etcd
 		}
 	}
 
 	var secretStorage *surveillee.Vault
 	if raw.vault != nil {
 		secretStorage, err = surveillee.NewVault(raw.vault)
-		if err != nil {
-			return nil, err
-		}
+		errs.Add("vault", err)
 	}
+	backendFailed := len(errs) > backendErrs
 	fileWatcher := surveillee.NewFileWatcher()
 
 	survSvcs := surveillee.NewServices(disc, fileWatcher, secretStorage)
@@ -164,41 +324,75 @@ func newConfig(configData []byte) (*Config, error) {
 	cfg.LogConfig = raw.logConfig
 
 	stopTimeout, err := raw.parseStopTimeout()
-	if err != nil {
-		return nil, err
-	}
+	errs.Add("stopTimeout", err)
 	cfg.StopTimeout = stopTimeout
+	cfg.ReloadOnChange = raw.reloadOnChange
 
 	controlConfig, err := control.NewConfig(raw.control)
-	if err != nil {
-		return nil, fmt.Errorf("unable to parse control: %v", err)
-	}
+	errs.Add("", err)
 	cfg.Control = controlConfig
 
 	jobConfigs, err := jobs.NewConfigs(raw.jobs, disc)
+	errs.Add("", err)
+	cfg.Jobs = jobConfigs
+	jobNames := make([]string, len(cfg.Jobs))
+	for i, job := range cfg.Jobs {
+		jobNames[i] = job.Name
+	}
 	if err != nil {
-		return nil, fmt.Errorf("unable to parse jobs: %v", err)
+		// we can still check the signals against the names of the jobs
+		jobNames = rawJobNames(raw.jobs)
 	}
-	cfg.Jobs = jobConfigs
 
-	watches, err := watches.NewConfigs(raw.watches, survSvcs)
-	if err != nil {
-		return nil, fmt.Errorf("unable to parse watches: %v", err)
+	// the watches on a backend that failed to configure would only
+	// report that it isn't configured
+	if !backendFailed {
+		watches, err := watches.NewConfigs(raw.watches, survSvcs)
+		errs.Add("", err)
+		cfg.Watches = watches
 	}
-	cfg.Watches = watches
 
 	telemetry, err := telemetry.NewConfig(raw.telemetry, disc)
-	if err != nil {
-		return nil, err
-	}
+	errs.Add("", err)
 	if telemetry != nil {
+		errs.Add("telemetry", telemetry.ValidateReady(jobNames))
+		// the health checks of jobs that aren't advertised only run
+		// if the jobs are checked by '/readyz'
+		for _, name := range telemetry.Ready {
+			for i, job := range cfg.Jobs {
+				if job.Name == name {
+					errs.Add(validation.Index("jobs", i), job.EnableHealthCheck())
+				}
+			}
+		}
 		cfg.Telemetry = telemetry
 		cfg.Jobs = append(cfg.Jobs, telemetry.JobConfig)
+		jobNames = append(jobNames, telemetry.JobConfig.Name)
 	}
 
+	signalConfigs, err := signals.NewConfigs(raw.signals, jobNames)
+	errs.Add("", err)
+	cfg.Signals = signalConfigs
+
+	if len(errs) > 0 {
+		return nil, errs
+	}
 	return cfg, nil
 }
 
+// rawJobNames returns the names of the jobs in the raw config
+func rawJobNames(rawJobs []interface{}) []string {
+	var names []string
+	for _, rawJob := range rawJobs {
+		if job, ok := rawJob.(map[string]interface{}); ok {
+			if name, ok := job["name"].(string); ok {
+				names = append(names, name)
+			}
+		}
+	}
+	return names
+}
+
 func unmarshalConfig(data []byte) (map[string]interface{}, error) {
 	var config map[string]interface{}
 	if err := json5.Unmarshal(data, &config); err != nil {
@@ -218,6 +412,31 @@ func newJSONparseError(js []byte, syntax *json5.SyntaxError) error {
 	return fmt.Errorf("parse error at line:col [%d:%d]: %s\n%s", line, col, syntax, err)
 }
 
+// newParseError formats an error at a line and column of the config in
+// the same way as newJSONparseError, for formats that report positions
+// by line rather than by offset
+func newParseError(data []byte, line, col int, msg string) error {
+	return fmt.Errorf("parse error at line:col [%d:%d]: %s\n%s",
+		line, col, msg, highlightLine(data, line, col))
+}
+
+func highlightLine(data []byte, line, col int) string {
+	lines := strings.Split(string(data), "\n")
+	if line < 1 || line > len(lines) {
+		return ""
+	}
+	prevLine := ""
+	if line > 1 {
+		prevLine = fmt.Sprintf("%5d: %s\n", line-1, lines[line-2])
+	}
+	thisLine := fmt.Sprintf("%5d: %s\n", line, lines[line-1])
+	highlight := ""
+	if count := 7 + col - 1; count > 0 {
+		highlight = fmt.Sprintf("%s^", strings.Repeat("-", count))
+	}
+	return fmt.Sprintf("%s%s%s", prevLine, thisLine, highlight)
+}
+
 func highlightError(data []byte, pos int64) (int, int, string) {
 	prevLine := ""
 	thisLine := ""
@@ -252,38 +471,58 @@ func highlightError(data []byte, pos int64) (int, int, string) {
 // We can't use mapstructure to decode our config map since we want the values
 // to also be raw interface{} types. mapstructure can only decode
 // into concrete structs and primitives
-func validateConfigByDecode(configMap map[string]interface{}, result *rawConfig) error {
+func validateConfigByDecode(configMap map[string]interface{}, result *rawConfig) validation.Errors {
+	var errs validation.Errors
 	var logConfig logger.Config
 	var stopTimeout int
+	var reloadOnChange, templateStrict bool
 	if err := decode.ToStruct(configMap["logging"], &logConfig); err != nil {
-		return err
+		errs = append(errs, validation.FromDecode("logging", err)...)
 	}
 	if err := decode.ToStruct(configMap["stopTimeout"], &stopTimeout); err != nil {
-		return err
+		errs = append(errs, validation.FromDecode("stopTimeout", err)...)
+	}
+	if err := decode.ToStruct(configMap["reloadOnChange"], &reloadOnChange); err != nil {
+		errs = append(errs, validation.FromDecode("reloadOnChange", err)...)
+	}
+	if err := decode.ToStruct(configMap["templateStrict"], &templateStrict); err != nil {
+		errs = append(errs, validation.FromDecode("templateStrict", err)...)
 	}
 	result.consul = configMap["consul"]
+	result.etcd = configMap["etcd"]
 	result.vault = configMap["vault"]
 	result.stopTimeout = stopTimeout
+	result.reloadOnChange = reloadOnChange
 	result.logConfig = &logConfig
 	result.control = configMap["control"]
 	result.jobs = decode.ToSlice(configMap["jobs"])
 	result.watches = decode.ToSlice(configMap["watches"])
 	result.telemetry = configMap["telemetry"]
+	result.signals = decode.ToSlice(configMap["signals"])
 
-	delete(configMap, "consul")
-	delete(configMap, "vault")
-	delete(configMap, "logging")
-	delete(configMap, "control")
-	delete(configMap, "stopTimeout")
-	delete(configMap, "jobs")
-	delete(configMap, "watches")
-	delete(configMap, "telemetry")
 	var unused []string
 	for key := range configMap {
-		unused = append(unused, key)
+		switch key {
+		case "consul", "etcd", "vault", "logging", "control", "stopTimeout",
+			"reloadOnChange", "templateStrict", "jobs", "watches", "telemetry",
+			"signals":
+		default:
+			unused = append(unused, key)
+		}
 	}
-	if len(unused) > 0 {
-		return fmt.Errorf("unknown config keys: %v", unused)
+	sort.Strings(unused) // for stable error messages
+	for _, key := range unused {
+		errs.Addf(key, "is not a known config key")
 	}
-	return nil
+	return errs
+}
+
+// ValidateConfig loads the configuration and checks it against the
+// schema and the validation of every section, the same way as
+// LoadConfig, but without connecting to the discovery or secrets
+// backends. It returns validation.Errors with all the problems it
+// finds and their lines, rather than stopping at the first one.
+func ValidateConfig(configFlag string) error {
+	_, err := LoadConfig(configFlag)
+	return err
 }
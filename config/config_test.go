@@ -1,12 +1,15 @@
 package config
 
 import (
+	"fmt"
 	"io/ioutil"
 	"os"
 	"path/filepath"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
+
+	"github.com/asokolov365/containerpilot/discovery"
 )
 
 /*
@@ -173,14 +176,422 @@ func TestCustomConfigControl(t *testing.T) {
 		"config for control.socket")
 }
 
+func TestConfigReloadOnChange(t *testing.T) {
+	cfg, err := newConfig([]byte(`{"reloadOnChange": true}`))
+	if err != nil {
+		t.Fatalf("unexpected error in newConfig: %v", err)
+	}
+	assert.True(t, cfg.ReloadOnChange)
+
+	cfg, err = newConfig([]byte(`{}`))
+	if err != nil {
+		t.Fatalf("unexpected error in newConfig: %v", err)
+	}
+	assert.False(t, cfg.ReloadOnChange, "expected reloadOnChange to be opt-in")
+
+	_, err = newConfig([]byte(`{"reloadOnChange": "maybe"}`))
+	assert.Error(t, err, "expected error for invalid reloadOnChange")
+}
+
+func TestConfigSameBackends(t *testing.T) {
+	parse := func(data string) *Config {
+		cfg, err := newConfig([]byte(data))
+		if err != nil {
+			t.Fatalf("unexpected error in newConfig: %v", err)
+		}
+		return cfg
+	}
+	cfg := parse(`{"consul": "consul:8500", "jobs": [{name: "a", exec: "true"}]}`)
+	assert.True(t, cfg.SameBackends(
+		parse(`{"consul": "consul:8500", "jobs": [{name: "b", exec: "true"}]}`)),
+		"expected job changes to keep the same backends")
+	assert.False(t, cfg.SameBackends(parse(`{"consul": "other:8500"}`)),
+		"expected consul address change to change backends")
+	assert.False(t, cfg.SameBackends(parse(`{}`)),
+		"expected removing consul to change backends")
+	assert.False(t, cfg.SameBackends(parse(`{"etcd": "etcd:2379"}`)),
+		"expected switching to etcd to change backends")
+}
+
+func TestConfigEtcd(t *testing.T) {
+	cfg, err := newConfig([]byte(`{
+	"etcd": {"endpoints": ["etcd-0:2379", "etcd-1:2379"], "prefix": "/app"}}`))
+	if err != nil {
+		t.Fatalf("unexpected error in newConfig: %v", err)
+	}
+	_, ok := cfg.Surveillees.Discovery.(*discovery.Etcd)
+	assert.True(t, ok, "expected etcd discovery backend")
+
+	_, err = newConfig([]byte(`{"consul": "consul:8500", "etcd": "etcd:2379"}`))
+	assert.EqualError(t, err, "etcd: can't be set along with 'consul'")
+
+	cfg, err = newConfig([]byte(`{"etcd": "127.0.0.1:1"}`))
+	if err != nil {
+		t.Fatalf("unexpected error in newConfig: %v", err)
+	}
+	err = cfg.Preflight(nil)
+	assert.Error(t, err, "expected unreachable etcd to fail preflight")
+	assert.Contains(t, err.Error(), "unable to reach etcd")
+}
+
+func TestConfigTelemetryReady(t *testing.T) {
+	_, err := newConfig([]byte(`{
+	"jobs": [{name: "app", exec: "true"}],
+	"telemetry": {"interfaces": ["lo", "lo0", "inet"], "ready": ["app", "db"]}}`))
+	assert.EqualError(t, err, "telemetry.ready[1]: 'db' is not a configured job")
+
+	// the health check of a job that isn't advertised only runs if
+	// the job is checked by '/readyz'
+	_, err = newConfig([]byte(`{
+	"jobs": [{name: "app", exec: "true", health: {exec: "true", ttl: 5}}],
+	"telemetry": {"interfaces": ["lo", "lo0", "inet"]}}`))
+	assert.NoError(t, err)
+	_, err = newConfig([]byte(`{
+	"jobs": [{name: "app", exec: "true", health: {exec: "true", ttl: 5}}],
+	"telemetry": {"interfaces": ["lo", "lo0", "inet"], "ready": ["app"]}}`))
+	assert.EqualError(t, err, "jobs[0].health.interval: must be > 0")
+}
+
+func TestConfigPreflight(t *testing.T) {
+	cfg, err := newConfig([]byte(`{"jobs": [{name: "a", exec: "true"}]}`))
+	if err != nil {
+		t.Fatalf("unexpected error in newConfig: %v", err)
+	}
+	assert.NoError(t, cfg.Preflight(nil), "expected no backends to pass preflight")
+
+	cfg, err = newConfig([]byte(`{"consul": "127.0.0.1:1"}`))
+	if err != nil {
+		t.Fatalf("unexpected error in newConfig: %v", err)
+	}
+	err = cfg.Preflight(nil)
+	assert.Error(t, err, "expected unreachable consul to fail preflight")
+	assert.Contains(t, err.Error(), "unable to reach consul")
+
+	// an unchanged backend is already in use and isn't checked again
+	assert.NoError(t, cfg.Preflight(cfg), "expected unchanged consul to skip preflight")
+}
+
+func TestConfigInclude(t *testing.T) {
+	cfg, err := LoadConfig("./testdata/include/base.json5")
+	if err != nil {
+		t.Fatalf("unexpected error in LoadConfig: %v", err)
+	}
+	assert.Equal(t, 10, cfg.StopTimeout)
+	jobNames := []string{}
+	for _, job := range cfg.Jobs {
+		jobNames = append(jobNames, job.Name)
+	}
+	assert.Equal(t, []string{"base", "app", "containerpilot"}, jobNames,
+		"expected included jobs to be appended")
+	assert.Equal(t, "watch.upstream", cfg.Watches[0].Name)
+	assert.Equal(t, 2, len(cfg.Telemetry.MetricConfigs),
+		"expected included telemetry metrics to be appended")
+	assert.Equal(t, []string{
+		"./testdata/include/base.json5",
+		"testdata/include/conf.d/app.json5",
+	}, cfg.WatchedFiles())
+}
+
+func TestConfigDirectory(t *testing.T) {
+	cfg, err := LoadConfig("./testdata/confdir")
+	if err != nil {
+		t.Fatalf("unexpected error in LoadConfig: %v", err)
+	}
+	assert.Equal(t, 2, len(cfg.Jobs))
+	assert.Equal(t, "base", cfg.Jobs[0].Name)
+	assert.Equal(t, "app", cfg.Jobs[1].Name)
+	assert.NotNil(t, cfg.Surveillees.Discovery)
+	assert.Equal(t, []string{
+		"testdata/confdir/00-base.json5",
+		"testdata/confdir/10-app.json",
+	}, cfg.WatchedFiles())
+
+	_, err = LoadConfig(t.TempDir())
+	assert.Error(t, err, "expected error for empty config directory")
+	assert.Contains(t, err.Error(), "no config files found in")
+}
+
+func TestConfigIncludeErrors(t *testing.T) {
+	testFunc := func(t *testing.T, files map[string]string) error {
+		dir := t.TempDir()
+		for name, data := range files {
+			if err := os.WriteFile(filepath.Join(dir, name), []byte(data), 0644); err != nil {
+				t.Fatal(err)
+			}
+		}
+		_, err := LoadConfig(dir)
+		return err
+	}
+	t.Run("duplicate job", func(t *testing.T) {
+		err := testFunc(t, map[string]string{
+			"a.json5": `{jobs: [{name: "app", exec: "a"}]}`,
+			"b.json5": `{jobs: [{name: "app", exec: "b"}]}`,
+		})
+		assert.Error(t, err)
+		assert.Regexp(t, "^duplicate job name 'app' in .*a.json5 and .*b.json5$", err.Error())
+	})
+	t.Run("duplicate key", func(t *testing.T) {
+		err := testFunc(t, map[string]string{
+			"a.json5": `{stopTimeout: 5}`,
+			"b.json5": `{stopTimeout: 10}`,
+		})
+		assert.Error(t, err)
+		assert.Regexp(t, "^stopTimeout is set in both .*a.json5 and .*b.json5$", err.Error())
+	})
+	t.Run("duplicate telemetry key", func(t *testing.T) {
+		err := testFunc(t, map[string]string{
+			"a.json5": `{telemetry: {port: 9090}}`,
+			"b.json5": `{telemetry: {port: 9091}}`,
+		})
+		assert.Error(t, err)
+		assert.Regexp(t, "^telemetry.port is set in both", err.Error())
+	})
+	t.Run("missing include", func(t *testing.T) {
+		err := testFunc(t, map[string]string{
+			"a.json5": `{include: ["missing.json5", "conf.d/*.json5"]}`,
+		})
+		assert.Error(t, err)
+		assert.Regexp(t, "included file .*missing.json5 not found$", err.Error())
+	})
+	t.Run("nested include", func(t *testing.T) {
+		err := testFunc(t, map[string]string{
+			"a.json5": `{include: ["b.inc"]}`,
+			"b.inc":   `{include: ["c.inc"]}`,
+			"c.inc":   `{}`,
+		})
+		assert.Error(t, err)
+		assert.Regexp(t, "b.inc: include is only allowed in the top-level config$", err.Error())
+	})
+	t.Run("parse error", func(t *testing.T) {
+		err := testFunc(t, map[string]string{
+			"a.json5": `{jobs: [}`,
+		})
+		assert.Error(t, err)
+		assert.Regexp(t, "a.json5: parse error at line:col", err.Error())
+	})
+}
+
+func TestConfigValidationErrors(t *testing.T) {
+	testFunc := func(t *testing.T, files map[string]string, configFlag string) error {
+		dir := t.TempDir()
+		for name, data := range files {
+			if err := os.WriteFile(filepath.Join(dir, name), []byte(data), 0644); err != nil {
+				t.Fatal(err)
+			}
+		}
+		_, err := LoadConfig(filepath.Join(dir, configFlag))
+		return err
+	}
+	t.Run("json5", func(t *testing.T) {
+		err := testFunc(t, map[string]string{"a.json5": `{
+	consul: "localhost:8500",
+	stopTimeout: 5,
+	jobs: [
+		{name: "app", exec: "/bin/app", port: 80,
+		 health: {exec: "/bin/check", ttl: 10}},
+		{name: "task", exec: "/bin/task", when: {interval: "0s"}},
+	],
+	signals: [{signal: "SIGHUP", action: "trigger", job: "nope"}],
+}`}, "a.json5")
+		assert.EqualError(t, err,
+			"jobs[0].health.interval: must be > 0 (line 6)\n"+
+				"jobs[1].when.interval: '0s' cannot be less than 1ms (line 7)\n"+
+				"signals[0].job: 'nope' is not a configured job (line 9)")
+	})
+	t.Run("schema", func(t *testing.T) {
+		// the schema is checked the same way as by -validate, before
+		// the sections are validated
+		err := testFunc(t, map[string]string{"a.json5": `{
+	stopTimeout: "soon",
+	jobs: [{name: "app", exec: "/bin/app", when: {interval: "0s"}}],
+	bogus: true,
+}`}, "a.json5")
+		assert.EqualError(t, err,
+			"bogus: is not a known config key (line 4)\n"+
+				"stopTimeout: must be an integer, not \"soon\" (line 2)")
+	})
+	t.Run("yaml", func(t *testing.T) {
+		err := testFunc(t, map[string]string{"a.yaml": `
+jobs:
+  - name: app
+    exec: /bin/app
+  - name: task
+    exec: /bin/task
+    restarts: sometimes
+`}, "a.yaml")
+		assert.EqualError(t, err,
+			"jobs[1].restarts: must be a non-negative integer, \"unlimited\", or \"never\" (line 7)")
+	})
+	t.Run("toml", func(t *testing.T) {
+		err := testFunc(t, map[string]string{"a.toml": `
+[[jobs]]
+name = "app"
+exec = "/bin/app"
+when = { source = "setup", once = "bogus" }
+`}, "a.toml")
+		assert.EqualError(t, err,
+			"jobs[0].when.once: bogus is not a valid event code (line 5)")
+	})
+	t.Run("included files", func(t *testing.T) {
+		err := testFunc(t, map[string]string{
+			"a.json5": `{
+	include: ["b.json5"],
+	jobs: [{name: "a", exec: "/bin/a"}]
+}`,
+			"b.json5": `{
+	jobs: [
+		{name: "b", exec: "/bin/b"},
+		{name: "c", exec: "/bin/c", timeout: "xx"}
+	]
+}`}, "a.json5")
+		assert.Error(t, err)
+		assert.Regexp(t, `^jobs\[2\]\.timeout: unable to parse 'xx': .* \(.*b\.json5:4\)$`,
+			err.Error())
+	})
+}
+
+func TestConfigTemplateStrict(t *testing.T) {
+	os.Setenv("TEST_TEMPLATE_STRICT", "app")
+	defer os.Unsetenv("TEST_TEMPLATE_STRICT")
+	testFunc := func(t *testing.T, files map[string]string, configFlag string) error {
+		dir := t.TempDir()
+		for name, data := range files {
+			if err := os.WriteFile(filepath.Join(dir, name), []byte(data), 0644); err != nil {
+				t.Fatal(err)
+			}
+		}
+		_, err := LoadConfig(filepath.Join(dir, configFlag))
+		return err
+	}
+	config := `{
+	jobs: [{name: "{{ .TEST_TEMPLATE_STRICT }}",
+	        exec: "/bin/app {{ .TEST_TEMPLATE_NOPE }} {{ env "TEST_TEMPLATE_OPT" }}"}],
+%s
+}`
+	t.Run("not strict", func(t *testing.T) {
+		err := testFunc(t, map[string]string{
+			"a.json5": fmt.Sprintf(config, "")}, "a.json5")
+		assert.NoError(t, err)
+	})
+	t.Run("config option", func(t *testing.T) {
+		err := testFunc(t, map[string]string{
+			"a.json5": fmt.Sprintf(config, "templateStrict: true")}, "a.json5")
+		assert.EqualError(t, err, "could not apply template to config: "+
+			"undefined environment variables: TEST_TEMPLATE_NOPE (line 3, col 29)")
+	})
+	t.Run("flag", func(t *testing.T) {
+		SetTemplateStrict(true)
+		defer SetTemplateStrict(false)
+		err := testFunc(t, map[string]string{
+			"a.json5": `{include: ["b.json5"]}`,
+			"b.json5": fmt.Sprintf(config, "")}, "a.json5")
+		assert.Error(t, err)
+		assert.Regexp(t, "^could not apply template to config: .*b.json5: "+
+			`undefined environment variables: TEST_TEMPLATE_NOPE \(line 3, col 29\)$`,
+			err.Error())
+	})
+}
+
+func TestConfigFormats(t *testing.T) {
+	testFunc := func(t *testing.T, path string) {
+		cfg, err := LoadConfig(path)
+		if err != nil {
+			t.Fatalf("unexpected error in LoadConfig: %v", err)
+		}
+		assert.Equal(t, 5, cfg.StopTimeout)
+		assert.Equal(t, "DEBUG", cfg.LogConfig.Level)
+		assert.Equal(t, 3, len(cfg.Jobs))
+		job := cfg.Jobs[0]
+		assert.Equal(t, "serviceA", job.Name)
+		assert.Equal(t, 8080, job.Port)
+		assert.Equal(t, []string{"tag1", "tag2"}, job.Tags)
+		assert.Equal(t, 19, job.Health.Heartbeat)
+		assert.Equal(t, []interface{}{"/bin/setup", "-v"}, cfg.Jobs[1].Exec)
+		assert.Equal(t, "watch.upstreamA", cfg.Watches[0].Name)
+		assert.Equal(t, 1, len(cfg.Telemetry.MetricConfigs))
+	}
+	t.Run("yaml", func(t *testing.T) { testFunc(t, "./testdata/test.yaml") })
+	t.Run("toml", func(t *testing.T) { testFunc(t, "./testdata/test.toml") })
+}
+
+func TestConfigSetFormat(t *testing.T) {
+	defer SetFormat("")
+	assert.Equal(t, formatJSON5, formatOf("containerpilot.json5"))
+	assert.Equal(t, formatJSON5, formatOf("containerpilot"))
+	assert.Equal(t, formatYAML, formatOf("containerpilot.YML"))
+	assert.Equal(t, formatTOML, formatOf("containerpilot.toml"))
+
+	assert.NoError(t, SetFormat("yaml"))
+	assert.Equal(t, formatYAML, formatOf("containerpilot.json5"))
+	assert.EqualError(t, SetFormat("xml"),
+		"unknown config format 'xml': must be one of json5, yaml, or toml")
+}
+
+func TestConfigFormatParseErrors(t *testing.T) {
+	t.Run("yaml", func(t *testing.T) {
+		_, err := unmarshalFormat([]byte("jobs:\n  - name: a\n    exec: [a\n"), formatYAML)
+		assert.Error(t, err)
+		assert.Regexp(t, `^parse error at line:col \[\d+:\d+\]: `, err.Error())
+	})
+	t.Run("yaml type", func(t *testing.T) {
+		_, err := unmarshalFormat([]byte("# jobs\n  - name: a\n"), formatYAML)
+		assert.Error(t, err)
+		assert.Regexp(t, `^parse error at line:col \[2:3\]: cannot unmarshal !!seq`, err.Error())
+	})
+	t.Run("toml", func(t *testing.T) {
+		_, err := unmarshalFormat([]byte("stopTimeout = 5\n  consul = \n"), formatTOML)
+		assert.EqualError(t, err, "parse error at line:col [2:12]: incomplete number\n"+
+			"    1: stopTimeout = 5\n"+
+			"    2:   consul = \n"+
+			"------------------^")
+	})
+}
+
+func TestConfigTOMLLines(t *testing.T) {
+	doc := `consul = "localhost:8500"
+logging.level = "DEBUG"
+
+[[jobs]]
+name = "app"
+health = { exec = "/bin/check", interval = 5 }
+
+[[jobs]]
+name = "setup"
+exec = [
+  "/bin/setup",
+  "-v",
+]
+
+[jobs.when]
+source = "app"
+`
+	assert.Equal(t, map[string]int{
+		"consul":                  1,
+		"logging.level":           2,
+		"jobs[0]":                 4,
+		"jobs[0].name":            5,
+		"jobs[0].health":          6,
+		"jobs[0].health.exec":     6,
+		"jobs[0].health.interval": 6,
+		"jobs[1]":                 8,
+		"jobs[1].name":            9,
+		"jobs[1].exec":            10,
+		"jobs[1].exec[0]":         11,
+		"jobs[1].exec[1]":         12,
+		"jobs[1].when":            15,
+		"jobs[1].when.source":     16,
+	}, lineIndex([]byte(doc), formatTOML))
+	assert.Nil(t, lineIndex([]byte("a = \n"), formatTOML))
+}
+
 func TestInvalidRenderConfigFileMissing(t *testing.T) {
-	err := RenderConfig("/xxxx", "-")
+	err := RenderConfig("/xxxx", "-", false)
 	assert.EqualError(t, err,
 		"could not read config file: open /xxxx: no such file or directory")
 }
 
 func TestInvalidRenderConfigOutputMissing(t *testing.T) {
-	err := RenderConfig("./testdata/test.json5", "./xxxx/xxxx")
+	err := RenderConfig("./testdata/test.json5", "./xxxx/xxxx", false)
 	assert.EqualError(t, err,
 		"could not write config file: open ./xxxx/xxxx: no such file or directory")
 }
@@ -189,7 +600,7 @@ func TestRenderConfigFileStdout(t *testing.T) {
 
 	// Render to file
 	defer os.Remove("testJSON.json")
-	if err := RenderConfig("./testdata/test.json5", "testJSON.json"); err != nil {
+	if err := RenderConfig("./testdata/test.json5", "testJSON.json", false); err != nil {
 		t.Fatalf("expected no error from renderConfigTemplate but got: %v", err)
 	}
 	if exists, err := fileExists("testJSON.json"); !exists || err != nil {
@@ -201,7 +612,7 @@ func TestRenderConfigFileStdout(t *testing.T) {
 	temp, _ := os.Create(fname)
 	old := os.Stdout
 	os.Stdout = temp
-	if err := RenderConfig("./testdata/test.json5", "-"); err != nil {
+	if err := RenderConfig("./testdata/test.json5", "-", false); err != nil {
 		t.Fatalf("expected no error from renderConfigTemplate but got: %v", err)
 	}
 	temp.Close()
@@ -247,7 +658,7 @@ func TestConfigWithVault(t *testing.T) {
 	os.Unsetenv("VAULT_TOKEN")
 	template, _ := renderConfigTemplate([]byte(testJSON))
 	_, err := newConfig(template)
-	assert.EqualError(t, err, "no vault token defined")
+	assert.EqualError(t, err, "vault: no vault token defined")
 
 	os.Setenv("VAULT_TOKEN", "myTestToken")
 	defer os.Unsetenv("VAULT_TOKEN")
@@ -275,7 +686,7 @@ func TestConfigWithOutVault(t *testing.T) {
 	template, _ := renderConfigTemplate([]byte(testJSON))
 	_, err := newConfig(template)
 	assert.EqualError(t, err,
-		"unable to parse watches: watch[secret/data/foo].source is vault but vault config is not defined")
+		"watches[0].source: is vault but vault config is not defined")
 }
 
 // ----------------------------------------------------
@@ -0,0 +1,158 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pelletier/go-toml/v2"
+	"gopkg.in/yaml.v3"
+)
+
+// config file formats
+const (
+	formatJSON5 = "json5"
+	formatYAML  = "yaml"
+	formatTOML  = "toml"
+)
+
+// forcedFormat is the format set by SetFormat, if any
+var forcedFormat string
+
+// SetFormat forces all config files to be parsed as JSON5, YAML, or TOML
+// rather than choosing the format from each file's extension. An empty
+// name restores choosing by extension.
+func SetFormat(name string) error {
+	name = strings.ToLower(name)
+	switch name {
+	case "", formatJSON5, formatYAML, formatTOML:
+		forcedFormat = name
+		return nil
+	case "json":
+		forcedFormat = formatJSON5
+		return nil
+	case "yml":
+		forcedFormat = formatYAML
+		return nil
+	}
+	return fmt.Errorf("unknown config format '%s': must be one of json5, yaml, or toml", name)
+}
+
+// formatOf returns the format of the config file at path. Anything that
+// isn't YAML or TOML is parsed as JSON5, which is a superset of JSON.
+func formatOf(path string) string {
+	if forcedFormat != "" {
+		return forcedFormat
+	}
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		return formatYAML
+	case ".toml":
+		return formatTOML
+	}
+	return formatJSON5
+}
+
+// unmarshalFormat parses the config data in the given format into the
+// same generic map we'd get from JSON5, so that every format is
+// validated the same way
+func unmarshalFormat(data []byte, format string) (map[string]interface{}, error) {
+	switch format {
+	case formatYAML:
+		return unmarshalYAML(data)
+	case formatTOML:
+		return unmarshalTOML(data)
+	}
+	return unmarshalConfig(data)
+}
+
+// yaml.v3 only reports the line of an error
+var yamlLinePattern = regexp.MustCompile(`^(?:yaml: )?line (\d+): (.*)$`)
+
+func unmarshalYAML(data []byte) (map[string]interface{}, error) {
+	var config map[string]interface{}
+	err := yaml.Unmarshal(data, &config)
+	if err != nil {
+		msg := err.Error()
+		if typeErr, ok := err.(*yaml.TypeError); ok && len(typeErr.Errors) > 0 {
+			msg = typeErr.Errors[0]
+		}
+		match := yamlLinePattern.FindStringSubmatch(msg)
+		if match == nil {
+			return nil, fmt.Errorf("could not parse configuration: %s", err)
+		}
+		line, _ := strconv.Atoi(match[1])
+		return nil, newParseError(data, line, firstColumn(data, line), match[2])
+	}
+	if config == nil {
+		config = map[string]interface{}{}
+	}
+	return normalize(config).(map[string]interface{}), nil
+}
+
+func unmarshalTOML(data []byte) (map[string]interface{}, error) {
+	var config map[string]interface{}
+	err := toml.Unmarshal(data, &config)
+	if err != nil {
+		var decodeErr *toml.DecodeError
+		if errors.As(err, &decodeErr) {
+			line, col := decodeErr.Position()
+			msg := strings.TrimPrefix(decodeErr.Error(), "toml: ")
+			return nil, newParseError(data, line, col, msg)
+		}
+		return nil, fmt.Errorf("could not parse configuration: %s", err)
+	}
+	if config == nil {
+		config = map[string]interface{}{}
+	}
+	return normalize(config).(map[string]interface{}), nil
+}
+
+// normalize converts the values decoded from YAML or TOML into the types
+// we get from JSON5: numbers are always float64 and map keys strings.
+func normalize(value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		for key, val := range v {
+			v[key] = normalize(val)
+		}
+		return v
+	case map[interface{}]interface{}:
+		result := make(map[string]interface{}, len(v))
+		for key, val := range v {
+			result[fmt.Sprint(key)] = normalize(val)
+		}
+		return result
+	case []interface{}:
+		for i, val := range v {
+			v[i] = normalize(val)
+		}
+		return v
+	case int:
+		return float64(v)
+	case int64:
+		return float64(v)
+	case uint64:
+		return float64(v)
+	case time.Time:
+		return v.Format(time.RFC3339Nano)
+	case toml.LocalDate, toml.LocalTime, toml.LocalDateTime:
+		return fmt.Sprint(v)
+	}
+	return value
+}
+
+// firstColumn returns the column of the first character on the line
+// that isn't whitespace
+func firstColumn(data []byte, line int) int {
+	lines := strings.Split(string(data), "\n")
+	if line < 1 || line > len(lines) {
+		return 1
+	}
+	text := lines[line-1]
+	return len(text) - len(strings.TrimLeft(text, " \t")) + 1
+}
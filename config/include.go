@@ -0,0 +1,395 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/asokolov365/containerpilot/config/decode"
+	"github.com/asokolov365/containerpilot/config/template"
+	"github.com/asokolov365/containerpilot/config/validation"
+)
+
+// configExtensions are the file extensions loaded from a config directory
+var configExtensions = []string{".json5", ".json", ".yaml", ".yml", ".toml"}
+
+// configSource tracks where the pieces of a merged config came from, so
+// that we can report conflicts and watch all the files for changes
+type configSource struct {
+	patterns  []string            // paths and globs of every file loaded
+	files     []string            // every file loaded
+	keys      map[string]string   // top-level keys to the file that set them
+	jobs      map[string]string   // job names to the file that defined them
+	watches   map[string]string   // watch names to the file that defined them
+	locations map[string]location // paths in the merged config to their source
+
+	// the environment variables used by each file that aren't set
+	undefined map[string][]template.Reference
+}
+
+func newConfigSource() *configSource {
+	return &configSource{
+		keys:      map[string]string{},
+		jobs:      map[string]string{},
+		watches:   map[string]string{},
+		locations: map[string]location{},
+		undefined: map[string][]template.Reference{},
+	}
+}
+
+// loadConfigMap loads the config file, or every config file in the
+// config directory, along with any files they include, and merges them
+// into a single config map. Returns the source of the merged config.
+func loadConfigMap(configFlag string) (map[string]interface{}, *configSource, error) {
+	source := newConfigSource()
+	info, err := os.Stat(configFlag)
+	if err != nil || !info.IsDir() {
+		// a single config file keeps its errors unprefixed
+		configMap, lines, err := source.loadFragment(configFlag)
+		if err != nil {
+			return nil, nil, err
+		}
+		source.patterns = append(source.patterns, configFlag)
+		merged := map[string]interface{}{}
+		if err := source.load(merged, configMap, lines, configFlag, true); err != nil {
+			return nil, nil, err
+		}
+		if err := source.checkTemplates(merged); err != nil {
+			return nil, nil, err
+		}
+		return merged, source, nil
+	}
+
+	for _, ext := range configExtensions {
+		source.patterns = append(source.patterns, filepath.Join(configFlag, "*"+ext))
+	}
+	paths := configDirFiles(configFlag)
+	if len(paths) == 0 {
+		return nil, nil, fmt.Errorf("no config files found in %s", configFlag)
+	}
+	merged := map[string]interface{}{}
+	for _, path := range paths {
+		configMap, lines, err := source.loadFragment(path)
+		if err != nil {
+			return nil, nil, fmt.Errorf("%s: %v", path, err)
+		}
+		if err := source.load(merged, configMap, lines, path, true); err != nil {
+			return nil, nil, err
+		}
+	}
+	if err := source.checkTemplates(merged); err != nil {
+		return nil, nil, err
+	}
+	return merged, source, nil
+}
+
+// configDirFiles returns the config files in the directory, in the order
+// that they're loaded
+func configDirFiles(dir string) []string {
+	var paths []string
+	for _, ext := range configExtensions {
+		matches, _ := filepath.Glob(filepath.Join(dir, "*"+ext))
+		paths = append(paths, matches...)
+	}
+	sort.Strings(paths)
+	return paths
+}
+
+// loadFragment reads, renders, and parses a single config file. Returns
+// the line of each value in the file along with the config map.
+func (s *configSource) loadFragment(path string) (map[string]interface{}, map[string]int, error) {
+	configData, err := loadConfigFile(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	renderedConfig, tmpl, err := renderTemplate(configData)
+	if err != nil {
+		return nil, nil, err
+	}
+	s.undefined[path] = tmpl.Undefined()
+	format := formatOf(path)
+	configMap, err := unmarshalFormat(renderedConfig, format)
+	if err != nil {
+		return nil, nil, err
+	}
+	return configMap, lineIndex(renderedConfig, format), nil
+}
+
+// load merges the config map from path into the merged config, followed
+// by any files that it includes. Only top-level config files can include
+// other files.
+func (s *configSource) load(merged, configMap map[string]interface{},
+	lines map[string]int, path string, canInclude bool) error {
+
+	rawInclude, hasInclude := configMap["include"]
+	delete(configMap, "include")
+	s.files = append(s.files, path)
+	s.locate(merged, lines, path)
+	if err := s.merge(merged, configMap, path); err != nil {
+		return err
+	}
+	if !hasInclude {
+		return nil
+	}
+	if !canInclude {
+		return fmt.Errorf("%s: include is only allowed in the top-level config", path)
+	}
+	patterns, err := decode.ToStrings(rawInclude)
+	if err != nil {
+		return fmt.Errorf("%s: unable to parse include: %v", path, err)
+	}
+	for _, pattern := range patterns {
+		if !filepath.IsAbs(pattern) {
+			pattern = filepath.Join(filepath.Dir(path), pattern)
+		}
+		matches, err := filepath.Glob(pattern)
+		if err != nil {
+			return fmt.Errorf("%s: invalid include '%s': %v", path, pattern, err)
+		}
+		// a glob may match nothing, but a plain path has to exist
+		if len(matches) == 0 && !hasGlob(pattern) {
+			return fmt.Errorf("%s: included file %s not found", path, pattern)
+		}
+		s.patterns = append(s.patterns, pattern)
+		for _, match := range matches {
+			fragment, lines, err := s.loadFragment(match)
+			if err != nil {
+				return fmt.Errorf("%s: %v", match, err)
+			}
+			if err := s.load(merged, fragment, lines, match, false); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// merge adds the config map from path to the merged config. The jobs,
+// watches, and telemetry metrics lists are appended to each other, but
+// any other key can only be set by one file.
+func (s *configSource) merge(merged, configMap map[string]interface{}, path string) error {
+	keys := make([]string, 0, len(configMap))
+	for key := range configMap {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys) // for stable error messages
+	for _, key := range keys {
+		value := configMap[key]
+		switch key {
+		case "jobs":
+			if err := s.addNames(s.jobs, "job", value, path); err != nil {
+				return err
+			}
+			merged[key] = appendList(merged[key], value)
+		case "watches":
+			if err := s.addNames(s.watches, "watch", value, path); err != nil {
+				return err
+			}
+			merged[key] = appendList(merged[key], value)
+		case "telemetry":
+			if err := s.mergeTelemetry(merged, value, path); err != nil {
+				return err
+			}
+		default:
+			if err := s.setKey(key, path); err != nil {
+				return err
+			}
+			merged[key] = value
+		}
+	}
+	return nil
+}
+
+func (s *configSource) mergeTelemetry(merged map[string]interface{},
+	value interface{}, path string) error {
+
+	fragment, ok := value.(map[string]interface{})
+	if !ok {
+		// not something we can merge, so let validation report it
+		if err := s.setKey("telemetry", path); err != nil {
+			return err
+		}
+		merged["telemetry"] = value
+		return nil
+	}
+	telemetry, ok := merged["telemetry"].(map[string]interface{})
+	if !ok {
+		telemetry = map[string]interface{}{}
+		merged["telemetry"] = telemetry
+	}
+	for key, val := range fragment {
+		if key == "metrics" {
+			telemetry[key] = appendList(telemetry[key], val)
+			continue
+		}
+		if err := s.setKey("telemetry."+key, path); err != nil {
+			return err
+		}
+		telemetry[key] = val
+	}
+	return nil
+}
+
+// mergedLists are the lists that are appended to each other when merging
+var mergedLists = []string{"jobs", "watches", "telemetry.metrics"}
+
+// locate records the source of each value in a config file, before it's
+// merged. Items of the lists that are appended are shifted by the number
+// of items already merged, so that they have their path in the merged
+// config.
+func (s *configSource) locate(merged map[string]interface{},
+	lines map[string]int, file string) {
+
+	offsets := map[string]int{}
+	for _, list := range mergedLists {
+		var existing interface{} = merged
+		for _, key := range strings.Split(list, ".") {
+			if m, ok := existing.(map[string]interface{}); ok {
+				existing = m[key]
+			} else {
+				existing = nil
+			}
+		}
+		offsets[list] = len(decode.ToSlice(existing))
+	}
+	for path, line := range lines {
+		s.locations[shiftIndex(path, offsets)] = location{file: file, line: line}
+	}
+}
+
+// shiftIndex adds the offset for the list to the index of the item at
+// path, if the path is within one of the lists
+func shiftIndex(path string, offsets map[string]int) string {
+	for list, offset := range offsets {
+		prefix := list + "["
+		if offset == 0 || !strings.HasPrefix(path, prefix) {
+			continue
+		}
+		end := strings.IndexByte(path, ']')
+		i, err := strconv.Atoi(path[len(prefix):end])
+		if err != nil {
+			return path
+		}
+		return validation.Index(list, i+offset) + path[end+1:]
+	}
+	return path
+}
+
+// lookup returns the file and line of the value at path in the merged
+// config, or of the closest enclosing value that we know the line of.
+// The file is only returned if the config came from more than one file.
+func (s *configSource) lookup(path string) (string, int) {
+	for {
+		if loc, ok := s.locations[path]; ok {
+			if len(s.files) < 2 {
+				return "", loc.line
+			}
+			return loc.file, loc.line
+		}
+		i := strings.LastIndexAny(path, ".[")
+		if i <= 0 {
+			return "", 0
+		}
+		path = path[:i]
+	}
+}
+
+// checkTemplates returns an error listing the environment variables used
+// by the config files that aren't set, if the templates are strict
+func (s *configSource) checkTemplates(merged map[string]interface{}) error {
+	if !strictTemplates && !isTemplateStrict(merged) {
+		return nil
+	}
+	var msgs []string
+	for _, file := range s.files {
+		refs := s.undefined[file]
+		if len(refs) == 0 {
+			continue
+		}
+		err := &template.UndefinedError{Refs: refs}
+		if len(s.files) > 1 {
+			msgs = append(msgs, fmt.Sprintf("%s: %v", file, err))
+		} else {
+			msgs = append(msgs, err.Error())
+		}
+	}
+	if len(msgs) == 0 {
+		return nil
+	}
+	return fmt.Errorf("could not apply template to config: %s",
+		strings.Join(msgs, "\n"))
+}
+
+func (s *configSource) setKey(key, path string) error {
+	if other, ok := s.keys[key]; ok {
+		if other == path {
+			return fmt.Errorf("%s: %s is set more than once", path, key)
+		}
+		return fmt.Errorf("%s is set in both %s and %s", key, other, path)
+	}
+	s.keys[key] = path
+	return nil
+}
+
+// addNames records the names of the jobs or watches in the list, and
+// returns an error if any of them has already been defined
+func (s *configSource) addNames(names map[string]string, kind string,
+	list interface{}, path string) error {
+
+	for _, item := range decode.ToSlice(list) {
+		raw, ok := item.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		name, ok := raw["name"].(string)
+		if !ok || name == "" {
+			continue
+		}
+		if other, ok := names[name]; ok {
+			if other == path {
+				return fmt.Errorf("duplicate %s name '%s' in %s", kind, name, path)
+			}
+			return fmt.Errorf("duplicate %s name '%s' in %s and %s",
+				kind, name, other, path)
+		}
+		names[name] = path
+	}
+	return nil
+}
+
+// appendList appends the items of the list to the existing list. If
+// either isn't a list, the new value replaces the old so that validation
+// can report it.
+func appendList(existing, list interface{}) interface{} {
+	if existing == nil {
+		return list
+	}
+	items := decode.ToSlice(existing)
+	more := decode.ToSlice(list)
+	if items == nil || more == nil {
+		return list
+	}
+	return append(items, more...)
+}
+
+// expandPatterns returns the files that currently match the paths and
+// globs of a config
+func expandPatterns(patterns []string) []string {
+	paths := []string{}
+	for _, pattern := range patterns {
+		if !hasGlob(pattern) {
+			paths = append(paths, pattern)
+			continue
+		}
+		matches, _ := filepath.Glob(pattern)
+		paths = append(paths, matches...)
+	}
+	return paths
+}
+
+func hasGlob(path string) bool {
+	return strings.ContainsAny(path, "*?[")
+}
@@ -0,0 +1,247 @@
+package config
+
+import (
+	"bytes"
+	"strings"
+
+	"github.com/pelletier/go-toml/v2/unstable"
+	"gopkg.in/yaml.v3"
+
+	"github.com/asokolov365/containerpilot/config/validation"
+)
+
+// location is where a config value was set
+type location struct {
+	file string
+	line int
+}
+
+// lineIndex returns the line of each value in the config data, by its
+// path like `jobs[0].health.interval`. The data has already been parsed
+// successfully, so we only need to find the values, not check them.
+func lineIndex(data []byte, format string) map[string]int {
+	switch format {
+	case formatYAML:
+		return yamlLines(data)
+	case formatTOML:
+		return tomlLines(data)
+	}
+	return json5Lines(data)
+}
+
+func yamlLines(data []byte) map[string]int {
+	var doc yaml.Node
+	if err := yaml.Unmarshal(data, &doc); err != nil || len(doc.Content) == 0 {
+		return nil
+	}
+	lines := map[string]int{}
+	var walk func(node *yaml.Node, path string)
+	walk = func(node *yaml.Node, path string) {
+		switch node.Kind {
+		case yaml.MappingNode:
+			for i := 0; i+1 < len(node.Content); i += 2 {
+				key, value := node.Content[i], node.Content[i+1]
+				keyPath := validation.Join(path, key.Value)
+				lines[keyPath] = key.Line
+				walk(value, keyPath)
+			}
+		case yaml.SequenceNode:
+			for i, item := range node.Content {
+				itemPath := validation.Index(path, i)
+				lines[itemPath] = item.Line
+				walk(item, itemPath)
+			}
+		}
+	}
+	walk(doc.Content[0], "")
+	return lines
+}
+
+// tomlLines walks the TOML expressions of the data. Table headers only
+// name their keys, so we keep track of how many entries each array of
+// tables has to find the path of the current table.
+func tomlLines(data []byte) map[string]int {
+	lines := map[string]int{}
+	tableArrays := map[string]int{}
+	p := &unstable.Parser{}
+	p.Reset(data)
+	lineOf := func(node *unstable.Node, fallback int) int {
+		if node.Raw.Length == 0 {
+			return fallback
+		}
+		return p.Shape(node.Raw).Start.Line
+	}
+	// keyPath returns the path of the node's key under the base path,
+	// and the line of its last part
+	keyPath := func(node *unstable.Node, base string) (string, int) {
+		path, line := base, 0
+		it := node.Key()
+		for it.Next() {
+			key := it.Node()
+			path = validation.Join(path, string(key.Data))
+			line = lineOf(key, line)
+			if count, ok := tableArrays[path]; ok && !(it.IsLast() && node.Kind == unstable.ArrayTable) {
+				path = validation.Index(path, count-1)
+			}
+		}
+		return path, line
+	}
+	var walk func(node *unstable.Node, path string, line int)
+	walk = func(node *unstable.Node, path string, line int) {
+		switch node.Kind {
+		case unstable.Array:
+			it := node.Children()
+			for i := 0; it.Next(); i++ {
+				itemPath := validation.Index(path, i)
+				itemLine := lineOf(it.Node(), line)
+				lines[itemPath] = itemLine
+				walk(it.Node(), itemPath, itemLine)
+			}
+		case unstable.InlineTable:
+			it := node.Children()
+			for it.Next() {
+				keyValue := it.Node()
+				valuePath, valueLine := keyPath(keyValue, path)
+				lines[valuePath] = valueLine
+				walk(keyValue.Value(), valuePath, valueLine)
+			}
+		}
+	}
+	table := ""
+	for p.NextExpression() {
+		expr := p.Expression()
+		switch expr.Kind {
+		case unstable.Table:
+			path, line := keyPath(expr, "")
+			table = path
+			lines[table] = line
+		case unstable.ArrayTable:
+			path, line := keyPath(expr, "")
+			tableArrays[path]++
+			table = validation.Index(path, tableArrays[path]-1)
+			lines[table] = line
+		case unstable.KeyValue:
+			path, line := keyPath(expr, table)
+			lines[path] = line
+			walk(expr.Value(), path, line)
+		}
+	}
+	if p.Error() != nil {
+		return nil
+	}
+	return lines
+}
+
+// json5Frame is an object or array that we're inside while scanning
+type json5Frame struct {
+	path    string
+	isArray bool
+	index   int    // the current item, for arrays
+	key     string // the current key, for objects
+	wantKey bool   // whether the next token is a key, for objects
+}
+
+// json5Lines scans the JSON5 tokens of the data, keeping track of the
+// path of each value
+func json5Lines(data []byte) map[string]int {
+	lines := map[string]int{}
+	var stack []*json5Frame
+	line := 1
+	// valuePath returns the path of a value starting at the current
+	// token, recording its line if it's an array item
+	valuePath := func() string {
+		if len(stack) == 0 {
+			return ""
+		}
+		top := stack[len(stack)-1]
+		if !top.isArray {
+			return validation.Join(top.path, top.key)
+		}
+		path := validation.Index(top.path, top.index)
+		if _, ok := lines[path]; !ok {
+			lines[path] = line
+		}
+		return path
+	}
+	for pos := 0; pos < len(data); {
+		c := data[pos]
+		switch {
+		case c == '\n':
+			line++
+			pos++
+		case c == ' ' || c == '\t' || c == '\r':
+			pos++
+		case bytes.HasPrefix(data[pos:], []byte("//")):
+			for pos < len(data) && data[pos] != '\n' {
+				pos++
+			}
+		case bytes.HasPrefix(data[pos:], []byte("/*")):
+			end := bytes.Index(data[pos:], []byte("*/"))
+			if end < 0 {
+				end = len(data) - pos
+			}
+			line += bytes.Count(data[pos:pos+end], []byte("\n"))
+			pos += end + 2
+		case c == '{' || c == '[':
+			stack = append(stack, &json5Frame{
+				path: valuePath(), isArray: c == '[', wantKey: c == '{'})
+			pos++
+		case c == '}' || c == ']':
+			if len(stack) > 0 {
+				stack = stack[:len(stack)-1]
+			}
+			pos++
+		case c == ',':
+			if len(stack) > 0 {
+				top := stack[len(stack)-1]
+				top.index++
+				top.wantKey = !top.isArray
+			}
+			pos++
+		case c == ':':
+			if len(stack) > 0 {
+				stack[len(stack)-1].wantKey = false
+			}
+			pos++
+		default:
+			token, next := json5Token(data, pos)
+			if len(stack) > 0 && stack[len(stack)-1].wantKey {
+				top := stack[len(stack)-1]
+				top.key = token
+				lines[validation.Join(top.path, token)] = line
+			} else {
+				valuePath()
+			}
+			line += bytes.Count(data[pos:next], []byte("\n"))
+			pos = next
+		}
+	}
+	return lines
+}
+
+// json5Token returns the string, identifier, or number starting at pos,
+// and the position after it
+func json5Token(data []byte, pos int) (string, int) {
+	if quote := data[pos]; quote == '"' || quote == '\'' {
+		var buf strings.Builder
+		for i := pos + 1; i < len(data); i++ {
+			switch data[i] {
+			case '\\':
+				if i+1 < len(data) {
+					i++
+					buf.WriteByte(data[i])
+				}
+			case quote:
+				return buf.String(), i + 1
+			default:
+				buf.WriteByte(data[i])
+			}
+		}
+		return buf.String(), len(data)
+	}
+	end := pos + 1
+	for end < len(data) && !strings.ContainsRune(" \t\r\n,:[]{}/", rune(data[end])) {
+		end++
+	}
+	return string(data[pos:end]), end
+}
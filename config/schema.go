@@ -0,0 +1,450 @@
+package config
+
+//go:generate go run schema_gen.go ../docs/30-configuration/containerpilot.schema.json
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/asokolov365/containerpilot/config/logger"
+	"github.com/asokolov365/containerpilot/config/validation"
+	"github.com/asokolov365/containerpilot/control"
+	"github.com/asokolov365/containerpilot/discovery"
+	"github.com/asokolov365/containerpilot/jobs"
+	"github.com/asokolov365/containerpilot/signals"
+	"github.com/asokolov365/containerpilot/surveillee"
+	"github.com/asokolov365/containerpilot/telemetry"
+	"github.com/asokolov365/containerpilot/watches"
+)
+
+// JSONSchema is the subset of JSON Schema (draft 7) that we need to
+// describe the configuration
+type JSONSchema struct {
+	Schema      string
+	Title       string
+	Description string
+
+	// Type is the list of JSON types that are allowed
+	Type       []string
+	Properties map[string]*JSONSchema
+	// AdditionalProperties is the schema of any properties not listed in
+	// Properties. If it's nil no other properties are allowed.
+	AdditionalProperties *JSONSchema
+	Required             []string
+	Items                *JSONSchema
+	Enum                 []interface{}
+	AnyOf                []*JSONSchema
+	Pattern              string
+	Minimum              *float64
+}
+
+// MarshalJSON satisfies the json.Marshaler interface, writing a single
+// type as a string and objects that allow no additional properties with
+// `"additionalProperties": false`
+func (s *JSONSchema) MarshalJSON() ([]byte, error) {
+	type schema struct {
+		Schema               string                 `json:"$schema,omitempty"`
+		Title                string                 `json:"title,omitempty"`
+		Description          string                 `json:"description,omitempty"`
+		Type                 interface{}            `json:"type,omitempty"`
+		Properties           map[string]*JSONSchema `json:"properties,omitempty"`
+		AdditionalProperties interface{}            `json:"additionalProperties,omitempty"`
+		Required             []string               `json:"required,omitempty"`
+		Items                *JSONSchema            `json:"items,omitempty"`
+		Enum                 []interface{}          `json:"enum,omitempty"`
+		AnyOf                []*JSONSchema          `json:"anyOf,omitempty"`
+		Pattern              string                 `json:"pattern,omitempty"`
+		Minimum              *float64               `json:"minimum,omitempty"`
+	}
+	out := schema{
+		Schema:      s.Schema,
+		Title:       s.Title,
+		Description: s.Description,
+		Properties:  s.Properties,
+		Required:    s.Required,
+		Items:       s.Items,
+		Enum:        s.Enum,
+		AnyOf:       s.AnyOf,
+		Pattern:     s.Pattern,
+		Minimum:     s.Minimum,
+	}
+	switch len(s.Type) {
+	case 0:
+	case 1:
+		out.Type = s.Type[0]
+	default:
+		out.Type = s.Type
+	}
+	if s.allows("object") {
+		if s.AdditionalProperties != nil {
+			out.AdditionalProperties = s.AdditionalProperties
+		} else if s.Properties != nil {
+			out.AdditionalProperties = false
+		}
+	}
+	return json.Marshal(out)
+}
+
+func (s *JSONSchema) allows(jsonType string) bool {
+	for _, t := range s.Type {
+		if t == jsonType {
+			return true
+		}
+	}
+	return false
+}
+
+// the config decoder is weakly typed, so numbers and booleans can be
+// given as strings, and a single string as a list of strings
+var (
+	stringSchema  = &JSONSchema{Type: []string{"string"}}
+	integerSchema = &JSONSchema{Type: []string{"integer", "string"}, Pattern: `^-?[0-9]+$`}
+	booleanSchema = &JSONSchema{Type: []string{"boolean", "string"},
+		Pattern: `^(1|0|t|f|T|F|true|false|TRUE|FALSE|True|False)$`}
+	stringListSchema = &JSONSchema{Type: []string{"array", "string"}, Items: stringSchema}
+	execSchema       = stringListSchema
+	// durations without units are in seconds
+	durationSchema = &JSONSchema{Type: []string{"string", "integer"}}
+	// numbers and booleans are decoded as strings
+	metaSchema = &JSONSchema{
+		Type: []string{"object"},
+		AdditionalProperties: &JSONSchema{
+			Type: []string{"string", "number", "boolean"}},
+	}
+)
+
+func minimum(schema *JSONSchema, min float64) *JSONSchema {
+	s := *schema
+	s.Minimum = &min
+	return &s
+}
+
+// schemaOverrides are the schemas of the config fields that we decode
+// into an interface{} and parse ourselves
+var schemaOverrides = map[string]*JSONSchema{
+	"etcd.endpoints":            stringListSchema,
+	"jobs.exec":                 execSchema,
+	"jobs.health.exec":          execSchema,
+	"jobs.interfaces":           stringListSchema,
+	"jobs.services.interfaces":  stringListSchema,
+	"jobs.consul.meta":          metaSchema,
+	"jobs.services.consul.meta": metaSchema,
+	"jobs.restarts": {
+		Description: `a non-negative integer, "unlimited", or "never"`,
+		AnyOf: []*JSONSchema{
+			minimum(integerSchema, 0),
+			{Type: []string{"string"}, Enum: []interface{}{"unlimited", "never"}},
+		}},
+	"jobs.timeout":                         durationSchema,
+	"jobs.stopTimeout":                     durationSchema,
+	"jobs.when.interval":                   durationSchema,
+	"jobs.when.timeout":                    durationSchema,
+	"jobs.health.timeout":                  durationSchema,
+	"jobs.consul.checks.interval":          durationSchema,
+	"jobs.consul.checks.timeout":           durationSchema,
+	"jobs.services.consul.checks.interval": durationSchema,
+	"jobs.services.consul.checks.timeout":  durationSchema,
+	"jobs.limits.nofile":                   minimum(integerSchema, 0),
+	"jobs.limits.nproc":                    minimum(integerSchema, 0),
+	"jobs.limits.core":                     minimum(integerSchema, 0),
+	"telemetry.interfaces":                 stringListSchema,
+	"telemetry.metrics.type": {Type: []string{"string"},
+		Enum: []interface{}{"counter", "gauge", "histogram", "summary"}},
+	"signals.action": {Type: []string{"string"}, Enum: []interface{}{
+		string(signals.Forward), string(signals.Trigger),
+		string(signals.Maintenance), string(signals.Reload)}},
+}
+
+// schemaTypes are the types of the config fields that we decode into an
+// interface{} and then decode again into a config struct
+var schemaTypes = map[string]reflect.Type{
+	"telemetry.metrics": reflect.TypeOf([]telemetry.MetricConfig{}),
+}
+
+// required are the config fields that must be set
+var required = map[string][]string{
+	"jobs":              {"name"},
+	"watches":           {"name"},
+	"telemetry.metrics": {"namespace", "subsystem", "name", "help", "type"},
+	"signals":           {"signal", "action"},
+}
+
+// Schema generates the JSON Schema of the configuration file from the
+// config structs of each section
+func Schema() *JSONSchema {
+	listOf := func(cfg interface{}, path string) *JSONSchema {
+		return &JSONSchema{Type: []string{"array"}, Items: structSchema(cfg, path)}
+	}
+	return &JSONSchema{
+		Schema: "http://json-schema.org/draft-07/schema#",
+		Title:  "ContainerPilot configuration",
+		Type:   []string{"object"},
+		Properties: map[string]*JSONSchema{
+			"consul": {
+				Description: "address of the Consul agent, or its full configuration",
+				AnyOf: []*JSONSchema{
+					stringSchema, structSchema(discovery.ConsulConfig{}, "consul")},
+			},
+			"etcd": {
+				Description: "address of the etcd cluster, or its full configuration",
+				AnyOf: []*JSONSchema{
+					stringSchema, structSchema(discovery.EtcdConfig{}, "etcd")},
+			},
+			"vault": {
+				Description: "address of the Vault server, or its full configuration",
+				AnyOf: []*JSONSchema{
+					stringSchema, structSchema(surveillee.VaultConfig{}, "vault")},
+			},
+			"include":        stringListSchema,
+			"logging":        structSchema(logger.Config{}, "logging"),
+			"stopTimeout":    minimum(integerSchema, 0),
+			"reloadOnChange": booleanSchema,
+			"templateStrict": booleanSchema,
+			"jobs":           listOf(jobs.Config{}, "jobs"),
+			"watches":        listOf(watches.Config{}, "watches"),
+			"telemetry":      structSchema(telemetry.Config{}, "telemetry"),
+			"control":        structSchema(control.Config{}, "control"),
+			"signals":        listOf(signals.Config{}, "signals"),
+		},
+	}
+}
+
+// structSchema generates the schema of a config struct from the
+// `mapstructure` (or `json`) tags of its fields. Fields without tags
+// are derived during validation, so they're not part of the schema.
+func structSchema(cfg interface{}, path string) *JSONSchema {
+	return typeSchema(reflect.TypeOf(cfg), path)
+}
+
+func typeSchema(t reflect.Type, path string) *JSONSchema {
+	if override, ok := schemaOverrides[path]; ok {
+		return override
+	}
+	if st, ok := schemaTypes[path]; ok && isInterface(t) {
+		return typeSchema(st, path)
+	}
+	switch t.Kind() {
+	case reflect.Ptr:
+		return typeSchema(t.Elem(), path)
+	case reflect.String:
+		return stringSchema
+	case reflect.Bool:
+		return booleanSchema
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return integerSchema
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return minimum(integerSchema, 0)
+	case reflect.Float32, reflect.Float64:
+		return &JSONSchema{Type: []string{"number", "string"}}
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.String {
+			return stringListSchema
+		}
+		return &JSONSchema{Type: []string{"array"}, Items: typeSchema(t.Elem(), path)}
+	case reflect.Map:
+		return &JSONSchema{
+			Type:                 []string{"object"},
+			AdditionalProperties: typeSchema(t.Elem(), path),
+		}
+	case reflect.Struct:
+		schema := &JSONSchema{
+			Type:       []string{"object"},
+			Properties: map[string]*JSONSchema{},
+			Required:   required[path],
+		}
+		for i := 0; i < t.NumField(); i++ {
+			field := t.Field(i)
+			name := fieldName(field)
+			if name == "" {
+				continue
+			}
+			schema.Properties[name] = typeSchema(field.Type, path+"."+name)
+		}
+		return schema
+	}
+	return &JSONSchema{} // anything goes
+}
+
+// isInterface returns true for an interface{} or []interface{}
+func isInterface(t reflect.Type) bool {
+	if t.Kind() == reflect.Slice {
+		t = t.Elem()
+	}
+	return t.Kind() == reflect.Interface
+}
+
+func fieldName(field reflect.StructField) string {
+	if field.PkgPath != "" {
+		return "" // unexported
+	}
+	for _, key := range []string{"mapstructure", "json"} {
+		if tag := strings.Split(field.Tag.Get(key), ",")[0]; tag != "" && tag != "-" {
+			return tag
+		}
+	}
+	return ""
+}
+
+// ValidateSchema checks the parsed config against the schema, and
+// returns every value that doesn't match it, at paths like
+// `jobs[2].health.interval`
+func ValidateSchema(configMap map[string]interface{}) validation.Errors {
+	return Schema().validate(configMap, "")
+}
+
+func (s *JSONSchema) validate(value interface{}, path string) validation.Errors {
+	fail := func(format string, args ...interface{}) validation.Errors {
+		return validation.Errors{validation.Errorf(path, format, args...)}
+	}
+	if s.AnyOf != nil {
+		return s.validateAnyOf(value, path)
+	}
+	jsonType := typeOf(value)
+	if len(s.Type) > 0 && !s.allows(jsonType) &&
+		!(jsonType == "integer" && s.allows("number")) {
+		return fail("must be %s, not %s", describeTypes(s.Type), jsonType)
+	}
+	if s.Enum != nil && !inEnum(s.Enum, value) {
+		return fail("must be one of %s", describeEnum(s.Enum))
+	}
+	switch v := value.(type) {
+	case string:
+		if s.Pattern != "" && !regexp.MustCompile(s.Pattern).MatchString(v) {
+			if s.allows("integer") {
+				return fail("must be an integer, not %q", v)
+			}
+			if s.allows("boolean") {
+				return fail("must be true or false, not %q", v)
+			}
+			return fail("must match %s, not %q", s.Pattern, v)
+		}
+	case float64:
+		if s.Minimum != nil && v < *s.Minimum {
+			return fail("must be at least %v", *s.Minimum)
+		}
+	case []interface{}:
+		if s.Items == nil {
+			return nil
+		}
+		var errs validation.Errors
+		for i, item := range v {
+			errs = append(errs, s.Items.validate(item, validation.Index(path, i))...)
+		}
+		return errs
+	case map[string]interface{}:
+		return s.validateObject(v, path)
+	}
+	return nil
+}
+
+func (s *JSONSchema) validateObject(value map[string]interface{}, path string) validation.Errors {
+	var errs validation.Errors
+	for _, key := range s.Required {
+		if _, ok := value[key]; !ok {
+			errs.Addf(validation.Join(path, key), "is required")
+		}
+	}
+	keys := make([]string, 0, len(value))
+	for key := range value {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		keyPath := validation.Join(path, key)
+		if prop, ok := s.Properties[key]; ok {
+			errs = append(errs, prop.validate(value[key], keyPath)...)
+		} else if s.AdditionalProperties != nil {
+			errs = append(errs, s.AdditionalProperties.validate(value[key], keyPath)...)
+		} else if s.Properties != nil {
+			errs.Addf(keyPath, "is not a known config key")
+		}
+	}
+	return errs
+}
+
+// validateAnyOf validates the value against the alternative that
+// accepts its type, so that we can report errors within it
+func (s *JSONSchema) validateAnyOf(value interface{}, path string) validation.Errors {
+	jsonType := typeOf(value)
+	var types []string
+	var failed []validation.Errors
+	for _, alt := range s.AnyOf {
+		types = append(types, alt.Type...)
+		if len(alt.Type) > 0 && !alt.allows(jsonType) {
+			continue
+		}
+		errs := alt.validate(value, path)
+		if len(errs) == 0 {
+			return nil
+		}
+		failed = append(failed, errs)
+	}
+	switch {
+	case len(failed) == 1:
+		return failed[0]
+	case len(failed) > 1 && s.Description != "":
+		return validation.Errors{validation.Errorf(path, "must be %s", s.Description)}
+	case len(failed) > 1:
+		return failed[0]
+	}
+	return validation.Errors{validation.Errorf(path, "must be %s, not %s",
+		describeTypes(types), jsonType)}
+}
+
+// typeOf returns the JSON type of a parsed config value
+func typeOf(value interface{}) string {
+	switch v := value.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case string:
+		return "string"
+	case float64:
+		if v == float64(int64(v)) {
+			return "integer"
+		}
+		return "number"
+	case []interface{}:
+		return "array"
+	case map[string]interface{}:
+		return "object"
+	}
+	return fmt.Sprintf("%T", value)
+}
+
+func describeTypes(types []string) string {
+	seen := map[string]bool{}
+	var names []string
+	for _, t := range types {
+		if !seen[t] {
+			seen[t] = true
+			names = append(names, t)
+		}
+	}
+	if len(names) == 1 {
+		return names[0]
+	}
+	return strings.Join(names[:len(names)-1], ", ") + " or " + names[len(names)-1]
+}
+
+func inEnum(enum []interface{}, value interface{}) bool {
+	for _, allowed := range enum {
+		if allowed == value {
+			return true
+		}
+	}
+	return false
+}
+
+func describeEnum(enum []interface{}) string {
+	names := make([]string, len(enum))
+	for i, allowed := range enum {
+		names[i] = fmt.Sprintf("%q", allowed)
+	}
+	return strings.Join(names, ", ")
+}
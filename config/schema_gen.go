@@ -0,0 +1,30 @@
+//go:build ignore
+// +build ignore
+
+// This program writes the JSON Schema of the configuration file. It's
+// run by `go generate` in the config package.
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"github.com/asokolov365/containerpilot/config"
+)
+
+func main() {
+	if len(os.Args) != 2 {
+		fmt.Fprintln(os.Stderr, "usage: go run schema_gen.go <output file>")
+		os.Exit(2)
+	}
+	data, err := json.MarshalIndent(config.Schema(), "", "  ")
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	if err := os.WriteFile(os.Args[1], append(data, '\n'), 0644); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,125 @@
+package config
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSchemaIsGenerated(t *testing.T) {
+	expected, err := json.MarshalIndent(Schema(), "", "  ")
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := os.ReadFile("../docs/30-configuration/containerpilot.schema.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, string(expected)+"\n", string(got),
+		"schema is out of date: run 'go generate ./config'")
+}
+
+func TestSchemaExamples(t *testing.T) {
+	paths, _ := filepath.Glob("../docs/30-configuration/examples/*.json5")
+	paths = append(paths, "./testdata/test.json5", "./testdata/test.yaml",
+		"./testdata/test.toml")
+	for _, path := range paths {
+		configMap, _, err := newConfigSource().loadFragment(path)
+		if err != nil {
+			t.Errorf("%s: %v", path, err)
+			continue
+		}
+		for _, err := range ValidateSchema(configMap) {
+			t.Errorf("%s: %v", path, err)
+		}
+	}
+}
+
+func TestValidateSchema(t *testing.T) {
+	testFunc := func(data string) []string {
+		configMap, err := unmarshalConfig([]byte(data))
+		if err != nil {
+			t.Fatalf("unexpected error parsing config: %v", err)
+		}
+		errs := []string{}
+		for _, err := range ValidateSchema(configMap) {
+			errs = append(errs, err.Error())
+		}
+		return errs
+	}
+
+	assert.Equal(t, []string{}, testFunc(`{
+	consul: "localhost:8500",
+	stopTimeout: "10",
+	jobs: [
+		{name: "a", exec: ["a", "-v"], restarts: "unlimited", when: {interval: 5}},
+		{name: "b", exec: "b", port: 80, health: {exec: "check", interval: 5, ttl: 10}}
+	]}`))
+
+	assert.Equal(t, []string{
+		"consul.bogus: is not a known config key",
+		"jobs[1].restarts: must be a non-negative integer, \"unlimited\", or \"never\"",
+		"jobs[2].health.interval: must be an integer, not \"fast\"",
+		"jobs[3].name: is required",
+		"jobs[3].port: must be integer or string, not array",
+		"signals[0].action: must be one of \"forward\", \"trigger\", \"maintenance\", \"reload\"",
+		"stopTimeout: must be at least 0",
+		"telemetry.metrics[0].type: must be one of \"counter\", \"gauge\", \"histogram\", \"summary\"",
+		"unknown: is not a known config key",
+	}, testFunc(`{
+	consul: {address: "localhost:8500", bogus: 1},
+	stopTimeout: -1,
+	unknown: true,
+	jobs: [
+		{name: "a", exec: "a"},
+		{name: "b", exec: "b", restarts: "often"},
+		{name: "c", exec: "c", health: {exec: "check", interval: "fast", ttl: 10}},
+		{exec: "d", port: [80]}
+	],
+	telemetry: {port: 9090, metrics: [
+		{namespace: "a", subsystem: "b", name: "c", help: "d", type: "meter"}]},
+	signals: [{signal: "SIGHUP", action: "explode"}]
+	}`))
+
+	assert.Equal(t, []string{"consul: must be string or object, not integer"},
+		testFunc(`{consul: 8500}`))
+}
+
+func TestValidateConfig(t *testing.T) {
+	assert.NoError(t, ValidateConfig("./testdata/test.yaml"))
+
+	dir := t.TempDir()
+	path := filepath.Join(dir, "containerpilot.json5")
+	write := func(data string) {
+		if err := os.WriteFile(path, []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write(`{
+	stopTimeout: "soon",
+	jobs: [
+		{exec: "a"}
+	]}`)
+	err := ValidateConfig(path)
+	assert.EqualError(t, err,
+		"jobs[0].name: is required (line 4)\n"+
+			"stopTimeout: must be an integer, not \"soon\" (line 2)")
+
+	// errors that the schema can't catch come from validating the sections
+	write(`{jobs: [
+		{name: "a", exec: "a", when: {source: "b", once: "bogus"}},
+		{name: "b", exec: "b", timeout: "0ms"}
+	]}`)
+	err = ValidateConfig(path)
+	assert.EqualError(t, err,
+		"jobs[0].when.once: bogus is not a valid event code (line 2)\n"+
+			"jobs[1].timeout: '0ms' cannot be less than 1ms (line 3)")
+
+	write(`{jobs: [`)
+	err = ValidateConfig(path)
+	assert.Error(t, err)
+	assert.Regexp(t, "^parse error at line:col", err.Error())
+}
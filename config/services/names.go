@@ -11,23 +11,24 @@ var validFilePathName = regexp.MustCompile(`^(\/[a-zA-Z0-9\_\-\s\.]+)+(\.[a-zA-Z
 
 // ValidateName checks if the service name passed as an argument
 // is is alpha-numeric with dashes. This ensures compliance with both DNS
-// and discovery backends.
+// and discovery backends. The error describes the name without naming
+// it, so that callers can report it at the path of the name field.
 func ValidateName(name, svcType string) error {
 	if name == "" {
-		return fmt.Errorf("'name' must not be blank")
+		return fmt.Errorf("must not be blank")
 	}
 	switch svcType {
 	case "vault":
 		if ok := validVaultPathName.MatchString(name); !ok {
-			return fmt.Errorf("service name must be valid vault path")
+			return fmt.Errorf("must be a valid vault path")
 		}
 	case "file":
 		if ok := validFilePathName.MatchString(name); !ok {
-			return fmt.Errorf("service name must be valid file path")
+			return fmt.Errorf("must be a valid file path")
 		}
 	default:
 		if ok := validConsulServiceName.MatchString(name); !ok {
-			return fmt.Errorf("service name must be alphanumeric with dashes to comply with service discovery")
+			return fmt.Errorf("must be alphanumeric with dashes to comply with service discovery")
 		}
 	}
 	return nil
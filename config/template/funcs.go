@@ -0,0 +1,219 @@
+package template
+
+import (
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"os"
+	"reflect"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/asokolov365/containerpilot/config/services"
+)
+
+// readFile returns the contents of the file at path, which is relative
+// to the working directory of ContainerPilot
+func readFile(path string) (string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
+
+// fileExists returns true if there's a file or directory at path
+func fileExists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
+
+// parseJSON parses a JSON document into maps, lists, and values that
+// can be used in the template
+func parseJSON(s string) (interface{}, error) {
+	var result interface{}
+	if err := json.Unmarshal([]byte(s), &result); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// parseYAML parses a YAML document into maps, lists, and values that
+// can be used in the template
+func parseYAML(s string) (interface{}, error) {
+	var result interface{}
+	if err := yaml.Unmarshal([]byte(s), &result); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// toJSON renders the value as JSON, which is also valid in a JSON5 or
+// YAML config. Strings are quoted and escaped, and lists are rendered
+// as JSON arrays.
+func toJSON(value interface{}) (string, error) {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
+
+func base64Encode(s string) string {
+	return base64.StdEncoding.EncodeToString([]byte(s))
+}
+
+func base64Decode(s string) (string, error) {
+	data, err := base64.StdEncoding.DecodeString(s)
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
+
+func md5sum(s string) string {
+	sum := md5.Sum([]byte(s))
+	return hex.EncodeToString(sum[:])
+}
+
+func sha1sum(s string) string {
+	sum := sha1.Sum([]byte(s))
+	return hex.EncodeToString(sum[:])
+}
+
+func sha256sum(s string) string {
+	sum := sha256.Sum256([]byte(s))
+	return hex.EncodeToString(sum[:])
+}
+
+// arithmetic accepts integers or strings holding integers, such as
+// environment variables, so `{{ .COUNT | add 1 }}` works
+func add(a, b interface{}) (int, error) {
+	x, y, err := ensureInts(a, b)
+	return x + y, err
+}
+
+func sub(a, b interface{}) (int, error) {
+	x, y, err := ensureInts(a, b)
+	return x - y, err
+}
+
+func mul(a, b interface{}) (int, error) {
+	x, y, err := ensureInts(a, b)
+	return x * y, err
+}
+
+func div(a, b interface{}) (int, error) {
+	x, y, err := ensureInts(a, b)
+	if err != nil {
+		return 0, err
+	}
+	if y == 0 {
+		return 0, errors.New("division by zero")
+	}
+	return x / y, nil
+}
+
+func mod(a, b interface{}) (int, error) {
+	x, y, err := ensureInts(a, b)
+	if err != nil {
+		return 0, err
+	}
+	if y == 0 {
+		return 0, errors.New("division by zero")
+	}
+	return x % y, nil
+}
+
+func ensureInts(a, b interface{}) (int, int, error) {
+	x, err := ensureInt(a)
+	if err != nil {
+		return 0, 0, err
+	}
+	y, err := ensureInt(b)
+	if err != nil {
+		return 0, 0, err
+	}
+	return x, y, nil
+}
+
+// required fails rendering with the message if the value is empty, so
+// that a missing environment variable is reported before the config is
+// parsed. For example: `{{ .API_KEY | required "API_KEY must be set" }}`
+func required(msg string, value interface{}) (interface{}, error) {
+	if isEmpty(value) {
+		return nil, errors.New(msg)
+	}
+	return value, nil
+}
+
+func hostname() (string, error) {
+	return os.Hostname()
+}
+
+// interfaceIP returns the IP address of the container that matches the
+// interface specifications, in the same way as the `interfaces` of a job
+func interfaceIP(specs ...string) (string, error) {
+	return services.GetIP(specs)
+}
+
+// list returns its arguments as a list
+func list(items ...interface{}) []interface{} {
+	return items
+}
+
+// appendList returns a copy of the list with the items added to the end
+func appendList(items interface{}, more ...interface{}) []interface{} {
+	result := []interface{}{}
+	result = append(result, toList(items)...)
+	return append(result, more...)
+}
+
+// compact returns a copy of the list without its empty items, so that
+// a list can be built from values that may not be set. For example:
+// `{{ list "app" .EXTRA_TAG | compact | toJSON }}`
+func compact(items interface{}) []interface{} {
+	result := []interface{}{}
+	for _, item := range toList(items) {
+		if !isEmpty(item) {
+			result = append(result, item)
+		}
+	}
+	return result
+}
+
+// toList converts any kind of slice, such as the result of split, to a
+// list. Anything else is a list of one item.
+func toList(value interface{}) []interface{} {
+	if value == nil {
+		return []interface{}{}
+	}
+	v := reflect.ValueOf(value)
+	if v.Kind() != reflect.Slice && v.Kind() != reflect.Array {
+		return []interface{}{value}
+	}
+	result := make([]interface{}, v.Len())
+	for i := range result {
+		result[i] = v.Index(i).Interface()
+	}
+	return result
+}
+
+func isEmpty(value interface{}) bool {
+	switch v := value.(type) {
+	case nil:
+		return true
+	case string:
+		return strings.TrimSpace(v) == ""
+	case []interface{}:
+		return len(v) == 0
+	case []string:
+		return len(v) == 0
+	}
+	return false
+}
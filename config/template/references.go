@@ -0,0 +1,192 @@
+package template
+
+import (
+	"fmt"
+	"strings"
+	"text/template/parse"
+)
+
+// Reference is a use of an environment variable in a template
+type Reference struct {
+	Name string
+	Line int
+	Col  int
+
+	// Optional references are read with the `env` function, which is
+	// how a template reads a variable that doesn't have to be set, or
+	// are guarded by an `if`, a `with`, or a `default`
+	Optional bool
+}
+
+func (ref Reference) String() string {
+	return fmt.Sprintf("%s (line %d, col %d)", ref.Name, ref.Line, ref.Col)
+}
+
+// UndefinedError is returned by a strict template that uses environment
+// variables that aren't set
+type UndefinedError struct {
+	Refs []Reference
+}
+
+func (err *UndefinedError) Error() string {
+	refs := make([]string, len(err.Refs))
+	for i, ref := range err.Refs {
+		refs[i] = ref.String()
+	}
+	return "undefined environment variables: " + strings.Join(refs, ", ")
+}
+
+// References returns every use of an environment variable in the
+// template, in the order they appear. Every branch of the template is
+// included, whether or not it would be rendered.
+func (c *Template) References() []Reference {
+	if c.Template.Tree == nil {
+		return nil
+	}
+	w := &refWalker{text: c.text, guarded: map[string]bool{}}
+	w.walk(c.Template.Tree.Root, true)
+	return w.refs
+}
+
+// Undefined returns the references to environment variables that aren't
+// set, other than the optional ones
+func (c *Template) Undefined() []Reference {
+	var undefined []Reference
+	for _, ref := range c.References() {
+		if _, ok := c.Env[ref.Name]; !ok && !ref.Optional {
+			undefined = append(undefined, ref)
+		}
+	}
+	return undefined
+}
+
+// CheckDefined returns an UndefinedError if the template uses any
+// environment variables that aren't set
+func (c *Template) CheckDefined() error {
+	if undefined := c.Undefined(); len(undefined) > 0 {
+		return &UndefinedError{Refs: undefined}
+	}
+	return nil
+}
+
+// refWalker collects the references in a template's parse tree. Fields
+// of dot are only environment variables where dot is still the
+// environment, which isn't the case inside a `range` or `with`.
+type refWalker struct {
+	text string
+	refs []Reference
+
+	// optional is set while walking a pipeline that checks whether a
+	// variable is set, like the condition of an `if` or a `default`
+	optional bool
+	// guarded are the variables checked by the enclosing `if`s, which
+	// are only used in their body when they're set
+	guarded map[string]bool
+}
+
+func (w *refWalker) walk(node parse.Node, dotIsEnv bool) {
+	switch n := node.(type) {
+	case *parse.ListNode:
+		if n == nil {
+			return
+		}
+		for _, child := range n.Nodes {
+			w.walk(child, dotIsEnv)
+		}
+	case *parse.ActionNode:
+		w.walk(n.Pipe, dotIsEnv)
+	case *parse.IfNode:
+		w.walkBranch(&n.BranchNode, dotIsEnv, dotIsEnv, true)
+	case *parse.RangeNode:
+		w.walkBranch(&n.BranchNode, false, dotIsEnv, false)
+	case *parse.WithNode:
+		w.walkBranch(&n.BranchNode, false, dotIsEnv, true)
+	case *parse.TemplateNode:
+		w.walk(n.Pipe, dotIsEnv)
+	case *parse.PipeNode:
+		if n == nil {
+			return
+		}
+		if hasDefault(n) && !w.optional {
+			w.optional = true
+			defer func() { w.optional = false }()
+		}
+		for _, cmd := range n.Cmds {
+			w.walk(cmd, dotIsEnv)
+		}
+	case *parse.CommandNode:
+		if len(n.Args) == 2 {
+			fn, isIdent := n.Args[0].(*parse.IdentifierNode)
+			name, isString := n.Args[1].(*parse.StringNode)
+			if isIdent && isString && fn.Ident == "env" {
+				w.add(name.Text, name.Position(), true)
+				return
+			}
+		}
+		for _, arg := range n.Args {
+			w.walk(arg, dotIsEnv)
+		}
+	case *parse.ChainNode:
+		w.walk(n.Node, dotIsEnv)
+	case *parse.FieldNode:
+		if dotIsEnv {
+			w.add(n.Ident[0], n.Position(), false)
+		}
+	case *parse.VariableNode:
+		// `$` is always the environment
+		if len(n.Ident) > 1 && n.Ident[0] == "$" {
+			w.add(n.Ident[1], n.Position(), false)
+		}
+	}
+}
+
+// walkBranch walks an if, range, or with. Its pipeline and else branch
+// are evaluated with the enclosing dot, but its body may not be. The
+// pipeline of an if or with only checks whether its variables are set,
+// and the body of an if is only rendered when they are.
+func (w *refWalker) walkBranch(n *parse.BranchNode, bodyDotIsEnv, dotIsEnv, guards bool) {
+	first := len(w.refs)
+	if guards && !w.optional {
+		w.optional = true
+		w.walk(n.Pipe, dotIsEnv)
+		w.optional = false
+	} else {
+		w.walk(n.Pipe, dotIsEnv)
+	}
+	var added []string
+	if guards {
+		for _, ref := range w.refs[first:] {
+			if !w.guarded[ref.Name] {
+				w.guarded[ref.Name] = true
+				added = append(added, ref.Name)
+			}
+		}
+	}
+	w.walk(n.List, bodyDotIsEnv)
+	for _, name := range added {
+		delete(w.guarded, name)
+	}
+	w.walk(n.ElseList, dotIsEnv)
+}
+
+// hasDefault returns true if the pipeline falls back to a default
+// value, like `{{ .PORT | default "80" }}`
+func hasDefault(pipe *parse.PipeNode) bool {
+	for _, cmd := range pipe.Cmds {
+		if len(cmd.Args) == 0 {
+			continue
+		}
+		if fn, ok := cmd.Args[0].(*parse.IdentifierNode); ok && fn.Ident == "default" {
+			return true
+		}
+	}
+	return false
+}
+
+func (w *refWalker) add(name string, pos parse.Pos, optional bool) {
+	line := 1 + strings.Count(w.text[:pos], "\n")
+	col := int(pos) - strings.LastIndex(w.text[:pos], "\n")
+	w.refs = append(w.refs, Reference{
+		Name: name, Line: line, Col: col,
+		Optional: optional || w.optional || w.guarded[name]})
+}
@@ -65,15 +65,22 @@ func envFunc(env string) string {
 }
 
 func ensureInt(intv interface{}) (int, error) {
-	switch intv.(type) {
+	switch v := intv.(type) {
 	case string:
-		ret, err := strconv.Atoi(intv.(string))
+		ret, err := strconv.Atoi(strings.TrimSpace(v))
 		if err != nil {
 			return 0, err
 		}
 		return ret, nil
+	case int:
+		return v, nil
+	case int64:
+		return int(v), nil
+	case float64:
+		// numbers parsed from JSON or YAML
+		return int(v), nil
 	default:
-		return intv.(int), nil
+		return 0, fmt.Errorf("expected an integer but got %v", intv)
 	}
 }
 
@@ -124,6 +131,8 @@ func loop(params ...interface{}) ([]int, error) {
 type Template struct {
 	Template *template.Template
 	Env      Environment
+
+	text string // the source of the template, for its line numbers
 }
 
 func defaultValue(defaultValue, templateValue interface{}) string {
@@ -151,6 +160,27 @@ func NewTemplate(config []byte) (*Template, error) {
 		"replaceAll":      replaceAll,
 		"regexReplaceAll": regexReplaceAll,
 		"loop":            loop,
+		"file":            readFile,
+		"fileExists":      fileExists,
+		"parseJSON":       parseJSON,
+		"parseYAML":       parseYAML,
+		"toJSON":          toJSON,
+		"base64Encode":    base64Encode,
+		"base64Decode":    base64Decode,
+		"md5sum":          md5sum,
+		"sha1sum":         sha1sum,
+		"sha256sum":       sha256sum,
+		"add":             add,
+		"sub":             sub,
+		"mul":             mul,
+		"div":             div,
+		"mod":             mod,
+		"required":        required,
+		"hostname":        hostname,
+		"interfaceIP":     interfaceIP,
+		"list":            list,
+		"append":          appendList,
+		"compact":         compact,
 	}).Option("missingkey=zero").Parse(string(config))
 	if err != nil {
 		return nil, err
@@ -158,6 +188,7 @@ func NewTemplate(config []byte) (*Template, error) {
 	return &Template{
 		Env:      env,
 		Template: tmpl,
+		text:     string(config),
 	}, nil
 }
 
@@ -2,6 +2,7 @@ package template
 
 import (
 	"os"
+	"path/filepath"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -68,3 +69,95 @@ func TestTemplate(t *testing.T) {
 	testTemplate("Regex Replace All",
 		`Hello, {{.NAME | regexReplaceAll "[epa]+" "_" }}!`, "Hello, T_m_l_t_!")
 }
+
+func TestTemplateFunctions(t *testing.T) {
+	env := parseEnvironment([]string{
+		"NAME=Template",
+		"COUNT=3",
+		"PARTS=a::c",
+		"CONFIG={\"port\": 8080, \"tags\": [\"a\", \"b\"]}",
+		"YAML=port: 8080",
+		"SECRET=c2VjcmV0",
+	})
+	path := filepath.Join(t.TempDir(), "token")
+	if err := os.WriteFile(path, []byte("my-token"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	env["TOKEN_FILE"] = path
+	hostname, _ := os.Hostname()
+
+	testTemplate := func(template string) (string, error) {
+		tmpl, err := NewTemplate([]byte(template))
+		if err != nil {
+			t.Fatalf("error parsing template %q: %s", template, err)
+		}
+		tmpl.Env = env
+		res, err := tmpl.Execute()
+		return string(res), err
+	}
+	for template, expected := range map[string]string{
+		`{{ file .TOKEN_FILE }}`:                                   "my-token",
+		`{{ fileExists .TOKEN_FILE }} {{ fileExists "/nope" }}`:    "true false",
+		`{{ (parseJSON .CONFIG).port }}`:                           "8080",
+		`{{ index (parseJSON .CONFIG).tags 1 }}`:                   "b",
+		`{{ (parseYAML .YAML).port }}`:                             "8080",
+		`{{ .NAME | toJSON }}`:                                     `"Template"`,
+		`{{ .NAME | base64Encode }}`:                               "VGVtcGxhdGU=",
+		`{{ .SECRET | base64Decode }}`:                             "secret",
+		`{{ .NAME | md5sum }}`:                                     "278c491bdd8a53618c149c4ac790da34",
+		`{{ .NAME | sha1sum }}`:                                    "3ec1ae061c27325c7ecb543adf91235e22cbc9ed",
+		`{{ .NAME | sha256sum }}`:                                  "0575f29df888a27e85b607111bd2fca420fd47432231bab2b9de8b81204ca4f2",
+		`{{ .COUNT | add 1 }} {{ sub .COUNT 1 }}`:                  "4 2",
+		`{{ mul .COUNT 2 }} {{ div 7 .COUNT }} {{ mod 7 .COUNT }}`: "6 2 1",
+		`{{ .NAME | required "NAME must be set" }}`:                "Template",
+		`{{ hostname }}`:                                           hostname,
+		`{{ interfaceIP "static:10.0.0.1" }}`:                      "10.0.0.1",
+		`{{ list "app" .NOPE .NAME | compact | toJSON }}`:          `["app","Template"]`,
+		`{{ .PARTS | split ":" | compact | toJSON }}`:              `["a","c"]`,
+		`{{ append (list "a") "b" | toJSON }}`:                     `["a","b"]`,
+	} {
+		got, err := testTemplate(template)
+		if err != nil {
+			t.Errorf("unexpected error for %q: %v", template, err)
+			continue
+		}
+		assert.Equal(t, expected, got, template)
+	}
+
+	_, err := testTemplate(`{{ .API_KEY | required "API_KEY must be set" }}`)
+	assert.Contains(t, err.Error(), "API_KEY must be set")
+	_, err = testTemplate(`{{ div 1 0 }}`)
+	assert.Contains(t, err.Error(), "division by zero")
+	_, err = testTemplate(`{{ add .NAME 1 }}`)
+	assert.Contains(t, err.Error(), "invalid syntax")
+	_, err = testTemplate(`{{ file "/no/such/file" }}`)
+	assert.Contains(t, err.Error(), "no such file or directory")
+}
+
+func TestTemplateReferences(t *testing.T) {
+	tmpl, err := NewTemplate([]byte(`{{ .NAME }}
+{{ if .DEBUG }}debug: {{ $.LEVEL | default "info" }} {{ .DEBUG }}{{ end }}
+{{ range $i := loop .COUNT }}{{ .Ignored }}{{ else }}{{ .EMPTY }}{{ end }}
+{{ with .SERVICE }}{{ .Port }}{{ end }} {{ env "OPTIONAL" }} {{ .PORT }}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl.Env = parseEnvironment([]string{"NAME=app", "COUNT=0"})
+	assert.Equal(t, []Reference{
+		{Name: "NAME", Line: 1, Col: 4},
+		{Name: "DEBUG", Line: 2, Col: 7, Optional: true},
+		{Name: "LEVEL", Line: 2, Col: 27, Optional: true},
+		{Name: "DEBUG", Line: 2, Col: 57, Optional: true},
+		{Name: "COUNT", Line: 3, Col: 21},
+		{Name: "EMPTY", Line: 3, Col: 57},
+		{Name: "SERVICE", Line: 4, Col: 9, Optional: true},
+		{Name: "OPTIONAL", Line: 4, Col: 48, Optional: true},
+		{Name: "PORT", Line: 4, Col: 65},
+	}, tmpl.References())
+
+	assert.EqualError(t, tmpl.CheckDefined(), "undefined environment variables: "+
+		"EMPTY (line 3, col 57), PORT (line 4, col 65)")
+
+	tmpl.Env["EMPTY"], tmpl.Env["PORT"] = "", ""
+	assert.NoError(t, tmpl.CheckDefined())
+}
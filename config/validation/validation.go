@@ -0,0 +1,166 @@
+// Package validation collects the problems found while validating a
+// config, so that they can all be reported together with their location
+package validation
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/mitchellh/mapstructure"
+)
+
+// Error is a problem with the config value at Path
+type Error struct {
+	Path string // like `jobs[2].health.interval`
+	File string // the config file that the value came from, if known
+	Line int    // the line of the value in the config file, if known
+	Err  error
+}
+
+// New returns an Error for the config value at path
+func New(path string, err error) *Error {
+	return &Error{Path: path, Err: err}
+}
+
+// Errorf returns an Error for the config value at path, formatted
+// according to the format specifier
+func Errorf(path, format string, args ...interface{}) *Error {
+	return &Error{Path: path, Err: fmt.Errorf(format, args...)}
+}
+
+func (err *Error) Error() string {
+	msg := err.Err.Error()
+	if err.Path != "" {
+		msg = err.Path + ": " + msg
+	}
+	switch {
+	case err.File != "" && err.Line > 0:
+		msg = fmt.Sprintf("%s (%s:%d)", msg, err.File, err.Line)
+	case err.Line > 0:
+		msg = fmt.Sprintf("%s (line %d)", msg, err.Line)
+	}
+	return msg
+}
+
+// Unwrap returns the underlying error
+func (err *Error) Unwrap() error {
+	return err.Err
+}
+
+// Errors is every problem found while validating a config
+type Errors []*Error
+
+func (errs Errors) Error() string {
+	msgs := make([]string, len(errs))
+	for i, err := range errs {
+		msgs[i] = err.Error()
+	}
+	return strings.Join(msgs, "\n")
+}
+
+// Add adds the error for the config value at path. If err is an Error or
+// Errors, their paths are taken to be relative to path. A nil err is
+// ignored so that the result of a validation function can be passed in.
+func (errs *Errors) Add(path string, err error) {
+	switch e := err.(type) {
+	case nil:
+	case *Error:
+		if e == nil {
+			return
+		}
+		located := *e
+		located.Path = Join(path, e.Path)
+		*errs = append(*errs, &located)
+	case Errors:
+		for _, err := range e {
+			errs.Add(path, err)
+		}
+	default:
+		*errs = append(*errs, New(path, err))
+	}
+}
+
+// Addf adds an error for the config value at path, formatted according
+// to the format specifier
+func (errs *Errors) Addf(path, format string, args ...interface{}) {
+	errs.Add(path, fmt.Errorf(format, args...))
+}
+
+// ErrorOrNil returns nil if there are no errors, so that callers don't
+// return a non-nil error interface holding an empty Errors
+func (errs Errors) ErrorOrNil() error {
+	if len(errs) == 0 {
+		return nil
+	}
+	return errs
+}
+
+// Locate sets the file and line of each error from the location of its
+// value, as returned by lookup
+func (errs Errors) Locate(lookup func(path string) (file string, line int)) {
+	for _, err := range errs {
+		if err.Line == 0 {
+			err.File, err.Line = lookup(err.Path)
+		}
+	}
+}
+
+// Join returns the path of key within the value at path
+func Join(path, key string) string {
+	switch {
+	case path == "":
+		return key
+	case key == "":
+		return path
+	case strings.HasPrefix(key, "["):
+		return path + key
+	}
+	return path + "." + key
+}
+
+// Index returns the path of the ith item of the list at path
+func Index(path string, i int) string {
+	return fmt.Sprintf("%s[%d]", path, i)
+}
+
+// mapstructure reports each problem as `'path' message` or, for weakly
+// typed values, `cannot parse 'path' as type: message`
+var (
+	decodeErrPattern  = regexp.MustCompile(`^'([^']*)' (.*)$`)
+	decodeWeakPattern = regexp.MustCompile(`^cannot parse '([^']*)' (as .*)$`)
+)
+
+// FromDecode converts an error from decoding the config value at path
+// into Errors, with a separate error for each problem and each unknown
+// key that mapstructure found
+func FromDecode(path string, err error) Errors {
+	var errs Errors
+	// decoding a single value returns its error unwrapped
+	msgs := []string{err.Error()}
+	if decodeErr, ok := err.(*mapstructure.Error); ok {
+		msgs = decodeErr.Errors
+	}
+	for _, msg := range msgs {
+		match := decodeErrPattern.FindStringSubmatch(msg)
+		if match == nil {
+			match = decodeWeakPattern.FindStringSubmatch(msg)
+			if match != nil {
+				match[2] = "cannot parse " + match[2]
+			}
+		}
+		if match == nil {
+			errs.Addf(path, "%s", msg)
+			continue
+		}
+		fieldPath := Join(path, match[1])
+		if keys := strings.TrimPrefix(match[2], "has invalid keys: "); keys != match[2] {
+			for _, key := range strings.Split(keys, ", ") {
+				errs.Addf(Join(fieldPath, key), "is not a known config key")
+			}
+			continue
+		}
+		errs.Addf(fieldPath, "%s", match[2])
+	}
+	return errs
+}
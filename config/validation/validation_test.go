@@ -0,0 +1,70 @@
+package validation
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/asokolov365/containerpilot/config/decode"
+)
+
+func TestErrorsAdd(t *testing.T) {
+	var errs Errors
+	errs.Add("jobs", nil)
+	assert.Nil(t, errs.ErrorOrNil(), "expected nil errors to be ignored")
+
+	errs.Add("jobs[0]", errors.New("must have a name"))
+	errs.Add("jobs[1]", Errorf("health.interval", "must be > 0"))
+	var nested Errors
+	nested.Addf("ttl", "must be > 0")
+	nested.Add("[2]", New("", errors.New("is invalid")))
+	errs.Add("jobs[1].health", nested)
+	assert.Equal(t, "jobs[0]: must have a name\n"+
+		"jobs[1].health.interval: must be > 0\n"+
+		"jobs[1].health.ttl: must be > 0\n"+
+		"jobs[1].health[2]: is invalid", errs.Error())
+}
+
+func TestErrorsLocate(t *testing.T) {
+	errs := Errors{Errorf("jobs[0].name", "is required"), Errorf("consul", "is invalid")}
+	errs.Locate(func(path string) (string, int) {
+		if path == "consul" {
+			return "base.json5", 2
+		}
+		return "", 7
+	})
+	assert.Equal(t, "jobs[0].name: is required (line 7)\n"+
+		"consul: is invalid (base.json5:2)", errs.Error())
+}
+
+func TestFromDecode(t *testing.T) {
+	var result struct {
+		Port   int `mapstructure:"port"`
+		Health struct {
+			TTL int `mapstructure:"ttl"`
+		} `mapstructure:"health"`
+	}
+	err := decode.ToStruct(map[string]interface{}{
+		"port":   "eighty",
+		"bogus":  true,
+		"health": map[string]interface{}{"ttl": []interface{}{}},
+	}, &result)
+	errs := FromDecode("jobs[1]", err)
+	msgs := []string{}
+	for _, err := range errs {
+		msgs = append(msgs, err.Error())
+	}
+	assert.ElementsMatch(t, []string{
+		"jobs[1].bogus: is not a known config key",
+		"jobs[1].port: cannot parse as int: strconv.ParseInt: parsing \"eighty\": invalid syntax",
+		"jobs[1].health.ttl: expected type 'int', got unconvertible type '[]interface {}', value: '[]'",
+	}, msgs)
+}
+
+func TestFromDecodeValue(t *testing.T) {
+	var stopTimeout int
+	err := decode.ToStruct("soon", &stopTimeout)
+	assert.EqualError(t, FromDecode("stopTimeout", err),
+		"stopTimeout: cannot parse as int: strconv.ParseInt: parsing \"soon\": invalid syntax")
+}
@@ -1,9 +1,8 @@
 package control
 
 import (
-	"fmt"
-
 	"github.com/asokolov365/containerpilot/config/decode"
+	"github.com/asokolov365/containerpilot/config/validation"
 )
 
 // DefaultSocket is the default location of the unix domain socket file
@@ -16,7 +15,7 @@ type Config struct {
 }
 
 // NewConfig parses a json config into a validated Config used by control
-// Server.
+// Server. Decoding problems are returned as validation.Errors.
 func NewConfig(raw interface{}) (*Config, error) {
 	cfg := &Config{SocketPath: DefaultSocket} // defaults
 	if raw == nil {
@@ -24,7 +23,7 @@ func NewConfig(raw interface{}) (*Config, error) {
 	}
 
 	if err := decode.ToStruct(raw, cfg); err != nil {
-		return nil, fmt.Errorf("control config parsing error: %v", err)
+		return nil, validation.FromDecode("control", err)
 	}
 
 	return cfg, nil
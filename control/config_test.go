@@ -34,3 +34,12 @@ func TestControlConfigParse(t *testing.T) {
 		t.Fatal("parsed socket does not match custom socket")
 	}
 }
+
+func TestControlConfigError(t *testing.T) {
+	testRaw := tests.DecodeRaw(`{ "sock": "/var/run/cp3.sock" }`)
+	_, err := NewConfig(testRaw)
+	expected := "control.sock: is not a known config key"
+	if err == nil || err.Error() != expected {
+		t.Fatalf("expected '%s' but got '%v'", expected, err)
+	}
+}
@@ -39,6 +39,15 @@ type HTTPServer struct {
 	Addr string
 	Bus  *events.EventBus
 
+	// Reload applies a new configuration without restarting the App. If
+	// it's not set, a reload shuts down the EventBus so that the App can
+	// restart with the new configuration.
+	Reload func() error
+
+	// HasJob returns true if a job is configured, so that a request for
+	// maintenance mode of an unknown job can be rejected
+	HasJob func(name string) bool
+
 	http.Server
 	events.Publisher
 }
@@ -91,6 +100,8 @@ func (srv *HTTPServer) Start(cancel context.CancelFunc) {
 	endpoints := &Endpoints{
 		bus:    srv.Publisher.Bus,
 		cancel: cancel,
+		reload: srv.Reload,
+		hasJob: srv.HasJob,
 	}
 
 	router := http.NewServeMux()
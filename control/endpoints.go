@@ -19,6 +19,14 @@ import (
 type Endpoints struct {
 	bus    *events.EventBus
 	cancel context.CancelFunc
+	reload func() error
+	hasJob func(name string) bool
+}
+
+// errorResponse is the JSON body of a request that failed for a reason
+// the client needs to know about
+type errorResponse struct {
+	Error string `json:"error"`
 }
 
 // PostHandler is an adapter which allows a normal function to serve itself and
@@ -46,7 +54,13 @@ func (pw PostHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 			io.WriteString(w, "\n")
 		}
 	default:
-		http.Error(w, http.StatusText(status), status)
+		if resp != nil {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(status)
+			json.NewEncoder(w).Encode(resp)
+		} else {
+			http.Error(w, http.StatusText(status), status)
+		}
 	}
 	collector.WithLabelValues(strconv.Itoa(status), r.URL.Path).Inc()
 }
@@ -72,13 +86,23 @@ func (e Endpoints) PutEnviron(r *http.Request) (interface{}, int) {
 }
 
 // PostReload handles incoming HTTP POST requests and reloads our current
-// ContainerPilot process configuration.  Returns empty response or HTTP422.
+// ContainerPilot process configuration. Returns empty response, or HTTP422
+// with the error if the new configuration failed validation, in which case
+// ContainerPilot keeps running with the current configuration.
 func (e Endpoints) PostReload(r *http.Request) (interface{}, int) {
-	defer e.cancel()
 	log.Debug("control: reloading app via control plane")
 	if r.Body != nil {
 		defer r.Body.Close()
 	}
+	if e.reload != nil {
+		if err := e.reload(); err != nil {
+			log.Errorf("control: failed to reload app: %v", err)
+			return errorResponse{Error: err.Error()}, http.StatusUnprocessableEntity
+		}
+		log.Debug("control: reloaded app via control plane")
+		return nil, http.StatusOK
+	}
+	defer e.cancel()
 	e.bus.SetReloadFlag()
 	e.bus.Shutdown()
 	log.Debug("control: reloaded app via control plane")
@@ -86,22 +110,32 @@ func (e Endpoints) PostReload(r *http.Request) (interface{}, int) {
 }
 
 // PostEnableMaintenanceMode handles incoming HTTP POST requests and toggles
-// ContainerPilot maintenance mode on. Returns empty response or HTTP422.
+// ContainerPilot maintenance mode on, for all the jobs or for the job given
+// by the 'job' query parameter. Returns empty response or HTTP422.
 func (e Endpoints) PostEnableMaintenanceMode(r *http.Request) (interface{}, int) {
-	if r.Body != nil {
-		defer r.Body.Close()
-	}
-	e.bus.Publish(events.GlobalEnterMaintenance)
-	return nil, http.StatusOK
+	return e.publishMaintenance(r, events.EnterMaintenance)
 }
 
 // PostDisableMaintenanceMode handles incoming HTTP POST requests and toggles
-// ContainerPilot maintenance mode on. Returns empty response or HTTP422.
+// ContainerPilot maintenance mode off, for all the jobs or for the job given
+// by the 'job' query parameter. Returns empty response or HTTP422.
 func (e Endpoints) PostDisableMaintenanceMode(r *http.Request) (interface{}, int) {
+	return e.publishMaintenance(r, events.ExitMaintenance)
+}
+
+func (e Endpoints) publishMaintenance(r *http.Request, code events.EventCode) (interface{}, int) {
 	if r.Body != nil {
 		defer r.Body.Close()
 	}
-	e.bus.Publish(events.GlobalExitMaintenance)
+	source := "global"
+	if job := r.URL.Query().Get("job"); job != "" {
+		if e.hasJob != nil && !e.hasJob(job) {
+			return errorResponse{Error: fmt.Sprintf("'%s' is not a configured job", job)},
+				http.StatusUnprocessableEntity
+		}
+		source = job
+	}
+	e.bus.Publish(events.Event{Code: code, Source: source})
 	return nil, http.StatusOK
 }
 
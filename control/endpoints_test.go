@@ -90,6 +90,16 @@ func TestPostHandler(t *testing.T) {
 			"expected JSON body in reply")
 	})
 
+	t.Run("POST JSON error", func(t *testing.T) {
+		req := httptest.NewRequest("POST", "/v3/foo", nil)
+		status, result := testFunc(req, func(r *http.Request) (interface{}, int) {
+			return errorResponse{Error: "bad config"}, 422
+		})
+		assert.Equal(t, 422, status, "expected HTTP422")
+		assert.Equal(t, "{\"error\":\"bad config\"}\n", result,
+			"expected JSON error body in reply")
+	})
+
 	t.Run("GET bad method", func(t *testing.T) {
 		req := httptest.NewRequest("GET", "/v3/foo", nil)
 		status, result := testFunc(req,
@@ -101,6 +111,43 @@ func TestPostHandler(t *testing.T) {
 	})
 }
 
+func TestPostReload(t *testing.T) {
+	t.Run("POST incremental reload", func(t *testing.T) {
+		reloaded := 0
+		bus := events.NewEventBus()
+		endpoints := &Endpoints{
+			bus:    bus,
+			reload: func() error { reloaded++; return nil },
+		}
+		req, _ := http.NewRequest("POST", "/v3/reload", nil)
+		_, status := endpoints.PostReload(req)
+		assert.Equal(t, http.StatusOK, status, "status was not 200OK")
+		assert.Equal(t, 1, reloaded, "expected reload func to be called")
+		assert.Equal(t, []events.Event{}, bus.DebugEvents(),
+			"expected no shutdown for incremental reload")
+	})
+	t.Run("POST failed reload", func(t *testing.T) {
+		endpoints := &Endpoints{
+			bus:    events.NewEventBus(),
+			reload: func() error { return fmt.Errorf("bad config") },
+		}
+		req, _ := http.NewRequest("POST", "/v3/reload", nil)
+		resp, status := endpoints.PostReload(req)
+		assert.Equal(t, http.StatusUnprocessableEntity, status, "status was not 422")
+		assert.Equal(t, errorResponse{Error: "bad config"}, resp)
+	})
+	t.Run("POST full reload", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		bus := events.NewEventBus()
+		endpoints := &Endpoints{bus: bus, cancel: cancel}
+		req, _ := http.NewRequest("POST", "/v3/reload", nil)
+		_, status := endpoints.PostReload(req)
+		assert.Equal(t, http.StatusOK, status, "status was not 200OK")
+		assert.Equal(t, []events.Event{events.GlobalShutdown}, bus.DebugEvents())
+		assert.Error(t, ctx.Err(), "expected control server to be cancelled")
+	})
+}
+
 func TestPostMetric(t *testing.T) {
 	testFunc := func(t *testing.T, expected map[events.Event]int, body string) int {
 		_, cancel := context.WithCancel(context.Background())
@@ -180,6 +227,47 @@ func TestPostEnableMaintenanceMode(t *testing.T) {
 	})
 }
 
+func TestPostMaintenanceModeForJob(t *testing.T) {
+	testFunc := func(t *testing.T, expected map[events.Event]int, path string) (interface{}, int) {
+		bus := events.NewEventBus()
+		endpoints := &Endpoints{
+			bus:    bus,
+			hasJob: func(name string) bool { return name == "myjob" },
+		}
+		req, _ := http.NewRequest("POST", path, nil)
+		handler := endpoints.PostEnableMaintenanceMode
+		if strings.Contains(path, "disable") {
+			handler = endpoints.PostDisableMaintenanceMode
+		}
+		resp, status := handler(req)
+		got := map[events.Event]int{}
+		for _, result := range bus.DebugEvents() {
+			got[result]++
+		}
+		assert.Equal(t, expected, got)
+		return resp, status
+	}
+
+	t.Run("POST enable job", func(t *testing.T) {
+		expected := map[events.Event]int{
+			{Code: events.EnterMaintenance, Source: "myjob"}: 1}
+		_, status := testFunc(t, expected, "/v3/maintenance/enable?job=myjob")
+		assert.Equal(t, http.StatusOK, status, "status was not 200OK")
+	})
+	t.Run("POST disable job", func(t *testing.T) {
+		expected := map[events.Event]int{
+			{Code: events.ExitMaintenance, Source: "myjob"}: 1}
+		_, status := testFunc(t, expected, "/v3/maintenance/disable?job=myjob")
+		assert.Equal(t, http.StatusOK, status, "status was not 200OK")
+	})
+	t.Run("POST unknown job", func(t *testing.T) {
+		resp, status := testFunc(t, map[events.Event]int{},
+			"/v3/maintenance/enable?job=other")
+		assert.Equal(t, http.StatusUnprocessableEntity, status)
+		assert.Equal(t, errorResponse{Error: "'other' is not a configured job"}, resp)
+	})
+}
+
 func TestPostDisableMaintenanceMode(t *testing.T) {
 	testFunc := func(t *testing.T, expected map[events.Event]int, req *http.Request) int {
 		_, cancel := context.WithCancel(context.Background())
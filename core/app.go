@@ -4,6 +4,7 @@ package core
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"os"
 	"strings"
@@ -14,6 +15,7 @@ import (
 	"github.com/asokolov365/containerpilot/control"
 	"github.com/asokolov365/containerpilot/events"
 	"github.com/asokolov365/containerpilot/jobs"
+	"github.com/asokolov365/containerpilot/signals"
 	"github.com/asokolov365/containerpilot/surveillee"
 	"github.com/asokolov365/containerpilot/telemetry"
 	"github.com/asokolov365/containerpilot/watches"
@@ -28,28 +30,47 @@ type App struct {
 	Jobs            []*jobs.Job
 	Watches         []*watches.Watch
 	Telemetry       *telemetry.Telemetry
+	Signals         []*signals.Config
 	StopTimeout     int
 	signalLock      *sync.RWMutex
+	handlingSignals bool
+	actionCh        chan os.Signal // receives the signals mapped to actions
 	ConfigFlag      string
 	Bus             *events.EventBus
+
+	// state kept so that we can reload the config incrementally
+	cfg             *config.Config
+	pendingCfg      *config.Config
+	reloadLock      *sync.Mutex
+	jobsLock        *sync.RWMutex
+	runCtx          context.Context
+	completedCh     chan struct{}
+	controlCancel   context.CancelFunc
+	telemetryCancel context.CancelFunc
 }
 
 // EmptyApp creates an empty application
 func EmptyApp() *App {
 	app := &App{}
 	app.signalLock = &sync.RWMutex{}
+	app.reloadLock = &sync.Mutex{}
+	app.jobsLock = &sync.RWMutex{}
 	return app
 }
 
 // NewApp creates a new App from the config
 func NewApp(configFlag string) (*App, error) {
 	os.Setenv("CONTAINERPILOT_PID", fmt.Sprintf("%v", os.Getpid()))
-	a := EmptyApp()
 	cfg, err := config.LoadConfig(configFlag)
 	if err != nil {
 		return nil, err
 	}
+	return newApp(cfg, configFlag)
+}
 
+// newApp creates a new App from a config that has already been loaded
+func newApp(cfg *config.Config, configFlag string) (*App, error) {
+	a := EmptyApp()
 	if err := cfg.InitLogging(); err != nil {
 		return nil, err
 	}
@@ -76,14 +97,16 @@ func NewApp(configFlag string) (*App, error) {
 	a.Telemetry = telemetry.NewTelemetry(cfg.Telemetry)
 	a.Telemetry.MonitorJobs(a.Jobs)
 	a.Telemetry.MonitorWatches(a.Watches)
+	a.Signals = cfg.Signals
 	a.ConfigFlag = configFlag // stash the old config
+	a.cfg = cfg
 
 	// set an environment variable for each job IP address so that
 	// forked processes have access to this information
 	for _, job := range a.Jobs {
-		if job.Service != nil {
+		if len(job.Services) > 0 {
 			envKey := getEnvVarNameFromService(job.Name)
-			os.Setenv(envKey, job.Service.IPAddress)
+			os.Setenv(envKey, job.Services[0].IPAddress)
 		}
 	}
 
@@ -102,6 +125,12 @@ func getEnvVarNameFromService(service string) string {
 func (a *App) Run() {
 	a.handleSignals()
 
+	// the config files are watched for as long as we run, rather than
+	// for each pass, which a full reload replaces
+	watchCtx, stopWatching := context.WithCancel(context.Background())
+	defer stopWatching()
+	a.watchConfig(watchCtx)
+
 	for {
 		ctx, cancel := context.WithCancel(context.Background())
 
@@ -126,11 +155,16 @@ func (a *App) Run() {
 				select {
 				case <-completedCh:
 					quit := true
+					// a reload swaps in the jobs it's going to start
+					// before it stops the old ones, so that we don't
+					// quit while they're being replaced
+					a.jobsLock.RLock()
 					for _, job := range a.Jobs {
 						if !job.IsComplete {
 							quit = false
 						}
 					}
+					a.jobsLock.RUnlock()
 					if quit {
 						cancel()
 						return
@@ -142,7 +176,9 @@ func (a *App) Run() {
 		}()
 
 		a.Bus = events.NewEventBus()
-		a.ControlServer.Run(ctx, a.Bus)
+		a.ControlServer.Reload = a.Reload
+		a.ControlServer.HasJob = a.hasJob
+		a.runControlServer(ctx)
 		a.runTasks(ctx, completedCh)
 
 		if !a.Bus.Wait() {
@@ -157,9 +193,24 @@ func (a *App) Run() {
 			}
 			break
 		}
+		// the control and telemetry servers are started again by the
+		// next pass, so they have to let go of their socket and port
+		a.controlCancel()
+		if a.telemetryCancel != nil {
+			a.telemetryCancel()
+			a.telemetryCancel = nil
+		}
+		// a reload from the config watcher until the next pass starts
+		// would otherwise be applied to this one
+		cancel()
 		if err := a.reload(); err != nil {
 			log.Error(err)
-			break
+			reloadSuccessGauge.Set(0)
+			// keep running with the config we had before the reload
+			if err := a.rollback(); err != nil {
+				log.Errorf("unable to roll back config: %v", err)
+				break
+			}
 		}
 		close(completedCh)
 	}
@@ -179,15 +230,63 @@ func (a *App) SignalEvent(sig string) {
 	a.Bus.PublishSignal(sig)
 }
 
+// hasJob returns true if a job with the name is configured
+func (a *App) hasJob(name string) bool {
+	a.jobsLock.RLock()
+	defer a.jobsLock.RUnlock()
+	for _, job := range a.Jobs {
+		if job.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
 // reload does the actual work of reloading the configuration and
 // updating the App with those changes. The EventBus should be
-// already shut down before we call this.
+// already shut down before we call this. If Reload has already
+// validated the new config, we use that config rather than loading
+// the file again.
 func (a *App) reload() error {
-	newApp, err := NewApp(a.ConfigFlag)
+	a.reloadLock.Lock()
+	defer a.reloadLock.Unlock()
+	var (
+		next *App
+		err  error
+	)
+	if cfg := a.pendingCfg; cfg != nil {
+		a.pendingCfg = nil
+		next, err = newApp(cfg, a.ConfigFlag)
+	} else {
+		next, err = NewApp(a.ConfigFlag)
+	}
 	if err != nil {
 		log.Errorf("error initializing config: %v", err)
 		return err
 	}
+	a.replace(next)
+	return nil
+}
+
+// rollback restores the App from the last config that was applied,
+// after a reload of a new config has failed
+func (a *App) rollback() error {
+	a.reloadLock.Lock()
+	defer a.reloadLock.Unlock()
+	if a.cfg == nil {
+		return errors.New("no previous config")
+	}
+	log.Warn("reload: rolling back to the previous config")
+	next, err := newApp(a.cfg, a.ConfigFlag)
+	if err != nil {
+		return err
+	}
+	a.replace(next)
+	return nil
+}
+
+// replace updates the App with the state of the newly created App
+func (a *App) replace(newApp *App) {
 	// a.Discovery = newApp.Discovery
 	// a.FileWatcher = newApp.FileWatcher
 	a.SurveilServices = newApp.SurveilServices
@@ -196,12 +295,43 @@ func (a *App) reload() error {
 	a.StopTimeout = newApp.StopTimeout
 	a.Telemetry = newApp.Telemetry
 	a.ControlServer = newApp.ControlServer
-	return nil
+	a.cfg = newApp.cfg
+	a.setSignals(newApp.Signals)
+}
+
+// runControlServer runs the control server with its own context, so
+// that it can be replaced if the socket changes on reload
+func (a *App) runControlServer(pctx context.Context) {
+	ctx, cancel := context.WithCancel(pctx)
+	a.controlCancel = cancel
+	a.ControlServer.Run(ctx, a.Bus)
+}
+
+// runTelemetry runs the telemetry server and metrics with their own
+// context, so that they can be replaced if they change on reload
+func (a *App) runTelemetry(pctx context.Context) {
+	if a.Telemetry == nil {
+		return
+	}
+	ctx, cancel := context.WithCancel(pctx)
+	a.telemetryCancel = cancel
+	a.Telemetry.MonitorEvents(a.Bus)
+	for _, metric := range a.Telemetry.Metrics {
+		metric.Run(ctx, a.Bus)
+	}
+	a.Telemetry.Run(ctx)
 }
 
 // HandlePolling sets up polling functions and write their quit channels
 // back to our config
 func (a *App) runTasks(ctx context.Context, completedCh chan struct{}) {
+	// stash the context and channel so that jobs started by an
+	// incremental reload share them with the rest of the jobs
+	a.reloadLock.Lock()
+	a.runCtx = ctx
+	a.completedCh = completedCh
+	a.reloadLock.Unlock()
+
 	// we need to subscribe to events before we Run all the jobs
 	// to avoid races where a job finishes and fires events before
 	// other jobs are even subscribed to listen for them.
@@ -215,12 +345,7 @@ func (a *App) runTasks(ctx context.Context, completedCh chan struct{}) {
 	for _, watch := range a.Watches {
 		watch.Run(ctx, a.Bus)
 	}
-	if a.Telemetry != nil {
-		for _, metric := range a.Telemetry.Metrics {
-			metric.Run(ctx, a.Bus)
-		}
-		a.Telemetry.Run(ctx)
-	}
+	a.runTelemetry(ctx)
 	// kick everything off
 	a.Bus.Publish(events.GlobalStartup)
 }
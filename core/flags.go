@@ -6,6 +6,7 @@ import (
 	"os"
 	"strings"
 
+	"github.com/asokolov365/containerpilot/config"
 	"github.com/asokolov365/containerpilot/subcommands"
 	"github.com/asokolov365/containerpilot/version"
 )
@@ -41,19 +42,45 @@ func (f MultiFlag) Len() int {
 	return len(f.Values)
 }
 
+// FormatFlag provides a custom CLI flag that forces the format of the
+// config files, rather than detecting it from their file extensions.
+type FormatFlag struct {
+	Value string
+}
+
+// String satisfies the flag.Value interface.
+func (f FormatFlag) String() string {
+	return f.Value
+}
+
+// Set satisfies the flag.Value interface by validating the format and
+// setting it for the config loader.
+func (f *FormatFlag) Set(value string) error {
+	if err := config.SetFormat(value); err != nil {
+		return err
+	}
+	f.Value = value
+	return nil
+}
+
 // GetArgs parses the command line flags and returns the subcommand
 // we need and its parameters (if any)
 func GetArgs() (subcommands.Handler, subcommands.Params) {
 
 	var versionFlag bool
 	var templateFlag bool
+	var templateStrictFlag bool
+	var templateVarsFlag bool
+	var validateFlag bool
 	var reloadFlag bool
 	var pingFlag bool
 
 	var configPath string
 	var renderFlag string
 	var maintFlag string
+	var jobFlag string
 
+	var formatFlag FormatFlag
 	var putMetricFlags MultiFlag
 	var putEnvFlags MultiFlag
 
@@ -64,11 +91,26 @@ func GetArgs() (subcommands.Handler, subcommands.Params) {
 		flag.BoolVar(&templateFlag, "template", false,
 			"Render template and quit.")
 
+		flag.BoolVar(&templateStrictFlag, "template-strict", false,
+			`Fail if the configuration template uses any environment variables that
+	aren't set, rather than rendering them as empty strings.`)
+
+		flag.BoolVar(&templateVarsFlag, "template-vars", false,
+			`Print the environment variables used by the configuration template to
+	stderr when '-template' is used.`)
+
+		flag.BoolVar(&validateFlag, "validate", false,
+			"Validate the configuration, print any errors, and quit.")
+
 		flag.BoolVar(&reloadFlag, "reload", false,
 			"Reload a ContainerPilot process through its control socket.")
 
 		flag.StringVar(&configPath, "config", "",
-			"File path to JSON5 configuration file. Defaults to CONTAINERPILOT env var.")
+			"File path to JSON5, YAML, or TOML configuration file, or to a directory of\n\tconfiguration files. Defaults to CONTAINERPILOT env var.")
+
+		flag.Var(&formatFlag, "config-format",
+			`Format of the configuration files: 'json5', 'yaml', or 'toml'.
+	Defaults to detecting the format from each file's extension.`)
 
 		flag.StringVar(&renderFlag, "out", "",
 			`File path where to save rendered config file when '-template' is used.
@@ -78,6 +120,10 @@ func GetArgs() (subcommands.Handler, subcommands.Params) {
 			`Toggle maintenance mode for a ContainerPilot process through its control socket.
 	Options: '-maintenance enable' or '-maintenance disable'`)
 
+		flag.StringVar(&jobFlag, "job", "",
+			`Name of the job to toggle maintenance mode for when '-maintenance' is used.
+	Defaults to all the jobs.`)
+
 		flag.Var(&putMetricFlags, "putmetric",
 			`Update metrics of a ContainerPilot process through its control socket.
 	Pass metrics in the format: 'key=value'`)
@@ -101,10 +147,19 @@ func GetArgs() (subcommands.Handler, subcommands.Params) {
 	if configPath == "" {
 		configPath = os.Getenv("CONTAINERPILOT")
 	}
+	if templateStrictFlag {
+		config.SetTemplateStrict(true)
+	}
 	if templateFlag {
 		return subcommands.RenderHandler, subcommands.Params{
+			ConfigPath:   configPath,
+			RenderFlag:   renderFlag,
+			TemplateVars: templateVarsFlag,
+		}
+	}
+	if validateFlag {
+		return subcommands.ValidateHandler, subcommands.Params{
 			ConfigPath: configPath,
-			RenderFlag: renderFlag,
 		}
 	}
 	if reloadFlag {
@@ -116,6 +171,7 @@ func GetArgs() (subcommands.Handler, subcommands.Params) {
 		return subcommands.MaintenanceHandler, subcommands.Params{
 			ConfigPath:      configPath,
 			MaintenanceFlag: maintFlag,
+			JobFlag:         jobFlag,
 		}
 	}
 	if putEnvFlags.Len() != 0 {
@@ -0,0 +1,314 @@
+package core
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/asokolov365/containerpilot/config"
+	"github.com/asokolov365/containerpilot/control"
+	"github.com/asokolov365/containerpilot/events"
+	"github.com/asokolov365/containerpilot/jobs"
+	"github.com/asokolov365/containerpilot/telemetry"
+	"github.com/asokolov365/containerpilot/watches"
+
+	"github.com/prometheus/client_golang/prometheus"
+	log "github.com/sirupsen/logrus"
+)
+
+var reloadSuccessGauge prometheus.Gauge
+
+func init() {
+	reloadSuccessGauge = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "containerpilot_config_last_reload_successful",
+		Help: "whether the last config reload was applied (1) or failed and was rolled back (0)",
+	})
+	reloadSuccessGauge.Set(1)
+	prometheus.MustRegister(reloadSuccessGauge)
+}
+
+// Reload loads the configuration file again and applies only what has
+// changed since it was last loaded. Jobs, watches, and the telemetry and
+// control servers that haven't changed keep running, and so do their
+// processes and service registrations. If the discovery or secrets
+// backends have changed then we fall back to restarting everything.
+//
+// The new config is fully validated, and its backends checked for
+// connectivity, before anything is stopped. If it fails we keep running
+// with the current config and return the error.
+func (a *App) Reload() error {
+	a.reloadLock.Lock()
+	defer a.reloadLock.Unlock()
+	err := a.reloadIncrementally()
+	if err != nil {
+		reloadSuccessGauge.Set(0)
+		return err
+	}
+	reloadSuccessGauge.Set(1)
+	return nil
+}
+
+func (a *App) reloadIncrementally() error {
+	if a.runCtx == nil || a.runCtx.Err() != nil {
+		return errors.New("unable to reload: not running")
+	}
+	cfg, err := config.LoadConfig(a.ConfigFlag)
+	if err != nil {
+		return err
+	}
+	if err := cfg.Preflight(a.cfg); err != nil {
+		return err
+	}
+	if a.cfg == nil || !cfg.SameBackends(a.cfg) {
+		log.Info("reload: backends have changed, restarting all jobs")
+		a.pendingCfg = cfg
+		a.Bus.SetReloadFlag()
+		a.Bus.Shutdown()
+		return nil
+	}
+	var controlServer *control.HTTPServer
+	if cfg.Control.SocketPath != a.cfg.Control.SocketPath {
+		controlServer, err = control.NewHTTPServer(cfg.Control)
+		if err != nil {
+			return err
+		}
+	}
+	if err := cfg.InitLogging(); err != nil {
+		return err
+	}
+	a.StopTimeout = cfg.StopTimeout
+	a.reloadJobs(cfg.Jobs)
+	a.reloadWatches(cfg.Watches)
+	a.reloadTelemetry(cfg.Telemetry)
+	a.setSignals(cfg.Signals)
+	a.cfg = cfg
+
+	// replacing the control server comes last, because this reload may
+	// have been requested through it
+	if controlServer != nil {
+		log.Infof("reload: moving control socket to %s", controlServer.Addr)
+		a.controlCancel()
+		a.ControlServer = controlServer
+		a.ControlServer.Reload = a.Reload
+		a.ControlServer.HasJob = a.hasJob
+		a.runControlServer(a.runCtx)
+	}
+	return nil
+}
+
+// reloadJobs stops the jobs that have been removed or changed and starts
+// the jobs that have been added or changed. Running jobs are matched to
+// their new config by name.
+func (a *App) reloadJobs(cfgs []*jobs.Config) {
+	oldCfgs := map[string]*jobs.Config{}
+	for _, cfg := range a.cfg.Jobs {
+		oldCfgs[cfg.Name] = cfg
+	}
+	newCfgs := map[string]*jobs.Config{}
+	for _, cfg := range cfgs {
+		newCfgs[cfg.Name] = cfg
+	}
+
+	a.jobsLock.Lock()
+	running := map[string]*jobs.Job{}
+	for _, job := range a.Jobs {
+		running[job.Name] = job
+	}
+	restart := jobsToRestart(oldCfgs, newCfgs, running)
+
+	// the new jobs are swapped in before the old ones have stopped, so
+	// that the jobs we're waiting on don't look like the last to complete
+	var started []*jobs.Job
+	newJobs := []*jobs.Job{}
+	for _, cfg := range cfgs {
+		if job, ok := running[cfg.Name]; ok && !restart[cfg.Name] {
+			newJobs = append(newJobs, job)
+			continue
+		}
+		job := jobs.NewJob(cfg)
+		newJobs = append(newJobs, job)
+		started = append(started, job)
+	}
+	a.Jobs = newJobs
+	a.jobsLock.Unlock()
+
+	// "pre-stop" and "post-stop" jobs for a job that's being restarted
+	// will run while it stops, so they get the same quit we send on a
+	// global shutdown. Everything else halts right away.
+	for name := range restart {
+		cfg := oldCfgs[name]
+		if cfg.IsStopHook() && restart[cfg.DependsOn()] {
+			running[name].Quit()
+		}
+	}
+	for name := range restart {
+		cfg := oldCfgs[name]
+		if !cfg.IsStopHook() || !restart[cfg.DependsOn()] {
+			log.Infof("reload: stopping job %s", name)
+			running[name].Stop()
+		}
+	}
+	var wg sync.WaitGroup
+	for name := range restart {
+		wg.Add(1)
+		go func(job *jobs.Job, removed bool) {
+			defer wg.Done()
+			<-job.Done()
+			a.waitForProcesses(job)
+			if removed {
+				job.RemoveCgroup()
+			}
+		}(running[name], newCfgs[name] == nil)
+	}
+	wg.Wait()
+
+	// as in runTasks, all the new jobs need to be subscribed before any
+	// of them run so that they don't miss each other's events
+	for _, job := range started {
+		log.Infof("reload: starting job %s", job.Name)
+		if len(job.Services) > 0 {
+			os.Setenv(getEnvVarNameFromService(job.Name), job.Services[0].IPAddress)
+		}
+		job.Subscribe(a.Bus)
+		job.Register(a.Bus)
+	}
+	for _, job := range started {
+		job.Run(a.runCtx, a.completedCh)
+	}
+	for _, job := range started {
+		job.Receive(events.GlobalStartup)
+	}
+}
+
+// waitForProcesses waits for the processes of a stopped job to exit,
+// killing them if they're still running after the stop timeout
+func (a *App) waitForProcesses(job *jobs.Job) {
+	exited := make(chan struct{})
+	go func() {
+		job.WaitForProcesses()
+		close(exited)
+	}()
+	select {
+	case <-exited:
+	case <-time.After(time.Duration(a.StopTimeout) * time.Second):
+		log.Infof("killing processes for job %#v", job.Name)
+		job.Kill()
+		<-exited
+	}
+}
+
+// jobsToRestart returns the names of the running jobs that need to be
+// stopped, either because their config has changed or been removed, or
+// because they're needed again by a job that's being started.
+func jobsToRestart(oldCfgs, newCfgs map[string]*jobs.Config,
+	running map[string]*jobs.Job) map[string]bool {
+
+	restart := map[string]bool{}
+	for name, oldCfg := range oldCfgs {
+		newCfg, ok := newCfgs[name]
+		if _, isRunning := running[name]; isRunning && (!ok || !oldCfg.Equal(newCfg)) {
+			restart[name] = true
+		}
+	}
+	for changed := true; changed; {
+		changed = false
+		for name, oldCfg := range oldCfgs {
+			// the stop hooks of a job that's restarted will fire when it
+			// stops, so they'll need to be restarted too
+			if !restart[name] && oldCfg.IsStopHook() && restart[oldCfg.DependsOn()] {
+				restart[name] = true
+				changed = true
+			}
+		}
+		for name, newCfg := range newCfgs {
+			// a job that's started has to be able to see the events of
+			// the job it depends on, so we restart that job if it has
+			// already completed
+			if _, isRunning := running[name]; isRunning && !restart[name] {
+				continue
+			}
+			dep := newCfg.DependsOn()
+			if job, ok := running[dep]; ok && !restart[dep] && job.IsComplete {
+				if _, keep := newCfgs[dep]; keep {
+					restart[dep] = true
+					changed = true
+				}
+			}
+		}
+	}
+	return restart
+}
+
+// reloadWatches stops the watches that have been removed or changed and
+// starts the watches that have been added or changed
+func (a *App) reloadWatches(cfgs []*watches.Config) {
+	oldCfgs := map[string]*watches.Config{}
+	for _, cfg := range a.cfg.Watches {
+		oldCfgs[cfg.Name] = cfg
+	}
+	running := map[string]*watches.Watch{}
+	for _, watch := range a.Watches {
+		running[watch.Name] = watch
+	}
+	kept := map[string]bool{}
+	for _, cfg := range cfgs {
+		if oldCfg, ok := oldCfgs[cfg.Name]; ok && sameConfig(oldCfg, cfg) {
+			if _, ok := running[cfg.Name]; ok {
+				kept[cfg.Name] = true
+			}
+		}
+	}
+	// the old watch has to be stopped before a new one with the same
+	// name is run, so that they don't both publish its events
+	for _, watch := range a.Watches {
+		if !kept[watch.Name] {
+			log.Infof("reload: stopping %s", watch.Name)
+			watch.Stop()
+		}
+	}
+	newWatches := []*watches.Watch{}
+	for _, cfg := range cfgs {
+		if kept[cfg.Name] {
+			newWatches = append(newWatches, running[cfg.Name])
+			continue
+		}
+		log.Infof("reload: starting %s", cfg.Name)
+		watch := watches.NewWatch(cfg)
+		watch.Run(a.runCtx, a.Bus)
+		newWatches = append(newWatches, watch)
+	}
+	a.Watches = newWatches
+}
+
+// reloadTelemetry restarts the telemetry server and metrics if they have
+// changed, and updates the jobs and watches it reports on
+func (a *App) reloadTelemetry(cfg *telemetry.Config) {
+	if !sameConfig(a.cfg.Telemetry, cfg) {
+		if a.telemetryCancel != nil {
+			log.Info("reload: stopping telemetry")
+			a.telemetryCancel()
+			a.telemetryCancel = nil
+		}
+		a.Telemetry = telemetry.NewTelemetry(cfg)
+		a.runTelemetry(a.runCtx)
+	}
+	a.Telemetry.MonitorJobs(a.Jobs)
+	a.Telemetry.MonitorWatches(a.Watches)
+}
+
+// sameConfig compares the exported fields of two configs, either of
+// which may be nil
+func sameConfig(this, that interface{}) bool {
+	a, err := json.Marshal(this)
+	if err != nil {
+		return false
+	}
+	b, err := json.Marshal(that)
+	if err != nil {
+		return false
+	}
+	return bytes.Equal(a, b)
+}
@@ -0,0 +1,244 @@
+package core
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/asokolov365/containerpilot/events"
+	"github.com/asokolov365/containerpilot/jobs"
+	"github.com/asokolov365/containerpilot/tests"
+	"github.com/asokolov365/containerpilot/tests/mocks"
+)
+
+func TestReload(t *testing.T) {
+	f := testCfgToTempFile(t, `{
+	jobs: [
+		{name: "keep", exec: ["./testdata/test.sh", "interruptSleep"]},
+		{name: "change", exec: ["./testdata/test.sh", "interruptSleep"]},
+		{name: "remove", exec: ["./testdata/test.sh", "interruptSleep"]}
+	]}`)
+	defer os.Remove(f.Name())
+	app, err := NewApp(f.Name())
+	if err != nil {
+		t.Fatalf("unexpected error in NewApp: %v", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	app.runTestTasks(ctx)
+	time.Sleep(200 * time.Millisecond) // give the processes time to start
+
+	running := map[string]*jobs.Job{}
+	for _, job := range app.Jobs {
+		running[job.Name] = job
+	}
+	keepState, _ := running["keep"].ProcessState()
+
+	err = os.WriteFile(f.Name(), []byte(`{
+	jobs: [
+		{name: "keep", exec: ["./testdata/test.sh", "interruptSleep"]},
+		{name: "change", exec: ["./testdata/test.sh", "interruptSleep"], timeout: "20s"},
+		{name: "add", exec: ["./testdata/test.sh", "interruptSleep"]}
+	]}`), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := app.Reload(); err != nil {
+		t.Fatalf("unexpected error in Reload: %v", err)
+	}
+	assert.Equal(t, float64(1), testutil.ToFloat64(reloadSuccessGauge))
+	time.Sleep(200 * time.Millisecond)
+
+	names := []string{}
+	reloaded := map[string]*jobs.Job{}
+	for _, job := range app.Jobs {
+		names = append(names, job.Name)
+		reloaded[job.Name] = job
+	}
+	assert.Equal(t, []string{"keep", "change", "add"}, names)
+	assert.True(t, running["keep"] == reloaded["keep"],
+		"expected unchanged job to be kept")
+	assert.False(t, reloaded["keep"].IsComplete, "expected unchanged job to keep running")
+	state, _ := reloaded["keep"].ProcessState()
+	assert.Equal(t, keepState.Pid, state.Pid, "expected unchanged job process to keep running")
+
+	assert.True(t, running["change"].IsComplete, "expected changed job to be stopped")
+	assert.True(t, running["remove"].IsComplete, "expected removed job to be stopped")
+	assert.False(t, running["change"] == reloaded["change"],
+		"expected changed job to be replaced")
+	for _, name := range []string{"change", "add"} {
+		state, ok := reloaded[name].ProcessState()
+		assert.True(t, ok && state.Pid != 0 && !state.Exited,
+			"expected job %s to be started", name)
+	}
+
+	cancel()
+	app.Bus.Wait()
+}
+
+func TestReloadBackendChanged(t *testing.T) {
+	// a fake Consul agent that only needs to pass the preflight check
+	consul := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte(`{"Config": {"NodeName": "node1"}}`))
+		}))
+	defer consul.Close()
+
+	f := testCfgToTempFile(t, `{jobs: [{name: "job", exec: "true"}]}`)
+	defer os.Remove(f.Name())
+	app, err := NewApp(f.Name())
+	if err != nil {
+		t.Fatalf("unexpected error in NewApp: %v", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	app.runTestTasks(ctx)
+
+	err = os.WriteFile(f.Name(), []byte(fmt.Sprintf(`{
+	consul: "%s",
+	jobs: [{name: "job", exec: "true"}]}`, consul.Listener.Addr())), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := app.Reload(); err != nil {
+		t.Fatalf("unexpected error in Reload: %v", err)
+	}
+	assert.True(t, app.Bus.Wait(), "expected full reload when backends change")
+	assert.NotNil(t, app.pendingCfg, "expected validated config to be used for full reload")
+}
+
+func TestReloadPreflightFailed(t *testing.T) {
+	f := testCfgToTempFile(t, `{jobs: [{name: "job", exec: "true"}]}`)
+	defer os.Remove(f.Name())
+	app, err := NewApp(f.Name())
+	if err != nil {
+		t.Fatalf("unexpected error in NewApp: %v", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	app.runTestTasks(ctx)
+
+	err = os.WriteFile(f.Name(), []byte(`{
+	consul: "127.0.0.1:1",
+	jobs: [{name: "job", exec: "true"}]}`), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = app.Reload()
+	assert.Error(t, err, "expected error for unreachable consul")
+	assert.Contains(t, err.Error(), "unable to reach consul")
+	assert.Nil(t, app.pendingCfg, "expected no full reload")
+	assert.Equal(t, float64(0), testutil.ToFloat64(reloadSuccessGauge))
+	cancel()
+	assert.False(t, app.Bus.Wait(), "expected no full reload")
+}
+
+func TestReloadRollback(t *testing.T) {
+	f := testCfgToTempFile(t, `{jobs: [{name: "job", exec: "true"}]}`)
+	defer os.Remove(f.Name())
+	app, err := NewApp(f.Name())
+	if err != nil {
+		t.Fatalf("unexpected error in NewApp: %v", err)
+	}
+	if err := os.WriteFile(f.Name(), []byte(`invalid`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	assert.Error(t, app.reload(), "expected error for invalid config")
+	if err := app.rollback(); err != nil {
+		t.Fatalf("unexpected error in rollback: %v", err)
+	}
+	assert.Equal(t, 1, len(app.Jobs))
+	assert.Equal(t, "job", app.Jobs[0].Name, "expected previous jobs to be restored")
+}
+
+func TestReloadInvalidConfig(t *testing.T) {
+	f := testCfgToTempFile(t, `{jobs: [{name: "job", exec: "true"}]}`)
+	defer os.Remove(f.Name())
+	app, err := NewApp(f.Name())
+	if err != nil {
+		t.Fatalf("unexpected error in NewApp: %v", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	app.runTestTasks(ctx)
+	job := app.Jobs[0]
+
+	if err := os.WriteFile(f.Name(), []byte(`invalid`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	assert.Error(t, app.Reload(), "expected error for invalid config")
+	assert.True(t, job == app.Jobs[0], "expected jobs to be unchanged")
+	assert.Equal(t, float64(0), testutil.ToFloat64(reloadSuccessGauge))
+	cancel()
+	app.Bus.Wait()
+}
+
+func TestJobsToRestart(t *testing.T) {
+	parse := func(raw string) map[string]*jobs.Config {
+		cfgs, err := jobs.NewConfigs(
+			tests.DecodeRawToSlice(raw), &mocks.NoopDiscoveryBackend{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		byName := map[string]*jobs.Config{}
+		for _, cfg := range cfgs {
+			byName[cfg.Name] = cfg
+		}
+		return byName
+	}
+	runningJobs := func(cfgs map[string]*jobs.Config) map[string]*jobs.Job {
+		running := map[string]*jobs.Job{}
+		for name, cfg := range cfgs {
+			running[name] = jobs.NewJob(cfg)
+		}
+		return running
+	}
+
+	t.Run("stop hooks", func(t *testing.T) {
+		oldCfgs := parse(`[
+		{name: "app", exec: "app"},
+		{name: "prestop", exec: "prestop", when: {source: "app", once: "stopping"}},
+		{name: "other", exec: "other"}]`)
+		newCfgs := parse(`[
+		{name: "app", exec: "app -v"},
+		{name: "prestop", exec: "prestop", when: {source: "app", once: "stopping"}},
+		{name: "other", exec: "other"}]`)
+		got := jobsToRestart(oldCfgs, newCfgs, runningJobs(oldCfgs))
+		assert.Equal(t, map[string]bool{"app": true, "prestop": true}, got)
+	})
+
+	t.Run("removed stop hook", func(t *testing.T) {
+		oldCfgs := parse(`[
+		{name: "app", exec: "app"},
+		{name: "prestop", exec: "prestop", when: {source: "app", once: "stopping"}}]`)
+		newCfgs := parse(`[{name: "app", exec: "app"}]`)
+		got := jobsToRestart(oldCfgs, newCfgs, runningJobs(oldCfgs))
+		assert.Equal(t, map[string]bool{"app": true, "prestop": true}, got)
+	})
+
+	t.Run("completed dependency", func(t *testing.T) {
+		oldCfgs := parse(`[
+		{name: "setup", exec: "setup"},
+		{name: "app", exec: "app", when: {source: "setup", once: "exitSuccess"}}]`)
+		newCfgs := parse(`[
+		{name: "setup", exec: "setup"},
+		{name: "app", exec: "app -v", when: {source: "setup", once: "exitSuccess"}}]`)
+		running := runningJobs(oldCfgs)
+		got := jobsToRestart(oldCfgs, newCfgs, running)
+		assert.Equal(t, map[string]bool{"app": true}, got)
+
+		running["setup"].IsComplete = true
+		got = jobsToRestart(oldCfgs, newCfgs, running)
+		assert.Equal(t, map[string]bool{"app": true, "setup": true}, got)
+	})
+}
+
+// runTestTasks runs the App's jobs without a control server, as Run does
+func (a *App) runTestTasks(ctx context.Context) {
+	a.Bus = events.NewEventBus()
+	a.runTasks(ctx, make(chan struct{}, len(a.Jobs)+10))
+}
@@ -4,6 +4,10 @@ import (
 	"os"
 	"os/signal"
 	"syscall"
+
+	"github.com/asokolov365/containerpilot/events"
+	"github.com/asokolov365/containerpilot/signals"
+	log "github.com/sirupsen/logrus"
 )
 
 // HandleSignals listens for and captures signals used for orchestration
@@ -15,13 +19,17 @@ func (a *App) handleSignals() {
 		syscall.SIGHUP,
 		syscall.SIGUSR2,
 	)
+	a.signalLock.Lock()
+	a.handlingSignals = true
+	a.notifySignals()
+	a.signalLock.Unlock()
 	go func() {
 		for {
 			sig := <-recvSig
 			switch sig {
 			case syscall.SIGINT, syscall.SIGTERM:
 				a.Terminate()
-			case syscall.SIGHUP, syscall.SIGUSR2:
+			default:
 				if s := toString(sig); s != "" {
 					a.SignalEvent(s)
 				}
@@ -40,3 +48,82 @@ func toString(sig os.Signal) string {
 		return ""
 	}
 }
+
+// setSignals replaces the signal actions, as when the config is reloaded
+func (a *App) setSignals(sigs []*signals.Config) {
+	a.signalLock.Lock()
+	defer a.signalLock.Unlock()
+	a.Signals = sigs
+	a.notifySignals()
+}
+
+// notifySignals subscribes a new channel to every signal that can be
+// mapped to an action, and then stops the previous one. The supervisor
+// passes all of these signals thru, so we catch them even when they're
+// not mapped: their default behavior would dump or stop the worker.
+// The caller must hold the signalLock.
+func (a *App) notifySignals() {
+	if !a.handlingSignals {
+		return
+	}
+	actionCh := make(chan os.Signal, 1)
+	signal.Notify(actionCh, signals.Supported()...)
+	if a.actionCh != nil {
+		signal.Stop(a.actionCh)
+		close(a.actionCh)
+	}
+	a.actionCh = actionCh
+	go func() {
+		for sig := range actionCh {
+			a.handleSignalActions(sig)
+		}
+	}()
+}
+
+// handleSignalActions runs every action that has been mapped to the signal
+func (a *App) handleSignalActions(sig os.Signal) {
+	a.signalLock.Lock()
+	defer a.signalLock.Unlock()
+	for _, cfg := range a.Signals {
+		if sig != cfg.Signal() {
+			continue
+		}
+		log.Debugf("%s received: running '%s' action", cfg.Name, cfg.Action)
+		switch cfg.Action {
+		case signals.Forward:
+			a.jobsLock.RLock()
+			for _, job := range a.Jobs {
+				if job.Name == cfg.Job {
+					job.Signal(cfg.Signal())
+				}
+			}
+			a.jobsLock.RUnlock()
+		case signals.Trigger:
+			a.Bus.Publish(events.Event{Code: events.Trigger, Source: cfg.Job})
+		case signals.Maintenance:
+			a.toggleMaintenance()
+		case signals.Reload:
+			// the reload replaces the signal actions, so it can't run
+			// while we hold the signalLock
+			go func() {
+				if err := a.Reload(); err != nil {
+					log.Errorf("failed to reload config: %v", err)
+				}
+			}()
+		}
+	}
+}
+
+// toggleMaintenance exits maintenance mode if any job is currently in
+// maintenance, and enters maintenance mode otherwise.
+func (a *App) toggleMaintenance() {
+	a.jobsLock.RLock()
+	defer a.jobsLock.RUnlock()
+	for _, job := range a.Jobs {
+		if job.InMaintenance() {
+			a.Bus.Publish(events.GlobalExitMaintenance)
+			return
+		}
+	}
+	a.Bus.Publish(events.GlobalEnterMaintenance)
+}
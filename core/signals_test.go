@@ -11,6 +11,8 @@ import (
 
 	"github.com/asokolov365/containerpilot/events"
 	"github.com/asokolov365/containerpilot/jobs"
+	"github.com/asokolov365/containerpilot/signals"
+	"github.com/asokolov365/containerpilot/tests"
 	"github.com/asokolov365/containerpilot/tests/mocks"
 	"github.com/stretchr/testify/assert"
 )
@@ -41,12 +43,11 @@ func getSignalTestConfig() *App {
 func getSignalEventTestConfig(signals []string) *App {
 	appJobs := make([]*jobs.Job, len(signals))
 	for n, sig := range signals {
+		// these jobs have no exec, so that the signal events are all
+		// that we see on the bus
 		cfg := &jobs.Config{
-			Name:       "test-" + sig,
-			Port:       1,
-			Interfaces: []string{"inet"},
-			Exec:       []string{"./testdata/test.sh", "interruptSleep"},
-			When:       &jobs.WhenConfig{Source: sig},
+			Name: "test-" + sig,
+			When: &jobs.WhenConfig{Source: sig},
 		}
 		cfg.Validate(&mocks.NoopDiscoveryBackend{})
 		appJobs[n] = jobs.NewJob(cfg)
@@ -130,6 +131,79 @@ func TestSignalEvent(t *testing.T) {
 	}
 }
 
+// Test that signals mapped to actions trigger jobs and toggle maintenance
+func TestSignalActions(t *testing.T) {
+	stopCh := make(chan struct{}, 1)
+	cfg := &jobs.Config{
+		Name: "test-trigger",
+		Exec: []string{"./testdata/test.sh", "doStuff"},
+		When: &jobs.WhenConfig{Source: "never", Once: "startup"},
+	}
+	cfg.Validate(&mocks.NoopDiscoveryBackend{})
+	app := EmptyApp()
+	app.StopTimeout = 1
+	app.Jobs = []*jobs.Job{jobs.NewJob(cfg)}
+	app.Bus = events.NewEventBus()
+	sigs, err := signals.NewConfigs(tests.DecodeRawToSlice(`[
+	{signal: "SIGUSR1", action: "trigger", job: "test-trigger"},
+	{signal: "SIGWINCH", action: "maintenance"}]`), []string{"test-trigger"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	app.Signals = sigs
+
+	bus := app.Bus
+	ctx, cancel := context.WithCancel(context.Background())
+	for _, job := range app.Jobs {
+		job.Subscribe(bus)
+		job.Register(bus)
+	}
+	for _, job := range app.Jobs {
+		job.Run(ctx, stopCh)
+	}
+	app.handleSignalActions(syscall.SIGUSR1)
+	app.handleSignalActions(syscall.SIGWINCH)
+	time.Sleep(200 * time.Millisecond)
+
+	cancel()
+	bus.Wait()
+	results := bus.DebugEvents()
+	got := map[events.Event]int{}
+	for _, result := range results {
+		got[result]++
+	}
+	assert.Equal(t, 1, got[events.Event{Code: events.Trigger, Source: "test-trigger"}],
+		"expected trigger event")
+	assert.Equal(t, 1, got[events.Event{Code: events.ExitSuccess, Source: "test-trigger"}],
+		"expected triggered job to run")
+	assert.Equal(t, 1, got[events.GlobalEnterMaintenance],
+		"expected maintenance to be toggled on")
+}
+
+// Test that a signal removed by a reload no longer runs its action
+func TestSignalActionsReload(t *testing.T) {
+	app := EmptyApp()
+	app.Bus = events.NewEventBus()
+	app.handlingSignals = true
+	sigs, err := signals.NewConfigs(tests.DecodeRawToSlice(
+		`[{signal: "SIGWINCH", action: "maintenance"}]`), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	app.setSignals(sigs)
+	me, _ := os.FindProcess(os.Getpid())
+	me.Signal(syscall.SIGWINCH)
+	assert.Equal(t, []events.Event{events.GlobalEnterMaintenance},
+		app.Bus.DebugEvents())
+
+	previous := app.actionCh
+	app.setSignals(nil)
+	_, ok := <-previous
+	assert.False(t, ok, "expected the previous signal channel to be closed")
+	me.Signal(syscall.SIGWINCH) // caught, but no longer mapped
+	assert.Empty(t, app.Bus.DebugEvents())
+}
+
 // Test that only ensures that we cover a straight-line run through
 // the handleSignals setup code
 func TestSignalWiring(t *testing.T) {
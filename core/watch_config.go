@@ -0,0 +1,85 @@
+package core
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"os"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// these are vars so that we can shorten them in tests
+var (
+	configPollInterval = time.Second
+	configSettleTime   = 2 * time.Second
+)
+
+// watchConfig polls the config file for changes while reloadOnChange is
+// enabled. Tools like Kubernetes update mounted files in several steps,
+// so we wait until the file has stopped changing before we reload it.
+func (a *App) watchConfig(ctx context.Context) {
+	go func() {
+		ticker := time.NewTicker(configPollInterval)
+		defer ticker.Stop()
+		var lastSum string
+		var changedAt time.Time
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+			files, enabled := a.configFiles()
+			if !enabled {
+				lastSum = ""
+				changedAt = time.Time{}
+				continue
+			}
+			sum := checksumFiles(files)
+			switch {
+			case lastSum == "":
+				lastSum = sum
+			case sum != lastSum:
+				lastSum = sum
+				changedAt = time.Now()
+			case !changedAt.IsZero() && time.Since(changedAt) >= configSettleTime:
+				changedAt = time.Time{}
+				log.Info("config file has changed: reloading")
+				if err := a.Reload(); err != nil {
+					log.Errorf("failed to reload config: %v", err)
+				}
+			}
+		}
+	}()
+}
+
+// configFiles returns the files to watch for changes, and whether
+// reloadOnChange is enabled in the running config
+func (a *App) configFiles() ([]string, bool) {
+	a.reloadLock.Lock()
+	defer a.reloadLock.Unlock()
+	if a.cfg == nil || !a.cfg.ReloadOnChange {
+		return nil, false
+	}
+	return a.cfg.WatchedFiles(), true
+}
+
+// checksumFiles returns a checksum of the names and contents of all the
+// files, so that adding or removing a file counts as a change too. A
+// missing or unreadable file counts as a change, so that we catch the
+// file coming back.
+func checksumFiles(paths []string) string {
+	hash := sha256.New()
+	for _, path := range paths {
+		hash.Write([]byte(path))
+		data, err := os.ReadFile(path)
+		if err != nil {
+			hash.Write([]byte(err.Error()))
+			continue
+		}
+		hash.Write(data)
+	}
+	return hex.EncodeToString(hash.Sum(nil))
+}
@@ -0,0 +1,86 @@
+package core
+
+import (
+	"context"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWatchConfig(t *testing.T) {
+	defer func(poll, settle time.Duration) {
+		configPollInterval = poll
+		configSettleTime = settle
+	}(configPollInterval, configSettleTime)
+	configPollInterval = 10 * time.Millisecond
+	configSettleTime = 50 * time.Millisecond
+
+	f := testCfgToTempFile(t, `{
+	reloadOnChange: true,
+	jobs: [{name: "first", exec: ["./testdata/test.sh", "interruptSleep"]}]}`)
+	defer os.Remove(f.Name())
+	app, err := NewApp(f.Name())
+	if err != nil {
+		t.Fatalf("unexpected error in NewApp: %v", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	app.runTestTasks(ctx)
+	app.watchConfig(ctx)
+	time.Sleep(50 * time.Millisecond)
+
+	jobNames := func() []string {
+		app.jobsLock.RLock()
+		defer app.jobsLock.RUnlock()
+		names := []string{}
+		for _, job := range app.Jobs {
+			names = append(names, job.Name)
+		}
+		return names
+	}
+
+	// an invalid config is never applied
+	if err := os.WriteFile(f.Name(), []byte(`{invalid`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(200 * time.Millisecond)
+	assert.Equal(t, []string{"first"}, jobNames())
+
+	err = os.WriteFile(f.Name(), []byte(`{
+	reloadOnChange: true,
+	jobs: [
+		{name: "first", exec: ["./testdata/test.sh", "interruptSleep"]},
+		{name: "second", exec: ["./testdata/test.sh", "interruptSleep"]}
+	]}`), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(200 * time.Millisecond)
+	assert.Equal(t, []string{"first", "second"}, jobNames())
+
+	// once it's disabled, changes are ignored
+	err = os.WriteFile(f.Name(), []byte(`{
+	jobs: [{name: "first", exec: ["./testdata/test.sh", "interruptSleep"]}]}`), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	app.reloadLock.Lock()
+	app.cfg.ReloadOnChange = false
+	app.reloadLock.Unlock()
+	time.Sleep(200 * time.Millisecond)
+	assert.Equal(t, []string{"first", "second"}, jobNames())
+
+	cancel()
+	app.Bus.Wait()
+}
+
+func TestChecksumFiles(t *testing.T) {
+	f := testCfgToTempFile(t, `{}`)
+	defer os.Remove(f.Name())
+	sum := checksumFiles([]string{f.Name()})
+	assert.Equal(t, sum, checksumFiles([]string{f.Name()}))
+	os.Remove(f.Name())
+	assert.NotEqual(t, sum, checksumFiles([]string{f.Name()}),
+		"expected missing file to change checksum")
+}
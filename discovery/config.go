@@ -1,6 +1,14 @@
 package discovery
 
 import (
+	"bufio"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
 	"os"
 	"strings"
 
@@ -11,12 +19,18 @@ import (
 // ConsulConfig is used to configure the creation of
 // the Consul Discovery service client.
 type ConsulConfig struct {
-	Address string          `mapstructure:"address"`
-	Scheme  string          `mapstructure:"scheme"`
-	Token   string          `mapstructure:"token"`
-	TLS     ConsulTLSConfig `mapstructure:"tls"` // optional TLS settings
+	Address   string          `mapstructure:"address"`
+	Scheme    string          `mapstructure:"scheme"`
+	Token     string          `mapstructure:"token"`
+	TokenFile string          `mapstructure:"tokenFile"` // re-read when it changes
+	Namespace string          `mapstructure:"namespace"` // Consul Enterprise only
+	Partition string          `mapstructure:"partition"` // Consul Enterprise only
+	TLS       ConsulTLSConfig `mapstructure:"tls"`       // optional TLS settings
 }
 
+// autoAddress is the Consul address that asks us to discover the agent
+const autoAddress = "auto"
+
 // ConsulTLSConfig is optional TLS settings for ConsulConfig.
 type ConsulTLSConfig struct {
 	HTTPCAFile        string `mapstructure:"cafile"`
@@ -63,32 +77,125 @@ func getConsulTLSConfig(cfg *ConsulConfig) api.TLSConfig {
 	return tlsConfig
 }
 
-func consulConfigFromMap(raw map[string]interface{}) (*api.Config, error) {
+func consulConfigFromMap(raw map[string]interface{}) (*ConsulConfig, error) {
 	parsed := &ConsulConfig{}
 	if err := decode.ToStruct(raw, parsed); err != nil {
 		return nil, err
 	}
-	config := &api.Config{
-		Address:   parsed.Address,
-		Scheme:    parsed.Scheme,
-		Token:     parsed.Token,
-		TLSConfig: getConsulTLSConfig(parsed),
-	}
-	return config, nil
+	return parsed, nil
 }
 
-func consulConfigFromURI(uri string) (*api.Config, error) {
+func consulConfigFromURI(uri string) *ConsulConfig {
 	address, scheme := parseRawConsulURI(uri)
-	parsed := &ConsulConfig{Address: address, Scheme: scheme}
+	return &ConsulConfig{Address: address, Scheme: scheme}
+}
+
+// newAPIConfig overrides an already-parsed ConsulConfig with any options
+// that might be set in the environment and then returns the config for
+// the Consul client
+func newAPIConfig(cfg *ConsulConfig) (*api.Config, error) {
+	if token := os.Getenv("CONSUL_HTTP_TOKEN"); token != "" {
+		cfg.Token = token
+	}
+	if tokenFile := os.Getenv("CONSUL_HTTP_TOKEN_FILE"); tokenFile != "" {
+		cfg.TokenFile = tokenFile
+	}
+	if namespace := os.Getenv("CONSUL_NAMESPACE"); namespace != "" {
+		cfg.Namespace = namespace
+	}
+	if partition := os.Getenv("CONSUL_PARTITION"); partition != "" {
+		cfg.Partition = partition
+	}
+	if cfg.Address == autoAddress {
+		address, scheme, err := discoverAgent()
+		if err != nil {
+			return nil, err
+		}
+		cfg.Address = address
+		if cfg.Scheme == "" {
+			cfg.Scheme = scheme
+		}
+	}
 	config := &api.Config{
-		Address:   parsed.Address,
-		Scheme:    parsed.Scheme,
-		Token:     parsed.Token,
-		TLSConfig: getConsulTLSConfig(parsed),
+		Address:   cfg.Address,
+		Scheme:    cfg.Scheme,
+		Token:     cfg.Token,
+		Namespace: cfg.Namespace,
+		TLSConfig: getConsulTLSConfig(cfg),
+	}
+	if cfg.TokenFile == "" && cfg.Partition == "" {
+		return config, nil
+	}
+
+	// the Consul client only reads the token file once, and doesn't
+	// support admin partitions, so we add them to each request
+	transport := &consulTransport{partition: cfg.Partition}
+	if cfg.TokenFile != "" {
+		tokenFile, err := newTokenFile(cfg.TokenFile)
+		if err != nil {
+			return nil, err
+		}
+		transport.token = tokenFile
+		config.Token = tokenFile.Token()
+	}
+	httpClient, err := api.NewHttpClient(
+		http.DefaultTransport.(*http.Transport).Clone(), config.TLSConfig)
+	if err != nil {
+		return nil, err
 	}
+	transport.next = httpClient.Transport
+	httpClient.Transport = transport
+	config.HttpClient = httpClient
 	return config, nil
 }
 
+// discoverAgent returns the address and scheme of the Consul agent:
+// CONSUL_HTTP_ADDR if it's set, or else port 8500 of the node's default
+// gateway, which is where an agent running on the host of the container
+// can usually be found
+func discoverAgent() (string, string, error) {
+	if addr := os.Getenv("CONSUL_HTTP_ADDR"); addr != "" {
+		address, scheme := parseRawConsulURI(addr)
+		return address, scheme, nil
+	}
+	routes, err := os.Open("/proc/net/route")
+	if err != nil {
+		return "", "", fmt.Errorf("unable to discover consul agent: %v", err)
+	}
+	defer routes.Close()
+	gateway, err := parseDefaultGateway(routes)
+	if err != nil {
+		return "", "", fmt.Errorf("unable to discover consul agent: %v", err)
+	}
+	return net.JoinHostPort(gateway.String(), "8500"), "http", nil
+}
+
+// parseDefaultGateway returns the gateway of the default route in the
+// Linux routing table, as found in /proc/net/route
+func parseDefaultGateway(routes io.Reader) (net.IP, error) {
+	scanner := bufio.NewScanner(routes)
+	scanner.Scan() // skip the header
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 3 || fields[1] != "00000000" {
+			continue
+		}
+		// the gateway is a hex-encoded address in host byte order,
+		// which is little-endian on every platform we support
+		raw, err := hex.DecodeString(fields[2])
+		if err != nil || len(raw) != net.IPv4len {
+			return nil, fmt.Errorf("invalid gateway '%s'", fields[2])
+		}
+		ip := make(net.IP, net.IPv4len)
+		binary.BigEndian.PutUint32(ip, binary.LittleEndian.Uint32(raw))
+		return ip, nil
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return nil, errors.New("no default gateway found")
+}
+
 // Returns the uri broken into an address and scheme portion
 func parseRawConsulURI(raw string) (string, string) {
 
@@ -2,8 +2,8 @@ package discovery
 
 import (
 	"fmt"
-	"os"
 	"sort"
+	"strings"
 	"sync"
 
 	"github.com/hashicorp/consul/api"
@@ -25,36 +25,64 @@ func init() {
 // and tracks the state of all watched dependencies.
 type Consul struct {
 	*api.Client
+	config          api.Config // so that we can create a client for another namespace
 	lock            sync.RWMutex
 	watchedServices map[string][]*api.ServiceEntry
 }
 
 // NewConsul creates a new service discovery backend for Consul
 func NewConsul(config interface{}) (*Consul, error) {
-	var consulConfig *api.Config
+	var parsed *ConsulConfig
 	var err error
 	switch t := config.(type) {
 	case string:
-		consulConfig, err = consulConfigFromURI(t)
+		parsed = consulConfigFromURI(t)
 	case map[string]interface{}:
-		consulConfig, err = consulConfigFromMap(t)
+		parsed, err = consulConfigFromMap(t)
 	default:
 		return nil, fmt.Errorf("no discovery backend defined")
 	}
 	if err != nil {
 		return nil, err
 	}
-
-	if token := os.Getenv("CONSUL_HTTP_TOKEN"); token != "" {
-		consulConfig.Token = token
+	consulConfig, err := newAPIConfig(parsed)
+	if err != nil {
+		return nil, err
 	}
+	return newConsulClient(consulConfig)
+}
+
+func newConsulClient(consulConfig *api.Config) (*Consul, error) {
 	client, err := api.NewClient(consulConfig)
 	if err != nil {
 		return nil, err
 	}
-	watchedServices := make(map[string][]*api.ServiceEntry)
-	consul := &Consul{client, sync.RWMutex{}, watchedServices}
-	return consul, nil
+	return &Consul{
+		Client:          client,
+		config:          *consulConfig,
+		watchedServices: make(map[string][]*api.ServiceEntry),
+	}, nil
+}
+
+// InNamespace returns a backend that makes all its requests in the
+// Consul namespace, so that a service registered in that namespace can
+// be updated and deregistered too. Backends other than Consul don't have
+// namespaces, so they're returned unchanged.
+func InNamespace(backend Backend, namespace string) (Backend, error) {
+	consul, ok := backend.(*Consul)
+	if !ok || consul == nil || namespace == "" || namespace == consul.config.Namespace {
+		return backend, nil
+	}
+	config := consul.config
+	config.Namespace = namespace
+	return newConsulClient(&config)
+}
+
+// Ping checks that the local Consul agent is reachable. This asks the
+// agent itself rather than the cluster, so it works without a leader.
+func (c *Consul) Ping() error {
+	_, err := c.Agent().Self()
+	return err
 }
 
 // UpdateTTL wraps the Consul.Agent's UpdateTTL method, and is used to set a TTL
@@ -81,6 +109,78 @@ func (c *Consul) ServiceDeregister(serviceID string) error {
 	return c.Agent().ServiceDeregister(serviceID)
 }
 
+// RegisterService registers the service with the local agent, along with
+// a TTL check with the given status and the service's additional checks
+func (c *Consul) RegisterService(service *ServiceDefinition, status string) error {
+	err := c.ServiceRegister(
+		&api.AgentServiceRegistration{
+			ID:                service.ID,
+			Name:              service.Name,
+			Tags:              service.Tags,
+			Port:              service.Port,
+			Address:           service.IPAddress,
+			TaggedAddresses:   service.TaggedAddresses,
+			EnableTagOverride: service.EnableTagOverride,
+			Meta:              service.Meta,
+			Weights:           service.Weights,
+			Namespace:         service.Namespace,
+			Check: &api.AgentServiceCheck{
+				TTL:                            fmt.Sprintf("%ds", service.TTL),
+				Status:                         status,
+				Notes:                          fmt.Sprintf("TTL for %s set by containerpilot", service.Name),
+				DeregisterCriticalServiceAfter: service.DeregisterCriticalServiceAfter,
+			},
+		},
+	)
+	if err != nil {
+		return err
+	}
+	for _, check := range service.Checks {
+		if err := c.CheckRegister(check); err != nil {
+			return fmt.Errorf("check %s: %v", check.Name, err)
+		}
+	}
+	return nil
+}
+
+// UpdateServiceStatus updates the TTL check of the service
+func (c *Consul) UpdateServiceStatus(service *ServiceDefinition, status, output string) error {
+	checkID := fmt.Sprintf("service:%s", service.ID)
+	err := c.UpdateTTL(checkID, output, status)
+	if err != nil && isUnknownCheck(err) {
+		return fmt.Errorf("%w: %v", ErrNotRegistered, err)
+	}
+	return err
+}
+
+// isUnknownCheck returns true if the error from updating a TTL check
+// means that the Consul agent doesn't know about the check. A 404 by
+// itself could come from a proxy in front of the agent, so we only
+// match the agent's own messages.
+func isUnknownCheck(err error) bool {
+	msg := err.Error()
+	return strings.Contains(msg, "does not have associated TTL") ||
+		strings.Contains(msg, "Unknown check")
+}
+
+// DeregisterService deregisters the service and its checks from the
+// local agent
+func (c *Consul) DeregisterService(service *ServiceDefinition) error {
+	return c.ServiceDeregister(service.ID)
+}
+
+// EnableServiceMaintenance puts the service into the local agent's
+// maintenance mode, which adds a critical check with the reason as its notes
+func (c *Consul) EnableServiceMaintenance(service *ServiceDefinition, reason string) error {
+	return c.Agent().EnableServiceMaintenance(service.ID, reason)
+}
+
+// DisableServiceMaintenance takes the service out of the local agent's
+// maintenance mode
+func (c *Consul) DisableServiceMaintenance(service *ServiceDefinition) error {
+	return c.Agent().DisableServiceMaintenance(service.ID)
+}
+
 // CheckForUpstreamChanges requests the set of healthy instances of a
 // service from Consul and checks whether there has been a change since
 // the last check.
@@ -1,10 +1,18 @@
 package discovery
 
 import (
+	"encoding/json"
+	"errors"
 	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
 
 	consul "github.com/hashicorp/consul/api"
+	"github.com/prometheus/client_golang/prometheus/testutil"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -35,6 +43,309 @@ func TestConsulAddressParse(t *testing.T) {
 	runParseTest(t, "", "", "http")
 }
 
+func TestConsulTokenFileAndPartition(t *testing.T) {
+	var token, partition, namespace string
+	server := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			token = r.Header.Get("X-Consul-Token")
+			partition = r.URL.Query().Get("partition")
+			namespace = r.URL.Query().Get("ns")
+			fmt.Fprint(w, `{"Config": {"NodeName": "node1"}}`)
+		}))
+	defer server.Close()
+
+	tokenPath := filepath.Join(t.TempDir(), "token")
+	if err := os.WriteFile(tokenPath, []byte("first-token\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	consul, err := NewConsul(map[string]interface{}{
+		"address":   server.URL,
+		"token":     "ignored",
+		"tokenFile": tokenPath,
+		"namespace": "team",
+		"partition": "web",
+	})
+	if err != nil {
+		t.Fatalf("unable to parse config: %v", err)
+	}
+	assert.NoError(t, consul.Ping())
+	assert.Equal(t, "first-token", token)
+	assert.Equal(t, "web", partition)
+	assert.Equal(t, "team", namespace)
+
+	// the rotated token is picked up on the next request
+	if err := os.WriteFile(tokenPath, []byte("second-token-rotated"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	assert.NoError(t, consul.Ping())
+	assert.Equal(t, "second-token-rotated", token)
+
+	// a missing file keeps the last token
+	os.Remove(tokenPath)
+	assert.NoError(t, consul.Ping())
+	assert.Equal(t, "second-token-rotated", token)
+
+	_, err = NewConsul(map[string]interface{}{"tokenFile": tokenPath})
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "unable to read consul token file")
+}
+
+func TestConsulAutoAddress(t *testing.T) {
+	var pinged bool
+	server := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			pinged = r.URL.Path == "/v1/agent/self"
+			fmt.Fprint(w, `{"Config": {"NodeName": "node1"}}`)
+		}))
+	defer server.Close()
+
+	os.Setenv("CONSUL_HTTP_ADDR", server.URL)
+	defer os.Unsetenv("CONSUL_HTTP_ADDR")
+	consul, err := NewConsul("auto")
+	if err != nil {
+		t.Fatalf("unable to parse config: %v", err)
+	}
+	assert.NoError(t, consul.Ping())
+	assert.True(t, pinged, "expected the agent at CONSUL_HTTP_ADDR to be used")
+
+	os.Setenv("CONSUL_HTTP_ADDR", "https://consul.local:8501")
+	address, scheme, err := discoverAgent()
+	assert.NoError(t, err)
+	assert.Equal(t, "consul.local:8501", address)
+	assert.Equal(t, "https", scheme)
+}
+
+func TestConsulRegisterExtras(t *testing.T) {
+	var registration consul.AgentServiceRegistration
+	var check consul.AgentCheckRegistration
+	namespaces := map[string]string{}
+	server := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			namespaces[r.URL.Path] = r.URL.Query().Get("ns")
+			if r.URL.Path == "/v1/agent/service/register" {
+				json.NewDecoder(r.Body).Decode(&registration)
+			}
+			if r.URL.Path == "/v1/agent/check/register" {
+				json.NewDecoder(r.Body).Decode(&check)
+			}
+		}))
+	defer server.Close()
+
+	client, err := NewConsul(server.URL)
+	if err != nil {
+		t.Fatalf("unable to parse config: %v", err)
+	}
+	backend, err := InNamespace(client, "team")
+	assert.NoError(t, err)
+	service := generateServiceDefinition("TestConsulRegisterExtras", nil)
+	service.Backend = backend
+	service.Meta = map[string]string{"version": "2"}
+	service.Weights = &consul.AgentWeights{Passing: 10, Warning: 1}
+	service.TaggedAddresses = map[string]consul.ServiceAddress{
+		"wan": {Address: "203.0.113.5", Port: 80}}
+	service.Namespace = "team"
+	service.Checks = []*consul.AgentCheckRegistration{{
+		ID:                "service:TestConsulRegisterExtras:http",
+		Name:              "http",
+		ServiceID:         "TestConsulRegisterExtras",
+		AgentServiceCheck: consul.AgentServiceCheck{HTTP: "http://localhost/", Interval: "10s"},
+	}}
+	service.SendHeartbeat()
+
+	assert.Equal(t, map[string]string{"version": "2"}, registration.Meta)
+	assert.Equal(t, &consul.AgentWeights{Passing: 10, Warning: 1}, registration.Weights)
+	assert.Equal(t, map[string]consul.ServiceAddress{
+		"wan": {Address: "203.0.113.5", Port: 80}}, registration.TaggedAddresses)
+	assert.Equal(t, "team", registration.Namespace)
+	assert.Equal(t, map[string]string{
+		"/v1/agent/check/register":                                "team",
+		"/v1/agent/service/register":                              "team",
+		"/v1/agent/check/update/service:TestConsulRegisterExtras": "team",
+	}, namespaces)
+
+	same, _ := InNamespace(client, "")
+	assert.Equal(t, Backend(client), same)
+}
+
+func TestConsulSendStatus(t *testing.T) {
+	updates := map[string]string{}
+	server := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			var update struct{ Status, Output string }
+			if strings.HasPrefix(r.URL.Path, "/v1/agent/check/update/") {
+				json.NewDecoder(r.Body).Decode(&update)
+				updates[update.Status] = update.Output
+			}
+		}))
+	defer server.Close()
+
+	client, err := NewConsul(server.URL)
+	if err != nil {
+		t.Fatalf("unable to parse config: %v", err)
+	}
+	service := generateServiceDefinition("TestConsulSendStatus", client)
+	service.SendStatus(consul.HealthWarning, "disk 91% full")
+	service.SendHeartbeat()
+	assert.Equal(t, map[string]string{
+		consul.HealthWarning: "disk 91% full",
+		consul.HealthPassing: "ok",
+	}, updates)
+}
+
+func TestConsulSendStatusReregister(t *testing.T) {
+	var registrations []string
+	var updateErrs []string
+	var failRegisters int
+	server := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path == "/v1/agent/service/register" && failRegisters > 0 {
+				failRegisters--
+				w.WriteHeader(http.StatusInternalServerError)
+				return
+			}
+			if r.URL.Path == "/v1/agent/service/register" {
+				var registration consul.AgentServiceRegistration
+				json.NewDecoder(r.Body).Decode(&registration)
+				registrations = append(registrations, registration.Check.Status)
+			}
+			if strings.HasPrefix(r.URL.Path, "/v1/agent/check/update/") &&
+				len(updateErrs) > 0 {
+				w.WriteHeader(http.StatusInternalServerError)
+				fmt.Fprint(w, updateErrs[0])
+				updateErrs = updateErrs[1:]
+			}
+		}))
+	defer server.Close()
+
+	client, err := NewConsul(server.URL)
+	if err != nil {
+		t.Fatalf("unable to parse config: %v", err)
+	}
+	name := "TestConsulSendStatusReregister"
+	service := generateServiceDefinition(name, client)
+	unknownCheck := fmt.Sprintf(
+		"CheckID \"service:%s\" does not have associated TTL", name)
+
+	// a service whose first registration failed isn't counted as
+	// re-registered by the next heartbeat
+	failRegisters = 1
+	updateErrs = []string{unknownCheck}
+	service.SendStatus(consul.HealthPassing, "ok")
+	assert.Empty(t, registrations)
+	service.SendStatus(consul.HealthPassing, "ok")
+	assert.Equal(t, []string{consul.HealthPassing}, registrations)
+	assert.Equal(t, 0.0, testutil.ToFloat64(
+		reregistrationsCollector.WithLabelValues(name)))
+
+	updateErrs = []string{"agent is shutting down"}
+	service.SendStatus(consul.HealthCritical, "down")
+	assert.Equal(t, []string{consul.HealthPassing}, registrations,
+		"expected no registration after an unrelated error")
+
+	updateErrs = []string{unknownCheck}
+	service.SendStatus(consul.HealthCritical, "down")
+	service.SendStatus(consul.HealthCritical, "down")
+	assert.Equal(t, []string{consul.HealthPassing, consul.HealthCritical}, registrations)
+	assert.Equal(t, 1.0, testutil.ToFloat64(
+		reregistrationsCollector.WithLabelValues(name)))
+
+	// the initial status only applies before any status has been sent
+	service.wasRegistered = false
+	service.RegisterWithInitialStatus()
+	assert.Len(t, registrations, 2)
+
+	// registering after maintenance isn't counted as a re-registration
+	service.MarkForMaintenance()
+	service.SendStatus(consul.HealthPassing, "ok")
+	assert.Len(t, registrations, 3)
+	assert.Equal(t, 1.0, testutil.ToFloat64(
+		reregistrationsCollector.WithLabelValues(name)))
+}
+
+func TestConsulMaintenance(t *testing.T) {
+	var calls []string
+	var failMaintenance bool
+	server := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			if strings.HasPrefix(r.URL.Path, "/v1/agent/service/maintenance/") ||
+				strings.HasPrefix(r.URL.Path, "/v1/agent/service/deregister/") {
+				calls = append(calls, r.URL.Path+"?"+r.URL.RawQuery)
+			}
+			if failMaintenance && strings.HasPrefix(r.URL.Path, "/v1/agent/service/maintenance/") {
+				w.WriteHeader(http.StatusInternalServerError)
+			}
+		}))
+	defer server.Close()
+
+	client, err := NewConsul(server.URL)
+	if err != nil {
+		t.Fatalf("unable to parse config: %v", err)
+	}
+	name := "TestConsulMaintenance"
+	service := generateServiceDefinition(name, client)
+
+	// the service is deregistered by default
+	service.MarkForMaintenance()
+	service.ExitMaintenance()
+	assert.Equal(t, []string{"/v1/agent/service/deregister/" + name + "?"}, calls)
+
+	calls = nil
+	service.MaintenanceReason = "upgrading"
+	service.MarkForMaintenance()
+	service.ExitMaintenance()
+	service.ExitMaintenance() // only once
+	assert.Equal(t, []string{
+		"/v1/agent/service/maintenance/" + name + "?enable=true&reason=upgrading",
+		"/v1/agent/service/maintenance/" + name + "?enable=false",
+	}, calls)
+
+	// the service is deregistered if maintenance mode can't be enabled
+	calls = nil
+	failMaintenance = true
+	service.wasRegistered = true
+	service.MarkForMaintenance()
+	assert.False(t, service.inMaintenance)
+	assert.False(t, service.wasRegistered)
+	assert.Equal(t, []string{
+		"/v1/agent/service/maintenance/" + name + "?enable=true&reason=upgrading",
+		"/v1/agent/service/deregister/" + name + "?",
+	}, calls)
+
+	// the service is registered again if it can't be taken out of
+	// maintenance mode
+	failMaintenance = false
+	service.wasRegistered = true
+	service.MarkForMaintenance()
+	assert.True(t, service.inMaintenance)
+	failMaintenance = true
+	service.ExitMaintenance()
+	assert.False(t, service.inMaintenance)
+	assert.False(t, service.wasRegistered)
+}
+
+func TestIsUnknownCheck(t *testing.T) {
+	assert.True(t, isUnknownCheck(errors.New(
+		`Unexpected response code: 500 (CheckID "service:a" does not have associated TTL)`)))
+	assert.True(t, isUnknownCheck(errors.New(
+		`Unexpected response code: 404 (Unknown check ID "service:a")`)))
+	assert.False(t, isUnknownCheck(errors.New(
+		`Unexpected response code: 404 (404 page not found)`)))
+	assert.False(t, isUnknownCheck(errors.New(
+		`Put "http://127.0.0.1:8500/v1/agent/check/update/service:a": connection refused`)))
+}
+
+func TestParseDefaultGateway(t *testing.T) {
+	routes := "Iface\tDestination\tGateway \tFlags\tRefCnt\tUse\tMetric\tMask\n" +
+		"eth0\t0000FEA9\t00000000\t0001\t0\t0\t0\t0000FFFF\n" +
+		"eth0\t00000000\t0100A8C0\t0003\t0\t0\t0\t00000000\n"
+	ip, err := parseDefaultGateway(strings.NewReader(routes))
+	assert.NoError(t, err)
+	assert.Equal(t, "192.168.0.1", ip.String())
+
+	_, err = parseDefaultGateway(strings.NewReader(routes[:strings.LastIndex(routes, "eth0")]))
+	assert.EqualError(t, err, "no default gateway found")
+}
+
 func runParseTest(t *testing.T, uri, expectedAddress, expectedScheme string) {
 
 	address, scheme := parseRawConsulURI(uri)
@@ -182,7 +493,7 @@ func testConsulEnableTagOverride(testServer *TestServer) func(*testing.T) {
 			TTL:               1,
 			Port:              9000,
 			EnableTagOverride: true,
-			Consul:            consul,
+			Backend:           consul,
 		}
 		id := service.ID
 		if changed, _ := consul.CheckForUpstreamChanges(backend, "", ""); changed {
@@ -210,6 +521,6 @@ func generateServiceDefinition(serviceName string, consul *Consul) *ServiceDefin
 		InitialStatus: "warning",
 		TTL:           5,
 		Port:          9000,
-		Consul:        consul,
+		Backend:       consul,
 	}
 }
@@ -1,14 +1,53 @@
-// Package discovery manages the configuration of the Consul clients and
-// the functions used to update/query Consul with service discovery data.
+// Package discovery manages the configuration of the service discovery
+// backends (Consul or etcd) and the functions used to update/query them
+// with service discovery data.
 package discovery
 
-import "github.com/hashicorp/consul/api"
+import "errors"
+
+// The health statuses of a registered service. These are the same as
+// Consul's statuses.
+const (
+	StatusPassing  = "passing"
+	StatusWarning  = "warning"
+	StatusCritical = "critical"
+)
+
+// ErrNotRegistered is wrapped by the error a Backend returns when it no
+// longer knows about a service, as when the Consul agent was restarted
+// without its state or an etcd lease expired, so that the service can be
+// registered again.
+var ErrNotRegistered = errors.New("service is not registered")
 
 // Backend is an interface which all service discovery backends must implement
 type Backend interface {
-	CheckForUpstreamChanges(fields ...string) (bool, bool)
-	CheckRegister(check *api.AgentCheckRegistration) error
-	UpdateTTL(checkID, output, status string) error
-	ServiceDeregister(serviceID string) error
-	ServiceRegister(service *api.AgentServiceRegistration) error
+	// CheckForUpstreamChanges takes the name of a service and optionally
+	// a tag and a datacenter, and returns whether the set of healthy
+	// instances of the service has changed since the last check and
+	// whether there are any.
+	CheckForUpstreamChanges(fields ...string) (hasChanged, isHealthy bool)
+
+	// RegisterService registers the service with the given status, along
+	// with any additional checks that the backend supports.
+	RegisterService(service *ServiceDefinition, status string) error
+
+	// UpdateServiceStatus sets the status of a registered service and
+	// extends its TTL. The output of its health check is the reason for
+	// the status.
+	UpdateServiceStatus(service *ServiceDefinition, status, output string) error
+
+	// DeregisterService removes the service.
+	DeregisterService(service *ServiceDefinition) error
+}
+
+// MaintenanceBackend is implemented by the backends that can keep a
+// service registered while it's in maintenance mode, so that it isn't
+// returned as a healthy instance but is still visible to operators.
+type MaintenanceBackend interface {
+	// EnableServiceMaintenance puts the service into maintenance mode,
+	// giving the reason for it.
+	EnableServiceMaintenance(service *ServiceDefinition, reason string) error
+
+	// DisableServiceMaintenance takes the service out of maintenance mode.
+	DisableServiceMaintenance(service *ServiceDefinition) error
 }
@@ -0,0 +1,505 @@
+package discovery
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"go.etcd.io/etcd/api/v3/v3rpc/rpctypes"
+	clientv3 "go.etcd.io/etcd/client/v3"
+	"go.uber.org/zap"
+
+	"github.com/asokolov365/containerpilot/config/decode"
+)
+
+const (
+	defaultEtcdPrefix = "/containerpilot"
+
+	// how long a request to etcd can take, other than a watch
+	etcdRequestTimeout = 10 * time.Second
+
+	// how long Ping waits for each endpoint. The client waits for a
+	// connection rather than failing right away, so an endpoint that
+	// can't be reached only fails when this runs out.
+	etcdPingTimeout = 2 * time.Second
+
+	// a watch that hasn't been checked for this long is stopped, so that
+	// we don't keep watching a backend that's been replaced on a reload
+	etcdWatchIdleTimeout = 5 * time.Minute
+)
+
+// EtcdConfig is used to configure the creation of the etcd discovery
+// backend client.
+type EtcdConfig struct {
+	Endpoints interface{}   `mapstructure:"endpoints"` // a URL or a list of URLs
+	Prefix    string        `mapstructure:"prefix"`
+	TLS       EtcdTLSConfig `mapstructure:"tls"` // optional TLS settings
+}
+
+// EtcdTLSConfig is optional TLS settings for EtcdConfig.
+type EtcdTLSConfig struct {
+	CAFile     string `mapstructure:"cafile"`
+	ClientCert string `mapstructure:"clientcert"`
+	ClientKey  string `mapstructure:"clientkey"`
+	ServerName string `mapstructure:"servername"`
+}
+
+// Etcd is the service discovery backend for etcd v3. Each service is a
+// key under the prefix that's attached to a lease with the service's
+// TTL, and each watched service is kept up to date by watching the keys
+// of its instances.
+type Etcd struct {
+	client *clientv3.Client
+	prefix string
+
+	lock     sync.Mutex
+	services map[string]*etcdRegistration // by service ID
+	watches  map[string]*etcdWatch        // by service name
+	reported map[string][]etcdInstance    // by service name
+}
+
+// etcdInstance is the value of the key of a service instance
+type etcdInstance struct {
+	ID      string            `json:"id"`
+	Name    string            `json:"name"`
+	Address string            `json:"address"`
+	Port    int               `json:"port"`
+	Tags    []string          `json:"tags,omitempty"`
+	Meta    map[string]string `json:"meta,omitempty"`
+	Status  string            `json:"status"`
+	Output  string            `json:"output,omitempty"`
+}
+
+// etcdRegistration is a service registered by this ContainerPilot
+type etcdRegistration struct {
+	key      string
+	lease    clientv3.LeaseID
+	instance etcdInstance
+}
+
+// NewEtcd creates a new service discovery backend for etcd
+func NewEtcd(config interface{}) (*Etcd, error) {
+	parsed := &EtcdConfig{}
+	switch t := config.(type) {
+	case string:
+		parsed.Endpoints = t
+	case map[string]interface{}:
+		if err := decode.ToStruct(t, parsed); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("no discovery backend defined")
+	}
+	rawEndpoints, err := decode.ToStrings(parsed.Endpoints)
+	if err != nil {
+		return nil, fmt.Errorf("endpoints: %v", err)
+	}
+	var endpoints []string
+	for _, endpoint := range rawEndpoints {
+		if endpoint == "" {
+			continue
+		}
+		if !strings.Contains(endpoint, "://") {
+			endpoint = "http://" + endpoint
+		}
+		endpoints = append(endpoints, strings.TrimSuffix(endpoint, "/"))
+	}
+	if len(endpoints) == 0 {
+		return nil, errors.New("endpoints: must have at least one etcd URL")
+	}
+	prefix := strings.TrimSuffix(parsed.Prefix, "/")
+	if parsed.Prefix == "" {
+		prefix = defaultEtcdPrefix
+	}
+	client, err := newEtcdClient(endpoints, parsed.TLS)
+	if err != nil {
+		return nil, err
+	}
+	return &Etcd{
+		client:   client,
+		prefix:   prefix,
+		services: make(map[string]*etcdRegistration),
+		watches:  make(map[string]*etcdWatch),
+		reported: make(map[string][]etcdInstance),
+	}, nil
+}
+
+var (
+	etcdClientsLock sync.Mutex
+	etcdClients     = map[string]*clientv3.Client{}
+)
+
+// newEtcdClient returns the client for the endpoints. The config is
+// loaded again on every reload and the client is never closed, so
+// backends with the same endpoints and TLS settings share a client
+// rather than each keeping their own connection open.
+func newEtcdClient(endpoints []string, tlsCfg EtcdTLSConfig) (*clientv3.Client, error) {
+	key := fmt.Sprintf("%q %+v", endpoints, tlsCfg)
+	etcdClientsLock.Lock()
+	defer etcdClientsLock.Unlock()
+	if client, ok := etcdClients[key]; ok {
+		return client, nil
+	}
+	tlsConfig, err := newEtcdTLSConfig(tlsCfg)
+	if err != nil {
+		return nil, err
+	}
+	// the client connects in the background, so this doesn't need
+	// etcd to be reachable yet
+	client, err := clientv3.New(clientv3.Config{
+		Endpoints:   endpoints,
+		TLS:         tlsConfig,
+		DialTimeout: etcdRequestTimeout,
+		Logger:      zap.NewNop(),
+	})
+	if err != nil {
+		return nil, err
+	}
+	etcdClients[key] = client
+	return client, nil
+}
+
+func newEtcdTLSConfig(cfg EtcdTLSConfig) (*tls.Config, error) {
+	tlsConfig := &tls.Config{ServerName: cfg.ServerName}
+	if cfg.CAFile != "" {
+		pem, err := ioutil.ReadFile(cfg.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("tls.cafile: %v", err)
+		}
+		tlsConfig.RootCAs = x509.NewCertPool()
+		if !tlsConfig.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("tls.cafile: no certificates found in %s", cfg.CAFile)
+		}
+	}
+	if cfg.ClientCert != "" || cfg.ClientKey != "" {
+		cert, err := tls.LoadX509KeyPair(cfg.ClientCert, cfg.ClientKey)
+		if err != nil {
+			return nil, fmt.Errorf("tls.clientcert: %v", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+	return tlsConfig, nil
+}
+
+// Ping checks that etcd is reachable
+func (e *Etcd) Ping() error {
+	var err error
+	for _, endpoint := range e.client.Endpoints() {
+		ctx, cancel := context.WithTimeout(context.Background(), etcdPingTimeout)
+		_, err = e.client.Status(ctx, endpoint)
+		cancel()
+		if err == nil {
+			return nil
+		}
+	}
+	return err
+}
+
+// RegisterService puts the key of the service with a new lease for its TTL
+func (e *Etcd) RegisterService(service *ServiceDefinition, status string) error {
+	if len(service.Checks) > 0 {
+		log.Warnf("etcd doesn't run health checks: ignoring the checks of %s",
+			service.Name)
+	}
+	e.lock.Lock()
+	previous := e.services[service.ID]
+	e.lock.Unlock()
+	ctx, cancel := e.requestContext()
+	defer cancel()
+	if previous != nil {
+		// the lease may have already expired, which is fine
+		e.client.Revoke(ctx, previous.lease)
+	}
+
+	grant, err := e.client.Grant(ctx, int64(service.TTL))
+	if err != nil {
+		return err
+	}
+	registration := &etcdRegistration{
+		key:   e.instanceKey(service),
+		lease: grant.ID,
+		instance: etcdInstance{
+			ID:      service.ID,
+			Name:    service.Name,
+			Address: service.IPAddress,
+			Port:    service.Port,
+			Tags:    service.Tags,
+			Meta:    service.Meta,
+			Status:  status,
+		},
+	}
+	if err := e.put(ctx, registration); err != nil {
+		return err
+	}
+	e.lock.Lock()
+	e.services[service.ID] = registration
+	e.lock.Unlock()
+	return nil
+}
+
+// UpdateServiceStatus keeps the lease of the service alive, and updates
+// its key if the status or output have changed
+func (e *Etcd) UpdateServiceStatus(service *ServiceDefinition, status, output string) error {
+	e.lock.Lock()
+	registration := e.services[service.ID]
+	e.lock.Unlock()
+	if registration == nil {
+		return fmt.Errorf("%w: %s", ErrNotRegistered, service.ID)
+	}
+	ctx, cancel := e.requestContext()
+	defer cancel()
+	if _, err := e.client.KeepAliveOnce(ctx, registration.lease); err != nil {
+		if errors.Is(err, rpctypes.ErrLeaseNotFound) {
+			// the lease expired and etcd deleted the key
+			e.lock.Lock()
+			delete(e.services, service.ID)
+			e.lock.Unlock()
+			return fmt.Errorf("%w: lease %x of %s has expired",
+				ErrNotRegistered, registration.lease, service.ID)
+		}
+		return err
+	}
+	if registration.instance.Status == status && registration.instance.Output == output {
+		return nil
+	}
+	updated := *registration
+	updated.instance.Status = status
+	updated.instance.Output = output
+	if err := e.put(ctx, &updated); err != nil {
+		if errors.Is(err, rpctypes.ErrLeaseNotFound) {
+			return fmt.Errorf("%w: %v", ErrNotRegistered, err)
+		}
+		return err
+	}
+	e.lock.Lock()
+	e.services[service.ID] = &updated
+	e.lock.Unlock()
+	return nil
+}
+
+// DeregisterService revokes the lease of the service, which deletes its key
+func (e *Etcd) DeregisterService(service *ServiceDefinition) error {
+	e.lock.Lock()
+	registration := e.services[service.ID]
+	delete(e.services, service.ID)
+	e.lock.Unlock()
+	ctx, cancel := e.requestContext()
+	defer cancel()
+	if registration != nil {
+		_, err := e.client.Revoke(ctx, registration.lease)
+		if err == nil || !errors.Is(err, rpctypes.ErrLeaseNotFound) {
+			return err
+		}
+	}
+	_, err := e.client.Delete(ctx, e.instanceKey(service))
+	return err
+}
+
+// CheckForUpstreamChanges checks the instances of a service, which are
+// kept up to date by a watch on their keys, and returns whether the set
+// of passing instances has changed since the last check. Datacenters
+// are a Consul feature, so that field is ignored.
+func (e *Etcd) CheckForUpstreamChanges(fields ...string) (hasChanged, isHealthy bool) {
+	backendName, backendTag := fields[0], fields[1]
+	e.lock.Lock()
+	watch, ok := e.watches[backendName]
+	if !ok {
+		watch = &etcdWatch{}
+		e.watches[backendName] = watch
+	}
+	e.lock.Unlock()
+
+	if err := e.startWatch(backendName, watch); err != nil {
+		log.Warnf("failed to query etcd %v: %s", backendName, err)
+		return false, false
+	}
+	instances := watch.passing(backendTag)
+	collector.WithLabelValues(backendName).Set(float64(len(instances)))
+	isHealthy = len(instances) > 0
+	hasChanged = e.compareAndSwap(backendName, instances)
+	return hasChanged, isHealthy
+}
+
+// returns true if any addresses for the service changed and updates
+// the internal state
+func (e *Etcd) compareAndSwap(service string, instances []etcdInstance) bool {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	existing := e.reported[service]
+	e.reported[service] = instances
+	if len(existing) != len(instances) {
+		return true
+	}
+	for i, ex := range existing {
+		if ex.ID != instances[i].ID ||
+			ex.Address != instances[i].Address ||
+			ex.Port != instances[i].Port {
+			return true
+		}
+	}
+	return false
+}
+
+func (e *Etcd) instanceKey(service *ServiceDefinition) string {
+	return e.servicePrefix(service.Name) + service.ID
+}
+
+func (e *Etcd) servicePrefix(name string) string {
+	return e.prefix + "/services/" + name + "/"
+}
+
+func (e *Etcd) put(ctx context.Context, registration *etcdRegistration) error {
+	value, err := json.Marshal(registration.instance)
+	if err != nil {
+		return err
+	}
+	_, err = e.client.Put(ctx, registration.key, string(value),
+		clientv3.WithLease(registration.lease))
+	return err
+}
+
+// requestContext is the context of a request that returns right away,
+// unlike a watch
+func (e *Etcd) requestContext() (context.Context, context.CancelFunc) {
+	return context.WithTimeout(context.Background(), etcdRequestTimeout)
+}
+
+// etcdWatch is the set of instances of a watched service
+type etcdWatch struct {
+	lock      sync.Mutex
+	instances map[string]etcdInstance // by key
+	running   bool
+	lastCheck time.Time
+}
+
+// startWatch reads the instances of the service and starts watching
+// them for changes, unless the watch is already running
+func (e *Etcd) startWatch(name string, watch *etcdWatch) error {
+	watch.lock.Lock()
+	watch.lastCheck = time.Now()
+	running := watch.running
+	watch.lock.Unlock()
+	if running {
+		return nil
+	}
+
+	prefix := e.servicePrefix(name)
+	ctx, cancel := e.requestContext()
+	resp, err := e.client.Get(ctx, prefix, clientv3.WithPrefix())
+	cancel()
+	if err != nil {
+		return err
+	}
+	instances := make(map[string]etcdInstance, len(resp.Kvs))
+	for _, kv := range resp.Kvs {
+		var instance etcdInstance
+		if err := json.Unmarshal(kv.Value, &instance); err == nil {
+			instances[string(kv.Key)] = instance
+		}
+	}
+	watch.lock.Lock()
+	watch.instances = instances
+	watch.running = true
+	watch.lock.Unlock()
+
+	ctx, cancel = context.WithCancel(context.Background())
+	go func() {
+		defer cancel()
+		e.runWatch(ctx, prefix, resp.Header.Revision+1, watch)
+		watch.lock.Lock()
+		watch.running = false
+		watch.lock.Unlock()
+	}()
+	go watch.stopWhenIdle(ctx, cancel)
+	return nil
+}
+
+// runWatch applies the changes to the keys under the prefix to the
+// watched instances until the watch fails or is canceled. The next
+// check reads the instances again and starts a new watch.
+func (e *Etcd) runWatch(ctx context.Context, prefix string, revision int64, watch *etcdWatch) {
+	// without a leader, a member can't tell us about any changes
+	ctx = clientv3.WithRequireLeader(ctx)
+	changes := e.client.Watch(ctx, prefix, clientv3.WithPrefix(), clientv3.WithRev(revision))
+	for resp := range changes {
+		if err := resp.Err(); err != nil {
+			// ex. the revision we started from has been compacted
+			log.Debugf("etcd watch of %s ended: %v", prefix, err)
+			return
+		}
+		watch.apply(resp.Events)
+	}
+}
+
+func (watch *etcdWatch) apply(events []*clientv3.Event) {
+	watch.lock.Lock()
+	defer watch.lock.Unlock()
+	for _, event := range events {
+		key := string(event.Kv.Key)
+		if event.Type == clientv3.EventTypeDelete {
+			delete(watch.instances, key)
+			continue
+		}
+		var instance etcdInstance
+		if err := json.Unmarshal(event.Kv.Value, &instance); err == nil {
+			watch.instances[key] = instance
+		}
+	}
+}
+
+// passing returns the passing instances with the tag, if any, sorted by ID
+func (watch *etcdWatch) passing(tag string) []etcdInstance {
+	watch.lock.Lock()
+	defer watch.lock.Unlock()
+	instances := []etcdInstance{}
+	for _, instance := range watch.instances {
+		if instance.Status != StatusPassing {
+			continue
+		}
+		if tag != "" && !hasTag(instance.Tags, tag) {
+			continue
+		}
+		instances = append(instances, instance)
+	}
+	sort.Slice(instances, func(i, j int) bool {
+		return instances[i].ID < instances[j].ID
+	})
+	return instances
+}
+
+func (watch *etcdWatch) stopWhenIdle(ctx context.Context, cancel context.CancelFunc) {
+	ticker := time.NewTicker(etcdWatchIdleTimeout / 5)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			watch.lock.Lock()
+			idle := time.Since(watch.lastCheck) > etcdWatchIdleTimeout
+			watch.lock.Unlock()
+			if idle {
+				cancel()
+				return
+			}
+		}
+	}
+}
+
+func hasTag(tags []string, tag string) bool {
+	for _, t := range tags {
+		if t == tag {
+			return true
+		}
+	}
+	return false
+}
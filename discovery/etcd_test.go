@@ -0,0 +1,207 @@
+package discovery
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/url"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	clientv3 "go.etcd.io/etcd/client/v3"
+	"go.etcd.io/etcd/server/v3/embed"
+)
+
+func TestEtcdConfigParse(t *testing.T) {
+	etcd, err := NewEtcd("etcd:2379")
+	if err != nil {
+		t.Fatalf("unable to parse config: %v", err)
+	}
+	assert.Equal(t, []string{"http://etcd:2379"}, etcd.client.Endpoints())
+	assert.Equal(t, "/containerpilot", etcd.prefix)
+
+	etcd, err = NewEtcd(map[string]interface{}{
+		"endpoints": []interface{}{"https://etcd-0:2379/", "etcd-1:2379"},
+		"prefix":    "/app/",
+	})
+	if err != nil {
+		t.Fatalf("unable to parse config: %v", err)
+	}
+	assert.Equal(t, []string{"https://etcd-0:2379", "http://etcd-1:2379"},
+		etcd.client.Endpoints())
+	assert.Equal(t, "/app", etcd.prefix)
+
+	_, err = NewEtcd(map[string]interface{}{"prefix": "/app"})
+	assert.EqualError(t, err, "endpoints: must have at least one etcd URL")
+	_, err = NewEtcd(map[string]interface{}{
+		"endpoints": "etcd:2379",
+		"tls":       map[string]interface{}{"cafile": "/does/not/exist"},
+	})
+	assert.Error(t, err)
+}
+
+func TestEtcdRegisterAndWatch(t *testing.T) {
+	endpoint := startEtcdTestServer(t)
+	etcd, err := NewEtcd(endpoint)
+	if err != nil {
+		t.Fatalf("unable to parse config: %v", err)
+	}
+	watcher, _ := NewEtcd(endpoint)
+	name := "TestEtcdRegisterAndWatch"
+	service := generateEtcdServiceDefinition(name, etcd)
+
+	changed, healthy := watcher.CheckForUpstreamChanges(name, "", "")
+	assert.False(t, changed, "expected no change without instances")
+	assert.False(t, healthy)
+
+	// the initial status is warning, which isn't healthy yet
+	service.RegisterWithInitialStatus()
+	assert.Equal(t, []string{"/containerpilot/services/" + name + "/" + name},
+		etcdKeys(t, etcd))
+	instance := readEtcdInstance(t, etcd, service)
+	assert.Equal(t, StatusWarning, instance.Status)
+	assert.Equal(t, "192.168.1.1", instance.Address)
+	assert.Equal(t, 9000, instance.Port)
+
+	assert.NoError(t, service.SendStatus(StatusPassing, "ok"))
+	assert.Eventually(t, func() bool {
+		changed, healthy = watcher.CheckForUpstreamChanges(name, "", "")
+		return changed
+	}, time.Second, 10*time.Millisecond, "expected the passing instance")
+	assert.True(t, healthy)
+	assert.Equal(t, 1.0, testutil.ToFloat64(collector.WithLabelValues(name)))
+
+	changed, _ = watcher.CheckForUpstreamChanges(name, "nope", "")
+	assert.True(t, changed, "expected no instances with another tag")
+	changed, healthy = watcher.CheckForUpstreamChanges(name, "nope", "")
+	assert.False(t, changed)
+	assert.False(t, healthy)
+	changed, healthy = watcher.CheckForUpstreamChanges(name, "web", "")
+	assert.True(t, changed)
+	assert.True(t, healthy)
+
+	assert.NoError(t, service.SendStatus(StatusCritical, "down"))
+	assert.Equal(t, "down", readEtcdInstance(t, etcd, service).Output)
+	assert.Eventually(t, func() bool {
+		changed, healthy = watcher.CheckForUpstreamChanges(name, "web", "")
+		return changed
+	}, time.Second, 10*time.Millisecond, "expected the critical instance to be removed")
+	assert.False(t, healthy)
+
+	service.Deregister()
+	assert.Empty(t, etcdKeys(t, etcd))
+}
+
+func TestEtcdLeaseExpiry(t *testing.T) {
+	etcd, err := NewEtcd(startEtcdTestServer(t))
+	if err != nil {
+		t.Fatalf("unable to parse config: %v", err)
+	}
+	name := "TestEtcdLeaseExpiry"
+	service := generateEtcdServiceDefinition(name, etcd)
+	service.TTL = 1
+	assert.NoError(t, service.SendStatus(StatusPassing, "ok"))
+	assert.Len(t, etcdKeys(t, etcd), 1)
+
+	// a lease that's kept alive doesn't expire
+	for i := 0; i < 3; i++ {
+		time.Sleep(500 * time.Millisecond)
+		assert.NoError(t, etcd.UpdateServiceStatus(service, StatusPassing, "ok"))
+	}
+	assert.Len(t, etcdKeys(t, etcd), 1)
+
+	assert.Eventually(t, func() bool {
+		return len(etcdKeys(t, etcd)) == 0
+	}, 3*time.Second, 100*time.Millisecond, "expected the key to expire with its lease")
+	err = etcd.UpdateServiceStatus(service, StatusPassing, "ok")
+	assert.True(t, errors.Is(err, ErrNotRegistered), "got %v", err)
+
+	// the service is registered again by its next heartbeat
+	assert.NoError(t, service.SendHeartbeat())
+	assert.Len(t, etcdKeys(t, etcd), 1)
+	assert.Equal(t, 1.0, testutil.ToFloat64(
+		reregistrationsCollector.WithLabelValues(name)))
+
+	// etcd loses the lease, ex. if it was restored from a backup
+	ctx := context.Background()
+	leases, err := etcd.client.Leases(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, lease := range leases.Leases {
+		etcd.client.Revoke(ctx, lease.ID)
+	}
+	assert.NoError(t, service.SendHeartbeat())
+	assert.Len(t, etcdKeys(t, etcd), 1)
+	assert.Equal(t, 2.0, testutil.ToFloat64(
+		reregistrationsCollector.WithLabelValues(name)))
+}
+
+// startEtcdTestServer starts a single etcd member for the test, and
+// returns its client URL. The leases of the member can be as short as
+// a second, like a real cluster with a faster heartbeat.
+func startEtcdTestServer(t *testing.T) string {
+	cfg := embed.NewConfig()
+	cfg.Dir = filepath.Join(t.TempDir(), "etcd")
+	cfg.LogLevel = "error"
+	cfg.TickMs = 10
+	cfg.ElectionMs = 100
+	clientURL, _ := url.Parse("http://127.0.0.1:0")
+	peerURL, _ := url.Parse("http://127.0.0.1:0")
+	cfg.LCUrls, cfg.ACUrls = []url.URL{*clientURL}, []url.URL{*clientURL}
+	cfg.LPUrls, cfg.APUrls = []url.URL{*peerURL}, []url.URL{*peerURL}
+	cfg.InitialCluster = cfg.InitialClusterFromName(cfg.Name)
+	server, err := embed.StartEtcd(cfg)
+	if err != nil {
+		t.Fatalf("unable to start etcd: %v", err)
+	}
+	t.Cleanup(server.Close)
+	select {
+	case <-server.Server.ReadyNotify():
+	case <-time.After(10 * time.Second):
+		t.Fatal("etcd took too long to start")
+	}
+	return "http://" + server.Clients[0].Addr().String()
+}
+
+// etcdKeys returns all the keys in etcd
+func etcdKeys(t *testing.T, etcd *Etcd) []string {
+	resp, err := etcd.client.Get(context.Background(), "",
+		clientv3.WithFromKey(), clientv3.WithKeysOnly())
+	if err != nil {
+		t.Fatal(err)
+	}
+	keys := []string{}
+	for _, kv := range resp.Kvs {
+		keys = append(keys, string(kv.Key))
+	}
+	return keys
+}
+
+func readEtcdInstance(t *testing.T, etcd *Etcd, service *ServiceDefinition) etcdInstance {
+	resp, err := etcd.client.Get(context.Background(), etcd.instanceKey(service))
+	if err != nil || len(resp.Kvs) != 1 {
+		t.Fatalf("expected the key of %s: %v", service.ID, err)
+	}
+	var instance etcdInstance
+	if err := json.Unmarshal(resp.Kvs[0].Value, &instance); err != nil {
+		t.Fatal(err)
+	}
+	return instance
+}
+
+func generateEtcdServiceDefinition(serviceName string, etcd *Etcd) *ServiceDefinition {
+	return &ServiceDefinition{
+		ID:            serviceName,
+		Name:          serviceName,
+		IPAddress:     "192.168.1.1",
+		InitialStatus: "warning",
+		TTL:           5,
+		Port:          9000,
+		Tags:          []string{"web"},
+		Backend:       etcd,
+	}
+}
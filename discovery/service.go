@@ -1,14 +1,25 @@
 package discovery
 
 import (
-	"fmt"
+	"errors"
 
 	"github.com/hashicorp/consul/api"
+	"github.com/prometheus/client_golang/prometheus"
 	log "github.com/sirupsen/logrus"
 )
 
-// ServiceDefinition is how a job communicates with the Consul service
-// discovery backend.
+var reregistrationsCollector *prometheus.CounterVec
+
+func init() {
+	reregistrationsCollector = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "containerpilot_service_reregistrations_total",
+		Help: "count of services registered again after the discovery backend lost them, partitioned by service",
+	}, []string{"service"})
+	prometheus.MustRegister(reregistrationsCollector)
+}
+
+// ServiceDefinition is how a job communicates with the service discovery
+// backend. The Consul-specific fields are ignored by other backends.
 type ServiceDefinition struct {
 	ID                             string
 	Name                           string
@@ -19,31 +30,94 @@ type ServiceDefinition struct {
 	IPAddress                      string
 	EnableTagOverride              bool
 	DeregisterCriticalServiceAfter string
-	Consul                         Backend
+	Meta                           map[string]string
+	Weights                        *api.AgentWeights
+	TaggedAddresses                map[string]api.ServiceAddress
+	Namespace                      string
+	Checks                         []*api.AgentCheckRegistration // besides the TTL check
+	MaintenanceReason              string                        // use the backend's maintenance mode if set
+	Backend                        Backend
 
 	wasRegistered bool
+	sentStatus    bool // the health check status supersedes InitialStatus
+	inMaintenance bool // in the backend's maintenance mode
 }
 
-// Deregister removes the service from Consul.
+// Deregister removes the service from the discovery backend.
 func (service *ServiceDefinition) Deregister() {
 	log.Debugf("deregistering: %s", service.ID)
-	if err := service.Consul.ServiceDeregister(service.ID); err != nil {
+	if err := service.Backend.DeregisterService(service); err != nil {
 		log.Infof("deregistering failed: %s", err)
 	}
+	// the next status is sent after registering the service again, so
+	// that it isn't counted as a service the backend has lost
+	service.wasRegistered = false
+	service.inMaintenance = false
 }
 
-// MarkForMaintenance removes the service from Consul.
+// MarkForMaintenance removes the service from the discovery backend, or
+// puts it into the backend's maintenance mode if the service has a
+// MaintenanceReason and the backend supports it.
 func (service *ServiceDefinition) MarkForMaintenance() {
-	service.Deregister()
+	backend, ok := service.Backend.(MaintenanceBackend)
+	if !ok || service.MaintenanceReason == "" {
+		service.Deregister()
+		return
+	}
+	log.Debugf("enabling maintenance mode: %s", service.ID)
+	if err := backend.EnableServiceMaintenance(service, service.MaintenanceReason); err != nil {
+		// the service mustn't keep taking traffic, so fall back to
+		// removing it from the backend
+		log.Infof("enabling maintenance mode failed, deregistering instead: %s", err)
+		service.Deregister()
+		return
+	}
+	service.inMaintenance = true
+}
+
+// ExitMaintenance takes the service out of the backend's maintenance mode.
+// A service that was deregistered instead, or that couldn't be taken out
+// of maintenance mode, is registered again by its next status update.
+func (service *ServiceDefinition) ExitMaintenance() {
+	if !service.inMaintenance {
+		return
+	}
+	log.Debugf("disabling maintenance mode: %s", service.ID)
+	service.inMaintenance = false
+	backend := service.Backend.(MaintenanceBackend)
+	if err := backend.DisableServiceMaintenance(service); err != nil {
+		log.Infof("disabling maintenance mode failed, registering again: %s", err)
+		service.wasRegistered = false
+	}
 }
 
-// SendHeartbeat writes a TTL check status=ok to the Consul store.
+// SendHeartbeat writes a TTL check status=ok to the discovery backend.
 func (service *ServiceDefinition) SendHeartbeat() error {
-	// Make sure the service is registered.
-	service.register(api.HealthPassing)
+	return service.SendStatus(StatusPassing, "ok")
+}
 
-	checkID := fmt.Sprintf("service:%s", service.ID)
-	if err := service.Consul.UpdateTTL(checkID, "ok", "pass"); err != nil {
+// SendStatus writes the TTL check status (passing, warning, or critical)
+// to the discovery backend, along with the output of the health check
+// that's shown as the reason for the status.
+func (service *ServiceDefinition) SendStatus(status, output string) error {
+	// Make sure the service is registered.
+	wasRegistered := service.wasRegistered
+	service.register(status)
+	service.sentStatus = true
+
+	err := service.Backend.UpdateServiceStatus(service, status, output)
+	if errors.Is(err, ErrNotRegistered) && wasRegistered {
+		// the backend has lost the service (ex. the agent was restarted
+		// without persistent state), so register it and its checks again
+		log.Warnf("service %s is unknown to the discovery backend, registering it again",
+			service.ID)
+		service.wasRegistered = false
+		if err = service.register(status); err == nil {
+			reregistrationsCollector.WithLabelValues(service.Name).Inc()
+			err = service.Backend.UpdateServiceStatus(service, status, output)
+		}
+	}
+	if err != nil {
 		log.Warnf("service update TTL failed: %s", err)
 	}
 
@@ -52,7 +126,7 @@ func (service *ServiceDefinition) SendHeartbeat() error {
 
 // RegisterWithInitialStatus registers the service with its configured initial status.
 func (service *ServiceDefinition) RegisterWithInitialStatus() {
-	if service.wasRegistered {
+	if service.wasRegistered || service.sentStatus {
 		return
 	}
 
@@ -60,13 +134,13 @@ func (service *ServiceDefinition) RegisterWithInitialStatus() {
 
 	switch service.InitialStatus {
 		case "passing":
-			status = api.HealthPassing
+			status = StatusPassing
 			break
 		case "warning":
-			status = api.HealthWarning
+			status = StatusWarning
 			break
 		case "critical":
-			status = api.HealthCritical
+			status = StatusCritical
 			break
 	}
 
@@ -75,10 +149,11 @@ func (service *ServiceDefinition) RegisterWithInitialStatus() {
 	service.register(status)
 }
 
-// Register registers the service with the given status in Consul.
+// Register registers the service with the given status in the discovery
+// backend.
 func (service *ServiceDefinition) register(status string) error {
 	if !service.wasRegistered {
-		if err := service.registerService(status); err != nil {
+		if err := service.Backend.RegisterService(service, status); err != nil {
 			log.Warnf("service registration failed: %s", err)
 			return err
 		}
@@ -88,23 +163,3 @@ func (service *ServiceDefinition) register(status string) error {
 
 	return nil
 }
-
-// registers the service along with a check set to the passing state
-func (service *ServiceDefinition) registerService(status string) error {
-	return service.Consul.ServiceRegister(
-		&api.AgentServiceRegistration{
-			ID:                service.ID,
-			Name:              service.Name,
-			Tags:              service.Tags,
-			Port:              service.Port,
-			Address:           service.IPAddress,
-			EnableTagOverride: service.EnableTagOverride,
-			Check: &api.AgentServiceCheck{
-				TTL:    fmt.Sprintf("%ds", service.TTL),
-				Status: status,
-				Notes:  fmt.Sprintf("TTL for %s set by containerpilot", service.Name),
-				DeregisterCriticalServiceAfter: service.DeregisterCriticalServiceAfter,
-			},
-		},
-	)
-}
@@ -0,0 +1,91 @@
+package discovery
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// consulTransport adds the options that the Consul client doesn't support
+// to each request: the ACL token from a token file that may be rotated,
+// and the admin partition
+type consulTransport struct {
+	next      http.RoundTripper
+	token     *tokenFile
+	partition string
+}
+
+// RoundTrip implements http.RoundTripper
+func (t *consulTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	// a RoundTripper must not modify the request it was given
+	req = req.Clone(req.Context())
+	if t.token != nil {
+		if token := t.token.Token(); token != "" {
+			req.Header.Set("X-Consul-Token", token)
+		}
+	}
+	if t.partition != "" {
+		query := req.URL.Query()
+		if query.Get("partition") == "" {
+			query.Set("partition", t.partition)
+			req.URL.RawQuery = query.Encode()
+		}
+	}
+	return t.next.RoundTrip(req)
+}
+
+// tokenFile reads the ACL token from a file, and reads it again whenever
+// the file changes so that a rotated token is used without a restart
+type tokenFile struct {
+	path string
+
+	lock    sync.Mutex
+	token   string
+	modTime time.Time
+	size    int64
+	failed  bool // so that we only log the first of repeated failures
+}
+
+func newTokenFile(path string) (*tokenFile, error) {
+	t := &tokenFile{path: path}
+	if err := t.read(); err != nil {
+		return nil, fmt.Errorf("unable to read consul token file: %v", err)
+	}
+	return t, nil
+}
+
+// Token returns the current token, reading the file again if it has
+// changed. If the file can't be read we keep using the last token.
+func (t *tokenFile) Token() string {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	info, err := os.Stat(t.path)
+	if err == nil && (!info.ModTime().Equal(t.modTime) || info.Size() != t.size) {
+		err = t.read()
+	}
+	if err != nil && !t.failed {
+		log.Warnf("unable to read consul token file, using the last token read: %v", err)
+	}
+	t.failed = err != nil
+	return t.token
+}
+
+func (t *tokenFile) read() error {
+	info, err := os.Stat(t.path)
+	if err != nil {
+		return err
+	}
+	data, err := os.ReadFile(t.path)
+	if err != nil {
+		return err
+	}
+	t.token = strings.TrimSpace(string(data))
+	t.modTime = info.ModTime()
+	t.size = info.Size()
+	return nil
+}
@@ -15,6 +15,10 @@ type EventBus struct {
 	reload   bool
 	done     sync.WaitGroup
 
+	// the check in flight for Responsive, if any
+	probeLock sync.Mutex
+	probe     chan struct{}
+
 	// circular buffer of events
 	head int
 	tail int
@@ -33,6 +37,8 @@ func (bus *EventBus) enqueue(event Event) {
 // DebugEvents ...
 func (bus *EventBus) DebugEvents() []Event {
 	time.Sleep(100 * time.Millisecond)
+	bus.lock.Lock()
+	defer bus.lock.Unlock()
 	events := []Event{}
 	for {
 		if bus.head == -1 {
@@ -145,6 +151,36 @@ func (bus *EventBus) PublishSignal(sig string) {
 	bus.Publish(Event{Code: Signal, Source: sig})
 }
 
+// Responsive returns true if the EventBus can take an Event within the
+// timeout. Publish holds the lock while it hands the Event to each
+// Subscriber, so a bus that stays locked means that a Subscriber has
+// stopped receiving its events. Only one check waits on the lock at a
+// time, so that checking a stuck bus repeatedly doesn't pile up
+// goroutines.
+func (bus *EventBus) Responsive(timeout time.Duration) bool {
+	bus.probeLock.Lock()
+	if bus.probe == nil {
+		probe := make(chan struct{})
+		bus.probe = probe
+		go func() {
+			bus.lock.RLock()
+			bus.lock.RUnlock()
+			bus.probeLock.Lock()
+			bus.probe = nil
+			bus.probeLock.Unlock()
+			close(probe)
+		}()
+	}
+	probe := bus.probe
+	bus.probeLock.Unlock()
+	select {
+	case <-probe:
+		return true
+	case <-time.After(timeout):
+		return false
+	}
+}
+
 // SetReloadFlag sets the flag that Wait will use to signal to the main
 // App that we want to restart rather than be shut down
 func (bus *EventBus) SetReloadFlag() {
@@ -25,11 +25,12 @@ func _() {
 	_ = x[Startup-14]
 	_ = x[Shutdown-15]
 	_ = x[Signal-16]
+	_ = x[Trigger-17]
 }
 
-const eventCodename = "NoneExitSuccessExitFailedStoppingStoppedStatusHealthyStatusUnhealthyStatusChangedTimerExpiredEnterMaintenanceExitMaintenanceErrorQuitMetricStartupShutdownSignal"
+const eventCodename = "NoneExitSuccessExitFailedStoppingStoppedStatusHealthyStatusUnhealthyStatusChangedTimerExpiredEnterMaintenanceExitMaintenanceErrorQuitMetricStartupShutdownSignalTrigger"
 
-var eventCodeindex = [...]uint8{0, 4, 15, 25, 33, 40, 53, 68, 81, 93, 109, 124, 129, 133, 139, 146, 154, 160}
+var eventCodeindex = [...]uint8{0, 4, 15, 25, 33, 40, 53, 68, 81, 93, 109, 124, 129, 133, 139, 146, 154, 160, 167}
 
 func (i EventCode) String() string {
 	if i < 0 || i >= EventCode(len(eventCodeindex)-1) {
@@ -36,6 +36,7 @@ const (
 	Startup  // fired once after events are set up and event loop is started
 	Shutdown // fired once after all jobs exit or on receiving SIGTERM
 	Signal   // fired when a UNIX signal hits a CP process/supervisor
+	Trigger  // fired when a UNIX signal is mapped to start a job's exec
 )
 
 // global events
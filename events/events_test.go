@@ -6,6 +6,7 @@ import (
 	"reflect"
 	"sync"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 )
@@ -111,3 +112,24 @@ func TestPublishSignal(t *testing.T) {
 		assert.Equal(t, found, expected[n])
 	}
 }
+
+func TestBusResponsive(t *testing.T) {
+	bus := NewEventBus()
+	assert.True(t, bus.Responsive(100*time.Millisecond))
+
+	// a subscriber that never receives its events blocks the bus
+	stuck := &Subscriber{Rx: make(chan Event)}
+	stuck.Subscribe(bus)
+	go bus.Publish(Event{Code: Startup, Source: "global"})
+	assert.Eventually(t, func() bool {
+		return !bus.Responsive(50 * time.Millisecond)
+	}, time.Second, 10*time.Millisecond)
+
+	// repeated checks share the one that's waiting on the bus
+	probe := bus.probe
+	assert.False(t, bus.Responsive(10*time.Millisecond))
+	assert.True(t, probe == bus.probe, "expected the check in flight to be reused")
+
+	<-stuck.Rx
+	assert.True(t, bus.Responsive(100*time.Millisecond))
+}
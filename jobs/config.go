@@ -1,18 +1,25 @@
 package jobs
 
 import (
+	"bytes"
+	"encoding/json"
 	"fmt"
 	"os"
 	"reflect"
+	"regexp"
+	"sort"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/asokolov365/containerpilot/commands"
 	"github.com/asokolov365/containerpilot/config/decode"
 	"github.com/asokolov365/containerpilot/config/services"
 	"github.com/asokolov365/containerpilot/config/timing"
+	"github.com/asokolov365/containerpilot/config/validation"
 	"github.com/asokolov365/containerpilot/discovery"
 	"github.com/asokolov365/containerpilot/events"
+	"github.com/hashicorp/consul/api"
 	log "github.com/sirupsen/logrus"
 )
 
@@ -24,18 +31,20 @@ type Config struct {
 	Exec interface{} `mapstructure:"exec"`
 
 	// service discovery
-	Port              int           `mapstructure:"port"`
-	InitialStatus     string        `mapstructure:"initial_status"`
-	Interfaces        interface{}   `mapstructure:"interfaces"`
-	Tags              []string      `mapstructure:"tags"`
-	ConsulExtras      *ConsulExtras `mapstructure:"consul"`
-	serviceDefinition *discovery.ServiceDefinition
+	Port               int              `mapstructure:"port"`
+	InitialStatus      string           `mapstructure:"initial_status"`
+	Interfaces         interface{}      `mapstructure:"interfaces"`
+	Tags               []string         `mapstructure:"tags"`
+	ConsulExtras       *ConsulExtras    `mapstructure:"consul"`
+	Services           []*ServiceConfig `mapstructure:"services"`
+	serviceDefinitions []*discovery.ServiceDefinition
 
 	// health checking
 	Health            *HealthConfig `mapstructure:"health"`
 	healthCheckExec   *commands.Command
 	heartbeatInterval time.Duration
 	ttl               int
+	warningExitCodes  []int
 
 	// timeouts and restarts
 	ExecTimeout     string      `mapstructure:"timeout"`
@@ -56,6 +65,24 @@ type Config struct {
 
 	// logging
 	Logging *LoggingConfig `mapstructure:"logging"`
+
+	// process working directory and environment
+	Cwd      string            `mapstructure:"cwd"`
+	Env      map[string]string `mapstructure:"env"`
+	EnvFile  string            `mapstructure:"envFile"`
+	CleanEnv bool              `mapstructure:"cleanEnv"`
+
+	// process resource limits
+	Limits *LimitsConfig `mapstructure:"limits"`
+	limits *commands.Limits
+
+	// process user and privileges
+	User        string   `mapstructure:"user"`
+	Group       string   `mapstructure:"group"`
+	Groups      []string `mapstructure:"groups"`
+	Umask       string   `mapstructure:"umask"`
+	NoNewPrivs  bool     `mapstructure:"noNewPrivs"`
+	credentials *commands.Credentials
 }
 
 // WhenConfig determines when a Job runs (dependencies on other Jobs,
@@ -68,19 +95,78 @@ type WhenConfig struct {
 	Timeout   string `mapstructure:"timeout"`
 }
 
+// ServiceConfig configures one of several services that a Job registers
+// instead of a single service with the Job's name and port. All of them
+// share the Job's health check.
+type ServiceConfig struct {
+	Name          string        `mapstructure:"name"`
+	Port          int           `mapstructure:"port"`
+	InitialStatus string        `mapstructure:"initial_status"`
+	Interfaces    interface{}   `mapstructure:"interfaces"` // defaults to the Job's
+	Tags          []string      `mapstructure:"tags"`
+	ConsulExtras  *ConsulExtras `mapstructure:"consul"`
+}
+
 // HealthConfig configures the Job's health checks
 type HealthConfig struct {
-	CheckExec    interface{}    `mapstructure:"exec"`
-	CheckTimeout string         `mapstructure:"timeout"`
-	Heartbeat    int            `mapstructure:"interval"` // time in seconds
-	TTL          int            `mapstructure:"ttl"`      // time in seconds
-	Logging      *LoggingConfig `mapstructure:"logging"`
+	CheckExec        interface{}    `mapstructure:"exec"`
+	CheckTimeout     string         `mapstructure:"timeout"`
+	Heartbeat        int            `mapstructure:"interval"` // time in seconds
+	TTL              int            `mapstructure:"ttl"`      // time in seconds
+	WarningExitCodes []int          `mapstructure:"warningExitCodes"`
+	Logging          *LoggingConfig `mapstructure:"logging"`
 }
 
 // ConsulExtras handles additional Consul configuration.
 type ConsulExtras struct {
-	EnableTagOverride              bool   `mapstructure:"enableTagOverride"`
-	DeregisterCriticalServiceAfter string `mapstructure:"deregisterCriticalServiceAfter"`
+	EnableTagOverride              bool                           `mapstructure:"enableTagOverride"`
+	DeregisterCriticalServiceAfter string                         `mapstructure:"deregisterCriticalServiceAfter"`
+	Meta                           map[string]string              `mapstructure:"meta"`
+	Weights                        *ConsulWeights                 `mapstructure:"weights"`
+	TaggedAddresses                map[string]ConsulTaggedAddress `mapstructure:"taggedAddresses"`
+	Namespace                      string                         `mapstructure:"namespace"`
+	Checks                         []ConsulCheck                  `mapstructure:"checks"`
+	MaintenanceReason              string                         `mapstructure:"maintenanceReason"`
+}
+
+// ConsulCheck is an additional health check of the service that the
+// Consul agent runs itself, so that Consul still has a signal about the
+// service if ContainerPilot stops sending heartbeats. Only one of HTTP,
+// TCP, or GRPC can be set.
+type ConsulCheck struct {
+	Name          string            `mapstructure:"name"`
+	HTTP          string            `mapstructure:"http"`
+	Method        string            `mapstructure:"method"`
+	Header        map[string]string `mapstructure:"header"`
+	TCP           string            `mapstructure:"tcp"`
+	GRPC          string            `mapstructure:"grpc"`
+	GRPCUseTLS    bool              `mapstructure:"grpcUseTLS"`
+	TLSSkipVerify bool              `mapstructure:"tlsSkipVerify"`
+	Interval      string            `mapstructure:"interval"`
+	Timeout       string            `mapstructure:"timeout"`
+}
+
+// ConsulWeights are the weights of the service in DNS SRV responses,
+// depending on the status of its health checks
+type ConsulWeights struct {
+	Passing int `mapstructure:"passing"`
+	Warning int `mapstructure:"warning"`
+}
+
+// ConsulTaggedAddress is an additional address of the service, such as
+// its address on the WAN. The port defaults to the port of the Job.
+type ConsulTaggedAddress struct {
+	Address string `mapstructure:"address"`
+	Port    int    `mapstructure:"port"`
+}
+
+// LimitsConfig configures the resource limits for the Job's processes
+type LimitsConfig struct {
+	NoFile    *uint64 `mapstructure:"nofile"`
+	NProc     *uint64 `mapstructure:"nproc"`
+	Core      *uint64 `mapstructure:"core"`
+	Memory    string  `mapstructure:"memory"`
+	CPUWeight int     `mapstructure:"cpuWeight"`
 }
 
 // LoggingConfig handles job-specific logging fields
@@ -88,20 +174,30 @@ type LoggingConfig struct {
 	Raw bool `mapstructure:"raw"`
 }
 
-// NewConfigs parses json config into a validated slice of Configs
+// NewConfigs parses json config into a validated slice of Configs. All
+// the problems found in any of the jobs are returned together as
+// validation.Errors, at paths like `jobs[0].health.interval`.
 func NewConfigs(raw []interface{}, disc discovery.Backend) ([]*Config, error) {
 	var jobs []*Config
 	if raw == nil {
 		return jobs, nil
 	}
-	if err := decode.ToStruct(raw, &jobs); err != nil {
-		return nil, fmt.Errorf("job configuration error: %v", err)
+	var errs validation.Errors
+	for i, rawJob := range raw {
+		path := validation.Index("jobs", i)
+		job := &Config{}
+		if err := decode.ToStruct(rawJob, job); err != nil {
+			errs = append(errs, validation.FromDecode(path, err)...)
+			continue
+		}
+		errs.Add(path, job.Validate(disc))
+		jobs = append(jobs, job)
+	}
+	if len(errs) > 0 {
+		return nil, errs
 	}
 	stopDependencies := make(map[string]string)
 	for _, job := range jobs {
-		if err := job.Validate(disc); err != nil {
-			return nil, err
-		}
 		if job.whenEvent.Code == events.Stopping {
 			stopDependencies[job.whenEvent.Source] = job.Name
 		}
@@ -115,22 +211,44 @@ func NewConfigs(raw []interface{}, disc discovery.Backend) ([]*Config, error) {
 	return jobs, nil
 }
 
-// Validate ensures that a Config meets all constraints
+// Validate ensures that a Config meets all constraints. It returns
+// validation.Errors with every problem found, at paths relative to the
+// job.
 func (cfg *Config) Validate(disc discovery.Backend) error {
-	if err := cfg.validateDiscovery(disc); err != nil {
-		return err
+	var errs validation.Errors
+	errs.Add("", cfg.validateEnv())
+	errs.Add("", cfg.validateDiscovery(disc))
+	errs.Add("", cfg.validateWhen())
+	errs.Add("", cfg.validateStoppingTimeout())
+	errs.Add("", cfg.validateRestarts())
+	errs.Add("", cfg.validateExec())
+	// the job name defaults to the exec, so the credentials and limits
+	// (whose cgroup is named after the job) are validated after it
+	errs.Add("", cfg.validateCredentials())
+	errs.Add("", cfg.validateLimits())
+	for _, cmd := range []*commands.Command{cfg.exec, cfg.healthCheckExec} {
+		if cmd != nil {
+			cfg.setProcessConfig(cmd)
+		}
 	}
-	if err := cfg.validateWhen(); err != nil {
-		return err
+	return errs.ErrorOrNil()
+}
+
+// EnableHealthCheck sets up the health check of a job that isn't
+// advertised to a discovery backend, which otherwise doesn't run, so
+// that the job can be checked by the telemetry '/readyz' endpoint. It
+// returns validation.Errors at paths relative to the job.
+func (cfg *Config) EnableHealthCheck() error {
+	if cfg.Health == nil || cfg.healthCheckExec != nil {
+		return nil
 	}
-	if err := cfg.validateStoppingTimeout(); err != nil {
+	if err := cfg.validateHealthCheck(); err != nil {
 		return err
 	}
-	if err := cfg.validateRestarts(); err != nil {
-		return err
+	if cfg.healthCheckExec != nil {
+		cfg.setProcessConfig(cfg.healthCheckExec)
 	}
-
-	return cfg.validateExec()
+	return nil
 }
 
 func (cfg *Config) setStopping(name string) {
@@ -141,45 +259,140 @@ func (cfg *Config) validateDiscovery(disc discovery.Backend) error {
 	// if port isn't set or discovery is not configured then
 	// we won't do any discovery for this job
 	if cfg.Name != "" {
-		if cfg.Port == 0 || disc == nil || reflect.ValueOf(disc).IsNil() {
+		if (cfg.Port == 0 && len(cfg.Services) == 0) ||
+			disc == nil || reflect.ValueOf(disc).IsNil() {
 			return nil
 		}
 	}
 
+	var errs validation.Errors
 	// setting up discovery requires the TTL from the health check first
-	if err := cfg.validateHealthCheck(); err != nil {
-		return err
-	}
+	errs.Add("", cfg.validateHealthCheck())
 
-	// we only need to validate initialStatus if we're doing discovery.
-	if err := cfg.valdiateInitialStatus(); err != nil {
-		return err
-	}
+	if len(cfg.Services) > 0 {
+		if cfg.Port != 0 || len(cfg.Tags) > 0 ||
+			cfg.InitialStatus != "" || cfg.ConsulExtras != nil {
+			errs.Addf("services", "can't be set along with 'port', 'tags', "+
+				"'initial_status', or 'consul', which configure a single service")
+		}
+		names := map[string]bool{}
+		for i, service := range cfg.Services {
+			path := validation.Index("services", i)
+			errs.Add(path, service.validate())
+			if names[service.Name] {
+				errs.Addf(validation.Join(path, "name"),
+					"'%s' is used by another service", service.Name)
+			}
+			names[service.Name] = true
+		}
+	} else {
+		// we only need to validate initialStatus if we're doing discovery.
+		errs.Add("", validateInitialStatus(cfg.InitialStatus))
 
-	// we only need to validate the name if we're doing discovery;
-	// we'll just take the name of the exec otherwise
-	if err := services.ValidateName(cfg.Name, "consul"); err != nil {
-		return err
+		// we only need to validate the name if we're doing discovery;
+		// we'll just take the name of the exec otherwise
+		errs.Add("name", services.ValidateName(cfg.Name, "consul"))
+	}
+	if len(errs) > 0 {
+		return errs
 	}
 	return cfg.addDiscoveryConfig(disc)
 }
 
-func (cfg *Config) valdiateInitialStatus() error {
+func validateInitialStatus(status string) error {
 	// initial status is optional
-	if cfg.InitialStatus == "" {
+	if status == "" {
 		return nil
 	}
 
-	if cfg.InitialStatus != "passing" &&
-		cfg.InitialStatus != "warning" &&
-		cfg.InitialStatus != "critical" {
-		return fmt.Errorf("job[%s].initialStatus must be one of 'passing', 'warning' or 'critical'",
-			cfg.Name)
+	if status != "passing" &&
+		status != "warning" &&
+		status != "critical" {
+		return validation.Errorf("initial_status",
+			"must be one of 'passing', 'warning' or 'critical'")
 	}
 
 	return nil
 }
 
+func (service *ServiceConfig) validate() error {
+	var errs validation.Errors
+	errs.Add("name", services.ValidateName(service.Name, "consul"))
+	if service.Port < 1 || service.Port > 65535 {
+		errs.Addf("port", "must be a valid port")
+	}
+	errs.Add("", validateInitialStatus(service.InitialStatus))
+	return errs.ErrorOrNil()
+}
+
+func (cfg *Config) validateEnv() error {
+	keys := make([]string, 0, len(cfg.Env))
+	for key := range cfg.Env {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys) // for stable error messages
+	var errs validation.Errors
+	for _, key := range keys {
+		if key == "" || strings.ContainsAny(key, "= ") {
+			errs.Addf("env", "key '%s' is not a valid environment variable name", key)
+		}
+	}
+	return errs.ErrorOrNil()
+}
+
+// setProcessConfig applies the process configuration shared by the
+// job's exec and health check exec
+func (cfg *Config) setProcessConfig(cmd *commands.Command) {
+	cmd.Dir = cfg.Cwd
+	cmd.Env = cfg.Env
+	cmd.EnvFile = cfg.EnvFile
+	cmd.CleanEnv = cfg.CleanEnv
+	cmd.Credentials = cfg.credentials
+	cmd.Limits = cfg.limits
+}
+
+func (cfg *Config) validateCredentials() error {
+	creds := &commands.Credentials{
+		User:       cfg.User,
+		Group:      cfg.Group,
+		Groups:     cfg.Groups,
+		Umask:      cfg.Umask,
+		NoNewPrivs: cfg.NoNewPrivs,
+	}
+	// the credentials are top-level fields of the job and their errors
+	// already name the field
+	if err := creds.Validate(); err != nil {
+		return err
+	}
+	cfg.credentials = creds
+	return nil
+}
+
+func (cfg *Config) validateLimits() error {
+	if cfg.Limits == nil {
+		return nil
+	}
+	// the job name is used as the cgroup name, so it can't be a path
+	usesCgroup := cfg.Limits.Memory != "" || cfg.Limits.CPUWeight != 0
+	if usesCgroup && (cfg.Name == "" || strings.Contains(cfg.Name, "/")) {
+		return validation.Errorf("limits",
+			"memory and cpuWeight require a job name without '/'")
+	}
+	limits := &commands.Limits{
+		NoFile:    cfg.Limits.NoFile,
+		NProc:     cfg.Limits.NProc,
+		Core:      cfg.Limits.Core,
+		Memory:    cfg.Limits.Memory,
+		CPUWeight: cfg.Limits.CPUWeight,
+		Cgroup:    cfg.Name,
+	}
+	if err := limits.Validate(); err != nil {
+		return validation.New("limits", err)
+	}
+	cfg.limits = limits
+	return nil
+}
+
 func (cfg *Config) validateWhen() error {
 	if cfg.When == nil {
 		// set defaults (frequencyInterval will be zero-value)
@@ -193,8 +406,8 @@ func (cfg *Config) validateWhen() error {
 	if (cfg.When.Frequency != "" && cfg.When.Once != "") ||
 		(cfg.When.Frequency != "" && cfg.When.Each != "") ||
 		(cfg.When.Once != "" && cfg.When.Each != "") {
-		return fmt.Errorf("job[%s].when can have only one of 'interval', 'once', or 'each'",
-			cfg.Name)
+		return validation.Errorf("when",
+			"can have only one of 'interval', 'once', or 'each'")
 	}
 	if cfg.When.Frequency != "" {
 		return cfg.validateFrequency()
@@ -205,12 +418,12 @@ func (cfg *Config) validateWhen() error {
 func (cfg *Config) validateFrequency() error {
 	freq, err := timing.ParseDuration(cfg.When.Frequency)
 	if err != nil {
-		return fmt.Errorf("unable to parse job[%s].when.interval '%s': %v",
-			cfg.Name, cfg.When.Frequency, err)
+		return validation.Errorf("when.interval", "unable to parse '%s': %v",
+			cfg.When.Frequency, err)
 	}
 	if freq < taskMinDuration {
-		return fmt.Errorf("job[%s].when.interval '%s' cannot be less than %v",
-			cfg.Name, cfg.When.Frequency, taskMinDuration)
+		return validation.Errorf("when.interval", "'%s' cannot be less than %v",
+			cfg.When.Frequency, taskMinDuration)
 	}
 	cfg.freqInterval = freq
 	cfg.whenTimeout = time.Duration(0)
@@ -220,25 +433,26 @@ func (cfg *Config) validateFrequency() error {
 }
 
 func (cfg *Config) validateWhenEvent() error {
+	var errs validation.Errors
 	whenTimeout, err := timing.GetTimeout(cfg.When.Timeout)
 	if err != nil {
-		return fmt.Errorf("unable to parse job[%s].when.timeout: %v",
-			cfg.Name, err)
+		errs.Addf("when.timeout", "unable to parse '%s': %v", cfg.When.Timeout, err)
 	}
 	cfg.whenTimeout = whenTimeout
 
 	var eventCode events.EventCode
 	if cfg.When.Once != "" {
 		eventCode, err = events.FromString(cfg.When.Once)
+		errs.Add("when.once", err)
 		cfg.whenStartsLimit = 1
 	}
 	if cfg.When.Each != "" && cfg.When.Once == "" {
 		eventCode, err = events.FromString(cfg.When.Each)
+		errs.Add("when.each", err)
 		cfg.whenStartsLimit = unlimited
 	}
-	if err != nil {
-		return fmt.Errorf("unable to parse job[%s].when.event: %v",
-			cfg.Name, err)
+	if len(errs) > 0 {
+		return errs
 	}
 
 	if cfg.When.Source == "SIGHUP" || cfg.When.Source == "SIGUSR2" {
@@ -253,8 +467,8 @@ func (cfg *Config) validateWhenEvent() error {
 func (cfg *Config) validateStoppingTimeout() error {
 	stoppingTimeout, err := timing.GetTimeout(cfg.StopTimeout)
 	if err != nil {
-		return fmt.Errorf("unable to parse job[%s].stopTimeout '%s': %v",
-			cfg.Name, cfg.StopTimeout, err)
+		return validation.Errorf("stopTimeout", "unable to parse '%s': %v",
+			cfg.StopTimeout, err)
 	}
 	cfg.stoppingTimeout = stoppingTimeout
 	cfg.stoppingWaitEvent = events.NonEvent
@@ -270,14 +484,14 @@ func (cfg *Config) validateExec() error {
 	if cfg.ExecTimeout != "" {
 		execTimeout, err := timing.GetTimeout(cfg.ExecTimeout)
 		if err != nil {
-			return fmt.Errorf("unable to parse job[%s].timeout '%s': %v",
-				cfg.Name, cfg.ExecTimeout, err)
+			return validation.Errorf("timeout", "unable to parse '%s': %v",
+				cfg.ExecTimeout, err)
 		}
 		if execTimeout < time.Duration(1*time.Millisecond) {
 			// if there's no timeout set, that's ok, but if we have a timeout
 			// set we need to make sure it's functional
-			return fmt.Errorf("job[%s].timeout '%v' cannot be less than 1ms",
-				cfg.Name, cfg.ExecTimeout)
+			return validation.Errorf("timeout", "'%v' cannot be less than 1ms",
+				cfg.ExecTimeout)
 		}
 		cfg.execTimeout = execTimeout
 	}
@@ -288,7 +502,7 @@ func (cfg *Config) validateExec() error {
 		}
 		cmd, err := commands.NewCommand(cfg.Exec, cfg.execTimeout, fields)
 		if err != nil {
-			return fmt.Errorf("unable to create job[%s].exec: %v", cfg.Name, err)
+			return validation.Errorf("exec", "unable to create command: %v", err)
 		}
 		if cfg.Name == "" {
 			cfg.Name = cmd.Exec
@@ -301,16 +515,22 @@ func (cfg *Config) validateExec() error {
 
 func (cfg *Config) validateHealthCheck() error {
 	if cfg.Port != 0 && cfg.Health == nil && cfg.Name != "containerpilot" {
-		return fmt.Errorf("job[%s].health must be set if 'port' is set and Discovery service is defined", cfg.Name)
+		return validation.Errorf("health",
+			"must be set if 'port' is set and Discovery service is defined")
+	}
+	if len(cfg.Services) > 0 && cfg.Health == nil {
+		return validation.Errorf("health",
+			"must be set if 'services' is set and Discovery service is defined")
 	}
 	if cfg.Health == nil {
 		return nil // non-advertised jobs don't need health checks
 	}
+	var errs validation.Errors
 	if cfg.Health.Heartbeat < 1 {
-		return fmt.Errorf("job[%s].health.interval must be > 0", cfg.Name)
+		errs.Addf("health.interval", "must be > 0")
 	}
 	if cfg.Health.TTL < 1 {
-		return fmt.Errorf("job[%s].health.ttl must be > 0", cfg.Name)
+		errs.Addf("health.ttl", "must be > 0")
 	}
 
 	cfg.ttl = cfg.Health.TTL
@@ -320,14 +540,22 @@ func (cfg *Config) validateHealthCheck() error {
 	if cfg.Health.CheckTimeout != "" {
 		parsedTimeout, err := timing.GetTimeout(cfg.Health.CheckTimeout)
 		if err != nil {
-			return fmt.Errorf("could not parse job[%s].health.timeout '%s': %v",
-				cfg.Name, cfg.Health.CheckTimeout, err)
+			errs.Addf("health.timeout", "unable to parse '%s': %v",
+				cfg.Health.CheckTimeout, err)
 		}
 		checkTimeout = parsedTimeout
 	} else {
 		checkTimeout = cfg.heartbeatInterval
 	}
 
+	for i, code := range cfg.Health.WarningExitCodes {
+		if code < 1 || code > 255 {
+			errs.Addf(validation.Index("health.warningExitCodes", i),
+				"must be between 1 and 255")
+		}
+	}
+	cfg.warningExitCodes = cfg.Health.WarningExitCodes
+
 	if cfg.Health.CheckExec != nil {
 		// the telemetry service won't have a health check
 		checkName := "check." + cfg.Name
@@ -339,13 +567,14 @@ func (cfg *Config) validateHealthCheck() error {
 		log.Debugf("job[%s].health.exec fields: %v", cfg.Name, fields)
 		cmd, err := commands.NewCommand(cfg.Health.CheckExec, checkTimeout, fields)
 		if err != nil {
-			return fmt.Errorf("unable to create job[%s].health.exec: %v",
-				cfg.Name, err)
+			errs.Addf("health.exec", "unable to create command: %v", err)
+		} else {
+			cmd.Name = checkName
+			cmd.CaptureOutput = true // reported as the output of the TTL check
+			cfg.healthCheckExec = cmd
 		}
-		cmd.Name = checkName
-		cfg.healthCheckExec = cmd
 	}
-	return nil
+	return errs.ErrorOrNil()
 }
 
 func (cfg *Config) validateRestarts() error {
@@ -359,14 +588,14 @@ func (cfg *Config) validateRestarts() error {
 		}
 		return nil
 	}
-	const msg = `job[%s].restarts field '%v' invalid: %v`
+	const msg = `'%v' is invalid: %v`
 
 	switch t := cfg.Restarts.(type) {
 	case string:
 		if t == "unlimited" {
 			if cfg.When.Each != "" {
-				return fmt.Errorf(msg, cfg.Name, cfg.Restarts,
-					`may not be used when 'job.when.each' is set because it may result in infinite processes`)
+				return validation.Errorf("restarts", msg, cfg.Restarts,
+					`may not be used when 'when.each' is set because it may result in infinite processes`)
 			}
 			cfg.restartLimit = unlimited
 		} else if t == "never" {
@@ -374,7 +603,7 @@ func (cfg *Config) validateRestarts() error {
 		} else if i, err := strconv.Atoi(t); err == nil && i >= 0 {
 			cfg.restartLimit = i
 		} else {
-			return fmt.Errorf(msg, cfg.Name, cfg.Restarts,
+			return validation.Errorf("restarts", msg, cfg.Restarts,
 				`accepts positive integers, "unlimited", or "never"`)
 		}
 	case float64, int:
@@ -389,11 +618,11 @@ func (cfg *Config) validateRestarts() error {
 		} else if i, ok := t.(float64); ok && i >= 0 {
 			cfg.restartLimit = int(i)
 		} else {
-			return fmt.Errorf(msg, cfg.Name, cfg.Restarts,
+			return validation.Errorf("restarts", msg, cfg.Restarts,
 				`number must be positive integer`)
 		}
 	default:
-		return fmt.Errorf(msg, cfg.Name, cfg.Restarts,
+		return validation.Errorf("restarts", msg, cfg.Restarts,
 			`accepts positive integers, "unlimited", or "never"`)
 	}
 
@@ -401,49 +630,310 @@ func (cfg *Config) validateRestarts() error {
 }
 
 // addDiscoveryConfig validates the configuration for service discovery
-// and attaches the discovery.ServiceDefinition to the Config
+// and attaches the discovery.ServiceDefinitions to the Config
 func (cfg *Config) addDiscoveryConfig(disc discovery.Backend) error {
-	interfaces, ifaceErr := decode.ToStrings(cfg.Interfaces)
+	if len(cfg.Services) == 0 {
+		// the Job's own fields configure its only service
+		service, err := cfg.serviceDefinition(disc, &ServiceConfig{
+			Name:          cfg.Name,
+			Port:          cfg.Port,
+			InitialStatus: cfg.InitialStatus,
+			Interfaces:    cfg.Interfaces,
+			Tags:          cfg.Tags,
+			ConsulExtras:  cfg.ConsulExtras,
+		})
+		if err != nil {
+			return err
+		}
+		cfg.serviceDefinitions = []*discovery.ServiceDefinition{service}
+		return nil
+	}
+	var errs validation.Errors
+	cfg.serviceDefinitions = nil
+	for i, service := range cfg.Services {
+		definition, err := cfg.serviceDefinition(disc, service)
+		if err != nil {
+			errs.Add(validation.Index("services", i), err)
+			continue
+		}
+		cfg.serviceDefinitions = append(cfg.serviceDefinitions, definition)
+	}
+	return errs.ErrorOrNil()
+}
+
+// serviceDefinition creates the discovery.ServiceDefinition for one of the
+// Job's services, which has already been validated
+func (cfg *Config) serviceDefinition(disc discovery.Backend, service *ServiceConfig) (*discovery.ServiceDefinition, error) {
+	rawInterfaces := service.Interfaces
+	if rawInterfaces == nil {
+		rawInterfaces = cfg.Interfaces
+	}
+	interfaces, ifaceErr := decode.ToStrings(rawInterfaces)
 	if ifaceErr != nil {
-		return ifaceErr
+		return nil, validation.New("interfaces", ifaceErr)
 	}
 	ipAddress, err := services.GetIP(interfaces)
 	if err != nil {
-		return err
+		return nil, validation.New("interfaces", err)
 	}
 	hostname, _ := os.Hostname()
-	id := fmt.Sprintf("%s-%s", cfg.Name, hostname)
+	id := fmt.Sprintf("%s-%s", service.Name, hostname)
+
+	definition := &discovery.ServiceDefinition{
+		ID:            id,
+		Name:          service.Name,
+		Port:          service.Port,
+		TTL:           cfg.ttl,
+		Tags:          service.Tags,
+		InitialStatus: service.InitialStatus,
+		IPAddress:     ipAddress,
+		Backend:       disc,
+	}
+	if service.ConsulExtras != nil {
+		if err := service.ConsulExtras.validate(); err != nil {
+			var errs validation.Errors
+			errs.Add("consul", err)
+			return nil, errs
+		}
+		if err := service.ConsulExtras.addTo(definition); err != nil {
+			return nil, validation.New("consul.namespace", err)
+		}
+	}
+	return definition, nil
+}
 
-	var (
-		enableTagOverride bool
-		deregAfter        string
-	)
+// Consul's limits on service metadata
+const (
+	maxMetaPairs    = 64
+	maxMetaKeyLen   = 128
+	maxMetaValueLen = 512
+)
 
-	if cfg.ConsulExtras != nil {
-		deregAfter = cfg.ConsulExtras.DeregisterCriticalServiceAfter
-		_, err := time.ParseDuration(deregAfter)
-		if err != nil {
-			return fmt.Errorf(
-				"unable to parse job[%s].consul.deregisterCriticalServiceAfter: %s",
-				cfg.Name, err)
-		}
-		enableTagOverride = cfg.ConsulExtras.EnableTagOverride
-	}
-	cfg.serviceDefinition = &discovery.ServiceDefinition{
-		ID:                             id,
-		Name:                           cfg.Name,
-		Port:                           cfg.Port,
-		TTL:                            cfg.ttl,
-		Tags:                           cfg.Tags,
-		InitialStatus:                  cfg.InitialStatus,
-		IPAddress:                      ipAddress,
-		DeregisterCriticalServiceAfter: deregAfter,
-		EnableTagOverride:              enableTagOverride,
-		Consul:                         disc,
+var metaKeyRe = regexp.MustCompile(`^[a-zA-Z0-9_-]+$`)
+
+// taggedAddressKeys are the tagged addresses that Consul understands
+var taggedAddressKeys = []string{
+	"lan", "lan_ipv4", "lan_ipv6", "wan", "wan_ipv4", "wan_ipv6"}
+
+func isTaggedAddressKey(key string) bool {
+	for _, k := range taggedAddressKeys {
+		if k == key {
+			return true
+		}
+	}
+	return false
+}
+
+// validate checks the extras against the limits that Consul applies, so
+// that the problems are reported with the config rather than when the
+// service fails to register
+func (extras *ConsulExtras) validate() error {
+	var errs validation.Errors
+	if after := extras.DeregisterCriticalServiceAfter; after != "" {
+		if _, err := time.ParseDuration(after); err != nil {
+			errs.Addf("deregisterCriticalServiceAfter", "unable to parse '%s': %v",
+				after, err)
+		}
+	}
+	if len(extras.Meta) > maxMetaPairs {
+		errs.Addf("meta", "must have no more than %d keys", maxMetaPairs)
+	}
+	metaKeys := make([]string, 0, len(extras.Meta))
+	for key := range extras.Meta {
+		metaKeys = append(metaKeys, key)
+	}
+	sort.Strings(metaKeys) // for stable error messages
+	for _, key := range metaKeys {
+		path := validation.Join("meta", key)
+		switch {
+		case !metaKeyRe.MatchString(key):
+			errs.Addf(path, "key must only contain letters, numbers, dashes, and underscores")
+		case len(key) > maxMetaKeyLen:
+			errs.Addf(path, "key must be no longer than %d characters", maxMetaKeyLen)
+		case strings.HasPrefix(key, "consul-"):
+			errs.Addf(path, "key must not start with 'consul-', which is reserved by Consul")
+		}
+		if len(extras.Meta[key]) > maxMetaValueLen {
+			errs.Addf(path, "must be no longer than %d characters", maxMetaValueLen)
+		}
+	}
+	if extras.Weights != nil {
+		if extras.Weights.Passing < 1 {
+			errs.Addf("weights.passing", "must be > 0")
+		}
+		if extras.Weights.Warning < 0 {
+			errs.Addf("weights.warning", "must be >= 0")
+		}
+	}
+	keys := make([]string, 0, len(extras.TaggedAddresses))
+	for key := range extras.TaggedAddresses {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys) // for stable error messages
+	for _, key := range keys {
+		path := validation.Join("taggedAddresses", key)
+		if !isTaggedAddressKey(key) {
+			errs.Addf(path, "is not a tagged address: must be one of %s",
+				strings.Join(taggedAddressKeys, ", "))
+			continue
+		}
+		tagged := extras.TaggedAddresses[key]
+		if tagged.Address == "" {
+			errs.Addf(validation.Join(path, "address"), "must not be blank")
+		}
+		if tagged.Port < 0 || tagged.Port > 65535 {
+			errs.Addf(validation.Join(path, "port"), "must be a valid port")
+		}
+	}
+	names := map[string]bool{}
+	for i, check := range extras.Checks {
+		path := validation.Index("checks", i)
+		errs.Add(path, check.validate())
+		if names[check.Name] {
+			errs.Addf(validation.Join(path, "name"), "'%s' is used by another check", check.Name)
+		}
+		names[check.Name] = true
+	}
+	return errs.ErrorOrNil()
+}
+
+func (check ConsulCheck) validate() error {
+	var errs validation.Errors
+	if check.Name == "" {
+		errs.Addf("name", "must not be blank")
+	}
+	targets := 0
+	for _, target := range []string{check.HTTP, check.TCP, check.GRPC} {
+		if target != "" {
+			targets++
+		}
+	}
+	if targets != 1 {
+		errs.Addf("", "must have exactly one of 'http', 'tcp', or 'grpc'")
+	}
+	if check.HTTP == "" && (check.Method != "" || len(check.Header) > 0) {
+		errs.Addf("", "'method' and 'header' can only be set for 'http' checks")
+	}
+	if check.Interval == "" {
+		errs.Addf("interval", "must be set")
+	} else if interval, err := timing.ParseDuration(check.Interval); err != nil {
+		errs.Addf("interval", "unable to parse '%s': %v", check.Interval, err)
+	} else if interval <= 0 {
+		errs.Addf("interval", "must be > 0")
+	}
+	if _, err := timing.GetTimeout(check.Timeout); err != nil {
+		errs.Addf("timeout", "unable to parse '%s': %v", check.Timeout, err)
+	}
+	return errs.ErrorOrNil()
+}
+
+// registration returns the registration of the check for the service.
+// The check has already been validated.
+func (check ConsulCheck) registration(serviceID string) *api.AgentCheckRegistration {
+	interval, _ := timing.ParseDuration(check.Interval)
+	timeout, _ := timing.GetTimeout(check.Timeout)
+	reg := &api.AgentCheckRegistration{
+		ID:        fmt.Sprintf("service:%s:%s", serviceID, check.Name),
+		Name:      check.Name,
+		ServiceID: serviceID,
+		AgentServiceCheck: api.AgentServiceCheck{
+			HTTP:          check.HTTP,
+			Method:        check.Method,
+			TCP:           check.TCP,
+			GRPC:          check.GRPC,
+			GRPCUseTLS:    check.GRPCUseTLS,
+			TLSSkipVerify: check.TLSSkipVerify,
+			Interval:      interval.String(),
+		},
+	}
+	if timeout > 0 {
+		reg.Timeout = timeout.String()
+	}
+	if len(check.Header) > 0 {
+		reg.Header = map[string][]string{}
+		for key, value := range check.Header {
+			reg.Header[key] = []string{value}
+		}
+	}
+	return reg
+}
+
+// addTo adds the extras to the service definition. A service in another
+// namespace needs a client for that namespace.
+func (extras *ConsulExtras) addTo(service *discovery.ServiceDefinition) error {
+	backend, err := discovery.InNamespace(service.Backend, extras.Namespace)
+	if err != nil {
+		return err
+	}
+	service.Backend = backend
+	service.DeregisterCriticalServiceAfter = extras.DeregisterCriticalServiceAfter
+	service.EnableTagOverride = extras.EnableTagOverride
+	service.Meta = extras.Meta
+	service.Namespace = extras.Namespace
+	service.MaintenanceReason = extras.MaintenanceReason
+	if extras.Weights != nil {
+		service.Weights = &api.AgentWeights{
+			Passing: extras.Weights.Passing,
+			Warning: extras.Weights.Warning,
+		}
+	}
+	if len(extras.TaggedAddresses) > 0 {
+		service.TaggedAddresses = map[string]api.ServiceAddress{}
+		for key, tagged := range extras.TaggedAddresses {
+			port := tagged.Port
+			if port == 0 {
+				port = service.Port
+			}
+			service.TaggedAddresses[key] = api.ServiceAddress{
+				Address: tagged.Address, Port: port}
+		}
+	}
+	for _, check := range extras.Checks {
+		service.Checks = append(service.Checks, check.registration(service.ID))
 	}
 	return nil
 }
 
+// Equal returns true if the other Config would create an identical Job,
+// so that a running Job can be kept as-is when the config is reloaded
+func (cfg *Config) Equal(other *Config) bool {
+	if cfg.stoppingWaitEvent != other.stoppingWaitEvent {
+		return false
+	}
+	// the IP addresses are looked up from the interfaces during
+	// validation, so they can change even if the config hasn't
+	if len(cfg.serviceDefinitions) != len(other.serviceDefinitions) {
+		return false
+	}
+	for i, service := range cfg.serviceDefinitions {
+		if service.IPAddress != other.serviceDefinitions[i].IPAddress {
+			return false
+		}
+	}
+	this, err := json.Marshal(cfg)
+	if err != nil {
+		return false
+	}
+	that, err := json.Marshal(other)
+	if err != nil {
+		return false
+	}
+	return bytes.Equal(this, that)
+}
+
+// DependsOn returns the name of the source of the event that starts the
+// Job, such as another Job or Watch
+func (cfg *Config) DependsOn() string {
+	return cfg.whenEvent.Source
+}
+
+// IsStopHook returns true for "pre-stop" and "post-stop" style Jobs,
+// which run when the Job they depend on is stopping or stopped
+func (cfg *Config) IsStopHook() bool {
+	return cfg.whenEvent.Code == events.Stopping ||
+		cfg.whenEvent.Code == events.Stopped
+}
+
 // String implements the stdlib fmt.Stringer interface for pretty-printing
 func (cfg *Config) String() string {
 	return "jobs.Config[" + cfg.Name + "]"
@@ -3,11 +3,15 @@ package jobs
 import (
 	"fmt"
 	"io/ioutil"
+	"os"
 	"testing"
 	"time"
 
+	"github.com/hashicorp/consul/api"
 	"github.com/stretchr/testify/assert"
 
+	"github.com/asokolov365/containerpilot/commands"
+	"github.com/asokolov365/containerpilot/config/validation"
 	"github.com/asokolov365/containerpilot/events"
 	"github.com/asokolov365/containerpilot/tests"
 	"github.com/asokolov365/containerpilot/tests/mocks"
@@ -51,7 +55,7 @@ func TestJobConfigServiceWithPreStart(t *testing.T) {
 	assert.Equal(job1.Port, 0, "config for job1.Port")
 	assert.Equal(job1.restartLimit, 0, "config for job1.restartLimit")
 	assert.Nil(job1.Restarts, "config for job1.Restarts")
-	assert.Nil(job1.serviceDefinition, "config for job1.serviceDefinition")
+	assert.Nil(job1.serviceDefinitions, "config for job1.serviceDefinitions")
 }
 
 func TestJobConfigHealthTimeout(t *testing.T) {
@@ -128,6 +132,28 @@ func TestJobConfigServiceNonAdvertised(t *testing.T) {
 	assert.Equal(job.restartLimit, unlimited, "config for job.restartLimit")
 }
 
+func TestJobConfigHealthWithoutDiscovery(t *testing.T) {
+	// a non-advertised job doesn't run its health check by default
+	cfg := `[{name: "myName", exec: "true", health: {exec: "true", ttl: 5}}]`
+	jobs, err := NewConfigs(tests.DecodeRawToSlice(cfg), nil)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	assert.Nil(t, jobs[0].healthCheckExec, "config for job.healthCheckExec")
+	assert.EqualError(t, jobs[0].EnableHealthCheck(), "health.interval: must be > 0")
+
+	cfg = `[{name: "myName", exec: "true", health: {exec: "true", interval: 2, ttl: 5}}]`
+	jobs, err = NewConfigs(tests.DecodeRawToSlice(cfg), nil)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	assert.NoError(t, jobs[0].EnableHealthCheck())
+	assert.NotNil(t, jobs[0].healthCheckExec, "config for job.healthCheckExec")
+	assert.Equal(t, 2*time.Second, jobs[0].heartbeatInterval,
+		"config for job.heartbeatInterval")
+	assert.Empty(t, jobs[0].serviceDefinitions)
+}
+
 func TestJobConfigServiceWithInitialStatus(t *testing.T) {
 	jobs := loadTestConfig(t)
 	assert := assert.New(t)
@@ -164,6 +190,136 @@ func TestJobConfigConsulExtras(t *testing.T) {
 		"config for job.ConsulExtras.EnableTagOverride")
 	assert.Nil(job.Restarts, "config for job.Restarts") // this the parsed value only
 	assert.Equal(job.restartLimit, 0, "config.for job.restartLimit")
+
+	service := job.serviceDefinitions[0]
+	assert.Equal(map[string]string{"version": "2", "lb-route": "/api"},
+		service.Meta, "config for service.Meta")
+	assert.Equal(&api.AgentWeights{Passing: 10, Warning: 1},
+		service.Weights, "config for service.Weights")
+	assert.Equal("upgrading", service.MaintenanceReason,
+		"config for service.MaintenanceReason")
+	assert.Equal(map[string]api.ServiceAddress{
+		"wan":      {Address: "203.0.113.5", Port: 80},
+		"lan_ipv6": {Address: "fd00::5", Port: 8080},
+	}, service.TaggedAddresses, "config for service.TaggedAddresses")
+	assert.Equal([]*api.AgentCheckRegistration{
+		{
+			ID:        "service:" + service.ID + ":http",
+			Name:      "http",
+			ServiceID: service.ID,
+			AgentServiceCheck: api.AgentServiceCheck{
+				HTTP:     "http://localhost:8080/health",
+				Header:   map[string][]string{"X-Check": {"consul"}},
+				Interval: "10s",
+				Timeout:  "2s",
+			},
+		},
+		{
+			ID:        "service:" + service.ID + ":tcp",
+			Name:      "tcp",
+			ServiceID: service.ID,
+			AgentServiceCheck: api.AgentServiceCheck{
+				TCP:      "localhost:8080",
+				Interval: "30s",
+			},
+		},
+	}, service.Checks, "config for service.Checks")
+}
+
+func TestErrJobConfigConsulExtras(t *testing.T) {
+	testCfg := tests.DecodeRawToSlice(`[{
+	name: "web", exec: "/bin/web", port: 80, interfaces: "static:10.0.0.1",
+	health: {exec: "/bin/check", interval: 1, ttl: 5},
+	consul: {
+		meta: {"consul-version": "1", "bad key": "x"},
+		weights: {passing: 0, warning: -1},
+		taggedAddresses: {wan: {port: 80}, public: {address: "203.0.113.5"}},
+		checks: [
+			{name: "a", http: "http://localhost/", tcp: "localhost:80", interval: "10s"},
+			{name: "a", tcp: "localhost:80", method: "GET", interval: "0s", timeout: "xx"},
+			{grpc: "localhost:9090"}
+		]
+	}}]`)
+	_, err := NewConfigs(testCfg, noop)
+	errs, ok := err.(validation.Errors)
+	if !ok {
+		t.Fatalf("expected validation.Errors but got %T: %v", err, err)
+	}
+	assert.Equal(t, []string{
+		"jobs[0].consul.meta.bad key: key must only contain letters, numbers, dashes, and underscores",
+		"jobs[0].consul.meta.consul-version: key must not start with 'consul-', which is reserved by Consul",
+		"jobs[0].consul.weights.passing: must be > 0",
+		"jobs[0].consul.weights.warning: must be >= 0",
+		"jobs[0].consul.taggedAddresses.public: is not a tagged address: " +
+			"must be one of lan, lan_ipv4, lan_ipv6, wan, wan_ipv4, wan_ipv6",
+		"jobs[0].consul.taggedAddresses.wan.address: must not be blank",
+		"jobs[0].consul.checks[0]: must have exactly one of 'http', 'tcp', or 'grpc'",
+		"jobs[0].consul.checks[1]: 'method' and 'header' can only be set for 'http' checks",
+		"jobs[0].consul.checks[1].interval: must be > 0",
+		"jobs[0].consul.checks[1].timeout: unable to parse 'xx': time: invalid duration \"xx\"",
+		"jobs[0].consul.checks[1].name: 'a' is used by another check",
+		"jobs[0].consul.checks[2].name: must not be blank",
+		"jobs[0].consul.checks[2].interval: must be set",
+	}, errorStrings(errs))
+}
+
+func TestJobConfigServices(t *testing.T) {
+	job := loadTestConfig(t)[0]
+	assert := assert.New(t)
+	assert.Len(job.serviceDefinitions, 2, "config for job.serviceDefinitions")
+
+	http, admin := job.serviceDefinitions[0], job.serviceDefinitions[1]
+	hostname, _ := os.Hostname()
+	assert.Equal("app-http-"+hostname, http.ID)
+	assert.Equal("app-http", http.Name)
+	assert.Equal(8080, http.Port)
+	assert.Equal(30, http.TTL)
+	assert.Equal([]string{"http"}, http.Tags)
+	assert.Equal("warning", http.InitialStatus)
+	assert.NotEmpty(http.IPAddress, "expected IP from the job's interfaces")
+
+	assert.Equal("app-admin-"+hostname, admin.ID)
+	assert.Equal(9090, admin.Port)
+	assert.Equal(30, admin.TTL)
+	assert.Equal("192.0.2.10", admin.IPAddress)
+	assert.Equal([]string{"admin", "metrics"}, admin.Tags)
+	assert.Equal(map[string]string{"path": "/metrics"}, admin.Meta)
+	assert.Len(admin.Checks, 1)
+	assert.Equal("service:"+admin.ID+":metrics", admin.Checks[0].ID)
+
+	// both services share the job's health status
+	assert.Equal(job.serviceDefinitions, NewJob(job).Services)
+}
+
+func TestErrJobConfigServices(t *testing.T) {
+	testCfg := tests.DecodeRawToSlice(`[
+	{
+		name: "app", exec: "/bin/app", port: 80, interfaces: "static:10.0.0.1",
+		health: {exec: "/bin/check", interval: 1, ttl: 5},
+		services: [
+			{name: "app-http", port: 8080, initial_status: "ok"},
+			{name: "app-http", port: 0},
+			{name: "app_admin", port: 9090, consul: {weights: {passing: 0}}}
+		]
+	},
+	{
+		name: "other", exec: "/bin/other",
+		services: [{name: "other", port: 8080}]
+	}]`)
+	_, err := NewConfigs(testCfg, noop)
+	errs, ok := err.(validation.Errors)
+	if !ok {
+		t.Fatalf("expected validation.Errors but got %T: %v", err, err)
+	}
+	assert.Equal(t, []string{
+		"jobs[0].services: can't be set along with 'port', 'tags', " +
+			"'initial_status', or 'consul', which configure a single service",
+		"jobs[0].services[0].initial_status: must be one of 'passing', 'warning' or 'critical'",
+		"jobs[0].services[1].port: must be a valid port",
+		"jobs[0].services[1].name: 'app-http' is used by another service",
+		"jobs[0].services[2].name: must be alphanumeric with dashes to comply with service discovery",
+		"jobs[1].health: must be set if 'services' is set and Discovery service is defined",
+	}, errorStrings(errs))
 }
 
 func TestJobConfigSmokeTest(t *testing.T) {
@@ -245,15 +401,15 @@ func TestJobConfigValidateName(t *testing.T) {
 
 	cfgA := `[{name: "", port: 80, health: {exec: "myhealth", interval: 1, ttl: 3}}]`
 	_, err := NewConfigs(tests.DecodeRawToSlice(cfgA), noop)
-	assert.EqualError(t, err, "'name' must not be blank")
+	assert.EqualError(t, err, "jobs[0].name: must not be blank")
 
 	cfgB := `[{name: "", exec: "myexec", port: 80, health: {exec: "myhealth", interval: 1, ttl: 3}}]`
 	_, err = NewConfigs(tests.DecodeRawToSlice(cfgB), noop)
-	assert.EqualError(t, err, "'name' must not be blank")
+	assert.EqualError(t, err, "jobs[0].name: must not be blank")
 
 	cfgC := `[{name: "", exec: "myexec"}]`
 	_, err = NewConfigs(tests.DecodeRawToSlice(cfgC), nil)
-	assert.EqualError(t, err, "'name' must not be blank")
+	assert.EqualError(t, err, "jobs[0].name: must not be blank")
 
 	// invalid name is permitted if there's no 'port' config
 	cfgD := `[{name: "myjob_invalid_name", exec: "myexec"}]`
@@ -267,15 +423,15 @@ func TestJobConfigValidateDiscovery(t *testing.T) {
 
 	cfgA := `[{name: "myName", port: 80, interfaces: ["inet", "lo0"]}]`
 	_, err := NewConfigs(tests.DecodeRawToSlice(cfgA), noop)
-	assert.EqualError(t, err, "job[myName].health must be set if 'port' is set and Discovery service is defined")
+	assert.EqualError(t, err, "jobs[0].health: must be set if 'port' is set and Discovery service is defined")
 
 	cfgB := `[{name: "myName", port: 80, interfaces: ["inet", "lo0"], health: {interval: 1}}]`
 	_, err = NewConfigs(tests.DecodeRawToSlice(cfgB), noop)
-	assert.EqualError(t, err, "job[myName].health.ttl must be > 0")
+	assert.EqualError(t, err, "jobs[0].health.ttl: must be > 0")
 
 	cfgC := `[{name: "myName", port: 80, initialStatus: "invalid", interfaces: ["inet", "lo0"], health: {interval: 1, ttl: 1}}]`
 	_, err = NewConfigs(tests.DecodeRawToSlice(cfgC), noop)
-	assert.EqualError(t, err, "job configuration error: 1 error(s) decoding:\n\n* '[0]' has invalid keys: initialStatus")
+	assert.EqualError(t, err, "jobs[0].initialStatus: is not a known config key")
 
 	// no health check shouldn't return an error
 	cfgD := `[{name: "myName", port: 80, interfaces: ["inet", "lo0"], health: {interval: 1, ttl: 1}}]`
@@ -308,23 +464,24 @@ func TestJobConfigValidateFrequency(t *testing.T) {
 	}
 	expectErr(
 		`[{name: "A", exec: "/bin/taskA", timeout: "1s", when: {interval: "-1s"}}]`,
-		"job[A].when.interval '-1s' cannot be less than 1ms")
+		"jobs[0].when.interval: '-1s' cannot be less than 1ms")
 
 	expectErr(
 		`[{name: "B", exec: "/bin/taskB", timeout: "1s", when: {interval: "1ns"}}]`,
-		"job[B].when.interval '1ns' cannot be less than 1ms")
+		"jobs[0].when.interval: '1ns' cannot be less than 1ms")
 
 	expectErr(
 		`[{name: "C", exec: "/bin/taskC", timeout: "-1ms", when: {interval: "1ms"}}]`,
-		"job[C].timeout '-1ms' cannot be less than 1ms")
+		"jobs[0].timeout: '-1ms' cannot be less than 1ms")
 
 	expectErr(
 		`[{name: "D", exec: "/bin/taskD", timeout: "1ns", when: {interval: "1ms"}}]`,
-		"job[D].timeout '1ns' cannot be less than 1ms")
+		"jobs[0].timeout: '1ns' cannot be less than 1ms")
 
 	expectErr(
 		`[{name: "E", exec: "/bin/taskE", timeout: "1ns", when: {interval: "xx"}}]`,
-		"unable to parse job[E].when.interval 'xx': time: invalid duration \"xx\"")
+		"jobs[0].when.interval: unable to parse 'xx': time: invalid duration \"xx\"\n"+
+			"jobs[0].timeout: '1ns' cannot be less than 1ms")
 
 	testCfg := tests.DecodeRawToSlice(
 		`[{name: "F", exec: "/bin/taskF", when: {interval: "1ms"}}]`)
@@ -379,7 +536,7 @@ func TestJobConfigValidateExec(t *testing.T) {
 		timeout: "xx"
 	}]`)
 	_, err = NewConfigs(testCfg, noop)
-	expected := "unable to parse job[serviceC].timeout 'xx': time: invalid duration \"xx\""
+	expected := "jobs[0].timeout: unable to parse 'xx': time: invalid duration \"xx\""
 	if err == nil || err.Error() != expected {
 		t.Fatalf("expected '%s', got '%v'", expected, err)
 	}
@@ -390,7 +547,7 @@ func TestJobConfigValidateExec(t *testing.T) {
 		exec: ""
 	}]`)
 	_, err = NewConfigs(testCfg, noop)
-	expected = "unable to create job[serviceD].exec: received zero-length argument"
+	expected = "jobs[0].exec: unable to create command: received zero-length argument"
 	if err == nil || err.Error() != expected {
 		t.Fatalf("expected '%s', got '%v'", expected, err)
 	}
@@ -400,7 +557,7 @@ func TestJobConfigValidateExec(t *testing.T) {
 func TestJobConfigValidateRestarts(t *testing.T) {
 
 	expectErr := func(test, name, val, msg string) {
-		errMsg := fmt.Sprintf(`job[%s].restarts field '%s' invalid: %s`, name, val, msg)
+		errMsg := fmt.Sprintf(`jobs[0].restarts: '%s' is invalid: %s`, val, msg)
 		testCfg := tests.DecodeRawToSlice(test)
 		_, err := NewConfigs(testCfg, nil)
 		assert.Equal(t, err.Error(), errMsg)
@@ -418,7 +575,7 @@ func TestJobConfigValidateRestarts(t *testing.T) {
 		`[{name: "D", exec: "/bin/coprocessD", restarts: "unlimited",
 		 when: { each: "healthy", source: "other"} }]`,
 		"D", "unlimited",
-		`may not be used when 'job.when.each' is set because it may result in infinite processes`)
+		`may not be used when 'when.each' is set because it may result in infinite processes`)
 
 	testCfg := tests.DecodeRawToSlice(`[
 	{ name: "D", exec: "/bin/coprocessD", "restarts": "unlimited" },
@@ -451,21 +608,133 @@ func TestHealthChecksConfigError(t *testing.T) {
 	}
 	expectErr(
 		`[{name: "myName", port: 65535, health: {exec: "/bin/true"}}]`,
-		"job[myName].health.interval must be > 0")
+		"jobs[0].health.interval: must be > 0\njobs[0].health.ttl: must be > 0")
 	expectErr(
 		`[{name: "myName", port: 65535, health: {exec: "/bin/true", interval: 1}}]`,
-		"job[myName].health.ttl must be > 0")
+		"jobs[0].health.ttl: must be > 0")
 	expectErr(
 		`[{name: "myName", port: 65535, health: {exec: "", interval: 1, ttl: 5}}]`,
-		"unable to create job[myName].health.exec: received zero-length argument")
+		"jobs[0].health.exec: unable to create command: received zero-length argument")
 	expectErr(
 		`[{name: "myName", port: 65535, health: {exec: "/bin/true", interval: 1, ttl: 5, timeout: "xx"}}]`,
-		"could not parse job[myName].health.timeout 'xx': time: invalid duration \"xx\"")
+		"jobs[0].health.timeout: unable to parse 'xx': time: invalid duration \"xx\"")
+	expectErr(
+		`[{name: "myName", port: 65535, health: {exec: "/bin/true", interval: 1, ttl: 5, warningExitCodes: [1, 0, 256]}}]`,
+		"jobs[0].health.warningExitCodes[1]: must be between 1 and 255\njobs[0].health.warningExitCodes[2]: must be between 1 and 255")
+}
+
+func TestJobConfigCredentials(t *testing.T) {
+	testCfg := tests.DecodeRawToSlice(`[{
+	name: "myName", exec: "/bin/app", port: 80,
+	health: {exec: "/bin/check", interval: 1, ttl: 5},
+	user: "0", group: "0", groups: ["1234"], umask: "0027", noNewPrivs: true
+	}]`)
+	jobs, err := NewConfigs(testCfg, noop)
+	if err != nil {
+		t.Fatal(err)
+	}
+	job := jobs[0]
+	assert := assert.New(t)
+	assert.Equal("0027", job.credentials.Umask, "config for job.credentials.Umask")
+	assert.True(job.credentials.NoNewPrivs, "config for job.credentials.NoNewPrivs")
+	assert.Equal(job.credentials, job.exec.Credentials, "config for job.exec.Credentials")
+	assert.Equal(job.credentials, job.healthCheckExec.Credentials,
+		"config for job.healthCheckExec.Credentials")
+
+	testCfg = tests.DecodeRawToSlice(
+		`[{name: "myName", exec: "/bin/app", umask: "0999"}]`)
+	_, err = NewConfigs(testCfg, noop)
+	assert.EqualError(err,
+		"jobs[0]: umask '0999' must be an octal value between 0 and 0777")
+}
+
+func TestJobConfigEnv(t *testing.T) {
+	testCfg := tests.DecodeRawToSlice(`[{
+	name: "myName", exec: "/bin/app", port: 80,
+	health: {exec: "/bin/check", interval: 1, ttl: 5},
+	cwd: "/srv/app", env: {FOO: "bar", PORT: 80}, envFile: "/srv/app/.env",
+	cleanEnv: true
+	}]`)
+	jobs, err := NewConfigs(testCfg, noop)
+	if err != nil {
+		t.Fatal(err)
+	}
+	job := jobs[0]
+	assert := assert.New(t)
+	for _, cmd := range []*commands.Command{job.exec, job.healthCheckExec} {
+		assert.Equal("/srv/app", cmd.Dir, "config for %s.Dir", cmd.Name)
+		assert.Equal(map[string]string{"FOO": "bar", "PORT": "80"}, cmd.Env,
+			"config for %s.Env", cmd.Name)
+		assert.Equal("/srv/app/.env", cmd.EnvFile, "config for %s.EnvFile", cmd.Name)
+		assert.True(cmd.CleanEnv, "config for %s.CleanEnv", cmd.Name)
+	}
+
+	testCfg = tests.DecodeRawToSlice(
+		`[{name: "myName", exec: "/bin/app", env: {"A=B": "C"}}]`)
+	_, err = NewConfigs(testCfg, noop)
+	assert.EqualError(err,
+		"jobs[0].env: key 'A=B' is not a valid environment variable name")
+}
+
+func TestJobConfigLimits(t *testing.T) {
+	testCfg := tests.DecodeRawToSlice(`[{
+	name: "myName", exec: "/bin/app",
+	limits: {nofile: 1024, core: 0, memory: "128M", cpuWeight: 50}
+	}]`)
+	jobs, err := NewConfigs(testCfg, noop)
+	if err != nil {
+		t.Fatal(err)
+	}
+	limits := jobs[0].exec.Limits
+	assert := assert.New(t)
+	assert.Equal(uint64(1024), *limits.NoFile, "config for limits.NoFile")
+	assert.Nil(limits.NProc, "config for limits.NProc")
+	assert.Equal(uint64(0), *limits.Core, "config for limits.Core")
+	assert.Equal("128M", limits.Memory, "config for limits.Memory")
+	assert.Equal(50, limits.CPUWeight, "config for limits.CPUWeight")
+	assert.Equal("myName", limits.Cgroup, "config for limits.Cgroup")
+
+	expectErr := func(test, errMsg string) {
+		_, err := NewConfigs(tests.DecodeRawToSlice(test), noop)
+		assert.EqualError(err, errMsg)
+	}
+	expectErr(`[{name: "myName", exec: "/bin/app", limits: {cpuWeight: 20000}}]`,
+		"jobs[0].limits: cpuWeight '20000' must be between 1 and 10000")
+	expectErr(`[{exec: "/bin/app", limits: {memory: "1G"}}]`,
+		"jobs[0].name: must not be blank\n"+
+			"jobs[0].limits: memory and cpuWeight require a job name without '/'")
+}
+
+func TestJobConfigValidationErrors(t *testing.T) {
+	testCfg := tests.DecodeRawToSlice(`[
+	{name: "web", exec: "/bin/web", port: 80, health: {exec: "/bin/check", interval: 1}},
+	{name: "B", exec: "/bin/b", timeout: "xx", restarts: "sometimes"},
+	{name: "C", exec: "/bin/c", unknown: true}
+	]`)
+	_, err := NewConfigs(testCfg, noop)
+	errs, ok := err.(validation.Errors)
+	if !ok {
+		t.Fatalf("expected validation.Errors but got %T: %v", err, err)
+	}
+	assert.Equal(t, []string{
+		"jobs[0].health.ttl: must be > 0",
+		"jobs[1].restarts: 'sometimes' is invalid: accepts positive integers, \"unlimited\", or \"never\"",
+		"jobs[1].timeout: unable to parse 'xx': time: invalid duration \"xx\"",
+		"jobs[2].unknown: is not a known config key",
+	}, errorStrings(errs))
 }
 
 // ---------------------------------------------------------------------
 // helpers
 
+func errorStrings(errs validation.Errors) []string {
+	msgs := make([]string, len(errs))
+	for i, err := range errs {
+		msgs[i] = err.Error()
+	}
+	return msgs
+}
+
 func loadTestConfig(t *testing.T) []*Config {
 	data, _ := ioutil.ReadFile(fmt.Sprintf("./testdata/%s.json5", t.Name()))
 	testCfg := tests.DecodeRawToSlice(string(data))
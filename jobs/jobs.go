@@ -4,7 +4,9 @@ package jobs
 import (
 	"context"
 	"fmt"
+	"strings"
 	"sync"
+	"syscall"
 	"time"
 
 	"github.com/asokolov365/containerpilot/commands"
@@ -25,15 +27,17 @@ const (
 
 // Job manages the state of a job and its start/stop conditions
 type Job struct {
-	Name string
-	exec *commands.Command
+	Name   string
+	exec   *commands.Command
+	limits *commands.Limits
 
 	// service health and discovery
-	Status          JobStatus
-	statusLock      *sync.RWMutex
-	Service         *discovery.ServiceDefinition
-	healthCheckExec *commands.Command
-	healthCheckName string
+	Status           JobStatus
+	statusLock       *sync.RWMutex
+	Services         []*discovery.ServiceDefinition
+	healthCheckExec  *commands.Command
+	healthCheckName  string
+	warningExitCodes []int
 
 	// starting events
 	startEvent        events.Event
@@ -54,6 +58,7 @@ type Job struct {
 	// completed
 	IsComplete   bool
 	completeLock *sync.RWMutex
+	stopped      chan struct{}
 
 	events.Subscriber
 	events.Publisher
@@ -64,9 +69,11 @@ func NewJob(cfg *Config) *Job {
 	job := &Job{
 		Name:              cfg.Name,
 		exec:              cfg.exec,
+		limits:            cfg.limits,
 		heartbeat:         cfg.heartbeatInterval,
-		Service:           cfg.serviceDefinition,
+		Services:          cfg.serviceDefinitions,
 		healthCheckExec:   cfg.healthCheckExec,
+		warningExitCodes:  cfg.warningExitCodes,
 		startEvent:        cfg.whenEvent,
 		startTimeout:      cfg.whenTimeout,
 		startsRemain:      cfg.whenStartsLimit,
@@ -78,6 +85,7 @@ func NewJob(cfg *Config) *Job {
 	}
 	job.statusLock = &sync.RWMutex{}
 	job.completeLock = &sync.RWMutex{}
+	job.stopped = make(chan struct{})
 	job.Rx = make(chan events.Event, eventBufferSize)
 	if job.Name == "containerpilot" {
 		// right now this hardcodes the telemetry service to
@@ -98,20 +106,33 @@ func FromConfigs(cfgs []*Config) []*Job {
 	return jobs
 }
 
-// SendHeartbeat sends a heartbeat for this Job's service
+// SendHeartbeat sends a heartbeat for each of this Job's services
 func (job *Job) SendHeartbeat() {
-	if job.Service != nil {
-		job.Service.SendHeartbeat()
+	for _, service := range job.Services {
+		service.SendHeartbeat()
 	}
 }
 
-// checkRegistration registers this Job's service if it isn't already registered.
+// checkRegistration registers this Job's services if they aren't already registered.
 func (job *Job) checkRegistration() {
-	if job.Service != nil && job.Service.InitialStatus != "" {
-		job.Service.RegisterWithInitialStatus()
+	for _, service := range job.Services {
+		if service.InitialStatus != "" {
+			service.RegisterWithInitialStatus()
+		}
 	}
 }
 
+// ResourceUsage returns the resource usage of the Job's cgroup, or nil
+// if the Job doesn't have memory or CPU limits
+func (job *Job) ResourceUsage() (*commands.Usage, error) {
+	return job.limits.Usage()
+}
+
+// HasHealthCheck returns true if the Job has a health check
+func (job *Job) HasHealthCheck() bool {
+	return job.healthCheckExec != nil
+}
+
 // GetStatus returns the current health status of the Job
 func (job *Job) GetStatus() JobStatus {
 	job.statusLock.RLock()
@@ -140,6 +161,56 @@ func (job *Job) Kill() {
 	}
 }
 
+// Signal forwards the signal to the Job's executable, if any
+func (job *Job) Signal(sig syscall.Signal) {
+	if job.exec != nil {
+		job.exec.Signal(sig)
+	}
+}
+
+// Quit asks the Job to halt as it would on a global shutdown. "pre-stop"
+// and "post-stop" style Jobs keep running until their exec has run once.
+func (job *Job) Quit() {
+	job.Receive(events.Event{Code: events.Quit, Source: job.Name})
+}
+
+// Stop asks the Job to halt immediately, even if it's a "pre-stop" or
+// "post-stop" style Job that is waiting on another Job's events.
+func (job *Job) Stop() {
+	job.Receive(events.Event{Code: events.Quit, Source: job.Name + ".stop"})
+}
+
+// Done returns a channel that's closed when the Job has halted and
+// finished its cleanup
+func (job *Job) Done() <-chan struct{} {
+	return job.stopped
+}
+
+// WaitForProcesses blocks until the Job's exec and health check processes
+// have exited, so that their exit events can't reach a new Job that
+// replaces this one with the same name
+func (job *Job) WaitForProcesses() {
+	job.exec.Wait()
+	job.healthCheckExec.Wait()
+}
+
+// RemoveCgroup removes the cgroup for the Job's resource limits, if any.
+// It must be called after its processes have exited, as when the Job has
+// been removed by a reload.
+func (job *Job) RemoveCgroup() {
+	if job.exec == nil {
+		return
+	}
+	if err := job.exec.Limits.RemoveCgroup(); err != nil {
+		log.Warnf("job %s: %v", job.Name, err)
+	}
+}
+
+// InMaintenance returns true if the Job is in maintenance mode
+func (job *Job) InMaintenance() bool {
+	return job.GetStatus() == statusMaintenance
+}
+
 // Run executes the event loop for the Job
 func (job *Job) Run(pctx context.Context, completedCh chan struct{}) {
 	ctx, cancel := context.WithCancel(pctx)
@@ -188,6 +259,7 @@ func (job *Job) processEvent(ctx context.Context, event events.Event) processEve
 	runEverySource := fmt.Sprintf("%s.run-every", job.Name)
 	heartbeatSource := fmt.Sprintf("%s.heartbeat", job.Name)
 	healthCheckName := fmt.Sprintf("check.%s", job.Name)
+	stopSource := fmt.Sprintf("%s.stop", job.Name)
 	if job.healthCheckExec != nil {
 		healthCheckName = job.healthCheckExec.Name
 	}
@@ -213,11 +285,16 @@ func (job *Job) processEvent(ctx context.Context, event events.Event) processEve
 		events.GlobalShutdown:
 		return job.onQuit(ctx)
 
-	case events.GlobalEnterMaintenance:
-		return job.onEnterMaintenance(ctx)
+	case events.Event{Code: events.Quit, Source: stopSource}:
+		return job.onStop(ctx)
 
-	case events.GlobalExitMaintenance:
-		return job.onExitMaintenance(ctx)
+	case events.GlobalEnterMaintenance,
+		events.Event{Code: events.EnterMaintenance, Source: job.Name}:
+		return job.onEnterMaintenance(ctx, event)
+
+	case events.GlobalExitMaintenance,
+		events.Event{Code: events.ExitMaintenance, Source: job.Name}:
+		return job.onExitMaintenance(ctx, event)
 
 	case events.Event{Code: events.ExitSuccess, Source: job.Name},
 		events.Event{Code: events.ExitFailed, Source: job.Name}:
@@ -227,6 +304,9 @@ func (job *Job) processEvent(ctx context.Context, event events.Event) processEve
 		events.Event{Code: events.Signal, Source: "SIGUSR2"}:
 		return job.onSignalEvent(ctx, event.Source)
 
+	case events.Event{Code: events.Trigger, Source: job.Name}:
+		return job.onTriggerEvent(ctx)
+
 	case job.startEvent:
 		return job.onStartEvent(ctx)
 	}
@@ -247,7 +327,7 @@ func (job *Job) onHeartbeatTimerExpired(ctx context.Context) processEventStatus
 	if status != statusMaintenance && status != statusIdle {
 		if job.healthCheckExec != nil {
 			job.healthCheckExec.Run(ctx, job.Publisher.Bus)
-		} else if job.Service != nil {
+		} else if len(job.Services) > 0 {
 			// this is the case for non-checked but advertised
 			// services like the telemetry endpoint
 			job.SendHeartbeat()
@@ -276,22 +356,83 @@ func (job *Job) onRunEveryTimerExpired(ctx context.Context) processEventStatus {
 }
 
 func (job *Job) onHealthCheckFailed(ctx context.Context) processEventStatus {
-	if job.GetStatus() != statusMaintenance {
-		job.setStatus(statusUnhealthy)
-		job.Publish(events.Event{Code: events.StatusUnhealthy, Source: job.Name})
+	job.recordHealthCheck()
+	if job.GetStatus() == statusMaintenance {
+		return jobContinue
+	}
+	if job.isHealthCheckWarning() {
+		// the job is degraded but still serving, so it stays healthy
+		// for other jobs while Consul shows the warning and its reason
+		job.setStatus(statusHealthy)
+		job.Publish(events.Event{Code: events.StatusHealthy, Source: job.Name})
+		job.sendStatus(discovery.StatusWarning)
+		return jobContinue
 	}
+	job.setStatus(statusUnhealthy)
+	job.Publish(events.Event{Code: events.StatusUnhealthy, Source: job.Name})
+	// mark the service critical right away rather than waiting for its
+	// TTL to lapse, so that Consul stops sending it traffic
+	job.sendStatus(discovery.StatusCritical)
 	return jobContinue
 }
 
 func (job *Job) onHealthCheckPassed(ctx context.Context) processEventStatus {
+	job.recordHealthCheck()
 	if job.GetStatus() != statusMaintenance {
 		job.setStatus(statusHealthy)
 		job.Publish(events.Event{Code: events.StatusHealthy, Source: job.Name})
-		job.SendHeartbeat()
+		job.sendStatus(discovery.StatusPassing)
 	}
 	return jobContinue
 }
 
+// isHealthCheckWarning returns true if the last health check exited with
+// one of the exit codes configured as a warning
+func (job *Job) isHealthCheckWarning() bool {
+	if job.healthCheckExec == nil {
+		return false
+	}
+	exitCode := job.healthCheckExec.State().ExitCode
+	for _, code := range job.warningExitCodes {
+		if code == exitCode {
+			return true
+		}
+	}
+	return false
+}
+
+// sendStatus sends the status of each of this Job's services along with
+// the output of the last health check
+func (job *Job) sendStatus(status string) {
+	if len(job.Services) == 0 {
+		return
+	}
+	output := job.healthCheckOutput()
+	for _, service := range job.Services {
+		service.SendStatus(status, output)
+	}
+}
+
+// healthCheckOutput returns the stdout of the last health check, or
+// describes its result if it didn't write anything
+func (job *Job) healthCheckOutput() string {
+	if job.healthCheckExec == nil {
+		return "ok"
+	}
+	state := job.healthCheckExec.State()
+	switch {
+	case strings.TrimSpace(state.Output) != "":
+		return state.Output
+	case state.ExitCode == 0:
+		return "ok"
+	case state.ExitCode == -1:
+		return fmt.Sprintf("%s was killed", job.healthCheckExec.Name)
+	default:
+		return fmt.Sprintf("%s exited with status %d",
+			job.healthCheckExec.Name, state.ExitCode)
+	}
+}
+
 func (job *Job) onQuit(ctx context.Context) processEventStatus {
 	job.restartsRemain = 0 // no more restarts
 	if (job.startEvent.Code == events.Stopping ||
@@ -311,20 +452,34 @@ func (job *Job) onQuit(ctx context.Context) processEventStatus {
 	return jobHalt
 }
 
-func (job *Job) onEnterMaintenance(ctx context.Context) processEventStatus {
+// onStop halts the Job without waiting for any further start events, as
+// when the Job has been removed or changed by a config reload
+func (job *Job) onStop(ctx context.Context) processEventStatus {
+	job.restartsRemain = 0
+	job.startsRemain = 0
+	job.startEvent = events.NonEvent
+	return jobHalt
+}
+
+// onEnterMaintenance handles both the global maintenance mode and the
+// maintenance mode of this Job alone
+func (job *Job) onEnterMaintenance(ctx context.Context, event events.Event) processEventStatus {
 	job.setStatus(statusMaintenance)
-	if job.Service != nil {
-		job.Service.MarkForMaintenance()
+	for _, service := range job.Services {
+		service.MarkForMaintenance()
 	}
-	if job.startEvent == events.GlobalEnterMaintenance {
+	if job.startEvent == event {
 		return job.onStartEvent(ctx)
 	}
 	return jobContinue
 }
 
-func (job *Job) onExitMaintenance(ctx context.Context) processEventStatus {
+func (job *Job) onExitMaintenance(ctx context.Context, event events.Event) processEventStatus {
 	job.setStatus(statusUnknown)
-	if job.startEvent == events.GlobalExitMaintenance {
+	for _, service := range job.Services {
+		service.ExitMaintenance()
+	}
+	if job.startEvent == event {
 		return job.onStartEvent(ctx)
 	}
 	return jobContinue
@@ -336,6 +491,7 @@ func (job *Job) onExecExit(ctx context.Context) processEventStatus {
 	}
 	if job.restartPermitted() {
 		job.restartsRemain--
+		job.recordRestart()
 		job.startJobExec(ctx)
 		return jobContinue
 	}
@@ -356,6 +512,21 @@ func (job *Job) onSignalEvent(ctx context.Context, sig string) processEventStatu
 	return jobContinue
 }
 
+// onTriggerEvent starts the Job's exec when a signal has been mapped
+// to trigger this Job directly, regardless of its 'when' config, unless
+// the Job is in maintenance or its exec is already running
+func (job *Job) onTriggerEvent(ctx context.Context) processEventStatus {
+	switch {
+	case job.GetStatus() == statusMaintenance:
+		log.Debugf("job %s is in maintenance, ignoring trigger", job.Name)
+	case job.exec.IsRunning():
+		log.Debugf("job %s is already running, ignoring trigger", job.Name)
+	default:
+		job.startJobExec(ctx)
+	}
+	return jobContinue
+}
+
 func (job *Job) onStartEvent(ctx context.Context) processEventStatus {
 	if job.startsRemain == 0 {
 		job.startEvent = events.NonEvent
@@ -406,13 +577,14 @@ func (job *Job) cleanup(ctx context.Context, cancel context.CancelFunc) {
 		}
 	}
 	cancel()
-	if job.Service != nil {
-		job.Service.Deregister() // deregister from Consul
+	for _, service := range job.Services {
+		service.Deregister() // deregister from Consul
 	}
 	job.Unsubscribe() // deregister from events
 	job.Unregister()
 	job.setComplete()
 	job.Publish(events.Event{Code: events.Stopped, Source: job.Name})
+	close(job.stopped)
 }
 
 // String implements the stdlib fmt.Stringer interface for pretty-printing
@@ -7,9 +7,12 @@ import (
 	"testing"
 	"time"
 
+	"github.com/prometheus/client_golang/prometheus/testutil"
 	"github.com/stretchr/testify/assert"
 
+	"github.com/asokolov365/containerpilot/discovery"
 	"github.com/asokolov365/containerpilot/events"
+	"github.com/asokolov365/containerpilot/tests/mocks"
 )
 
 func TestJobRunSafeClose(t *testing.T) {
@@ -42,6 +45,11 @@ func TestJobRunSafeClose(t *testing.T) {
 		{Code: events.Stopping, Source: "myjob"},
 		{Code: events.Stopped, Source: "myjob"},
 	}
+	// if the exec started before we cancelled, it's terminated and its
+	// exit events may follow
+	if len(results) > len(expected) {
+		results = results[:len(expected)]
+	}
 	if !reflect.DeepEqual(expected, results) {
 		t.Fatalf("expected: %v\ngot: %v", expected, results)
 	}
@@ -155,6 +163,11 @@ func TestJobRunRestarts(t *testing.T) {
 		if got != expected {
 			t.Fatalf("expected %d restarts but got %d\n%v", expected, got, results)
 		}
+		metric := testutil.ToFloat64(restartsCollector.WithLabelValues("myjob"))
+		restartsCollector.Reset()
+		if int(metric) != expected-1 {
+			t.Fatalf("expected %d restarts metric but got %v", expected-1, metric)
+		}
 	}
 	runRestartsTest(3, 4)
 	runRestartsTest("1", 2)
@@ -260,6 +273,34 @@ func TestJobMaintenance(t *testing.T) {
 			"job status after exiting maintenance")
 	})
 
+	t.Run("enter job maintenance", func(t *testing.T) {
+		status := testFunc(t, statusUnknown,
+			events.Event{Code: events.EnterMaintenance, Source: "myjob"})
+		assert.Equal(t, statusMaintenance, status,
+			"job status after entering maintenance mode for the job")
+	})
+
+	t.Run("other job maintenance", func(t *testing.T) {
+		status := testFunc(t, statusHealthy,
+			events.Event{Code: events.EnterMaintenance, Source: "otherjob"})
+		assert.Equal(t, statusHealthy, status,
+			"job status after entering maintenance mode for another job")
+	})
+
+	t.Run("exit job maintenance", func(t *testing.T) {
+		status := testFunc(t, statusMaintenance,
+			events.Event{Code: events.ExitMaintenance, Source: "myjob"})
+		assert.Equal(t, statusUnknown, status,
+			"job status after exiting maintenance mode for the job")
+	})
+
+	t.Run("trigger ignored", func(t *testing.T) {
+		status := testFunc(t, statusMaintenance,
+			events.Event{Code: events.Trigger, Source: "myjob"})
+		assert.Equal(t, statusMaintenance, status,
+			"job status after a signal trigger while in maintenance")
+	})
+
 	t.Run("now healthy", func(t *testing.T) {
 		status := testFunc(t, statusUnknown,
 			events.Event{Code: events.ExitSuccess, Source: "check.myjob"})
@@ -268,6 +309,87 @@ func TestJobMaintenance(t *testing.T) {
 	})
 }
 
+func TestJobHealthCheckStatus(t *testing.T) {
+	testFunc := func(t *testing.T, check interface{}) (JobStatus, []string) {
+		backend := &ttlBackend{}
+		cfg := &Config{
+			Name: "myjob",
+			Exec: "true",
+			Port: 80,
+			Health: &HealthConfig{
+				CheckExec:        check,
+				CheckTimeout:     "500ms",
+				Heartbeat:        10,
+				TTL:              50,
+				WarningExitCodes: []int{1},
+			},
+		}
+		if err := cfg.Validate(backend); err != nil {
+			t.Fatalf("unexpected error in Validate: %v", err)
+		}
+		job := NewJob(cfg)
+		bus := events.NewEventBus()
+		job.Register(bus)
+		job.healthCheckExec.Run(context.Background(), bus)
+		job.healthCheckExec.Wait()
+
+		event := events.Event{Code: events.ExitSuccess, Source: "check.myjob"}
+		if job.healthCheckExec.State().ExitCode != 0 {
+			event.Code = events.ExitFailed
+		}
+		job.processEvent(context.Background(), event)
+		return job.GetStatus(), backend.updates
+	}
+
+	t.Run("passing", func(t *testing.T) {
+		status, updates := testFunc(t, "echo all good")
+		assert.Equal(t, statusHealthy, status)
+		assert.Equal(t, []string{"passing: all good\n"}, updates)
+	})
+
+	t.Run("passing without output", func(t *testing.T) {
+		status, updates := testFunc(t, "true")
+		assert.Equal(t, statusHealthy, status)
+		assert.Equal(t, []string{"passing: ok"}, updates)
+	})
+
+	t.Run("warning", func(t *testing.T) {
+		status, updates := testFunc(t,
+			[]interface{}{"sh", "-c", "echo disk 91% full; exit 1"})
+		assert.Equal(t, statusHealthy, status)
+		assert.Equal(t, []string{"warning: disk 91% full\n"}, updates)
+	})
+
+	t.Run("warning without output", func(t *testing.T) {
+		_, updates := testFunc(t, "false")
+		assert.Equal(t, []string{
+			"warning: check.myjob exited with status 1"}, updates)
+	})
+
+	t.Run("critical", func(t *testing.T) {
+		status, updates := testFunc(t,
+			[]interface{}{"sh", "-c", "echo down; exit 2"})
+		assert.Equal(t, statusUnhealthy, status)
+		assert.Equal(t, []string{"critical: down\n"}, updates)
+	})
+
+	t.Run("critical timeout", func(t *testing.T) {
+		_, updates := testFunc(t, []interface{}{"sleep", "10"})
+		assert.Equal(t, []string{"critical: check.myjob was killed"}, updates)
+	})
+}
+
+// ttlBackend records the status and output of each TTL check update
+type ttlBackend struct {
+	mocks.NoopDiscoveryBackend
+	updates []string
+}
+
+func (b *ttlBackend) UpdateServiceStatus(service *discovery.ServiceDefinition, status, output string) error {
+	b.updates = append(b.updates, status+": "+output)
+	return nil
+}
+
 func TestJobProcessEvent(t *testing.T) {
 
 	t.Run("start once with no restarts", func(t *testing.T) {
@@ -0,0 +1,53 @@
+package jobs
+
+import (
+	"github.com/asokolov365/containerpilot/commands"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	restartsCollector    *prometheus.CounterVec
+	healthCheckCollector *prometheus.HistogramVec
+)
+
+func init() {
+	restartsCollector = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "containerpilot_job_restarts_total",
+		Help: "count of job restarts after the job's process exited, partitioned by job",
+	}, []string{"job"})
+	healthCheckCollector = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name: "containerpilot_job_health_check_duration_seconds",
+		Help: "duration of job health checks, partitioned by job",
+	}, []string{"job"})
+	prometheus.MustRegister(restartsCollector, healthCheckCollector)
+}
+
+// ProcessState returns a snapshot of the state of the Job's most recent
+// process, and false if the Job doesn't have an exec
+func (job *Job) ProcessState() (commands.ProcessState, bool) {
+	if job.exec == nil {
+		return commands.ProcessState{}, false
+	}
+	return job.exec.State(), true
+}
+
+// ProcessUsage returns the resource usage of the process group of the
+// Job's running process, or nil if it isn't running
+func (job *Job) ProcessUsage() (*commands.ProcessUsage, error) {
+	if job.exec == nil {
+		return nil, nil
+	}
+	return job.exec.ProcessGroupUsage()
+}
+
+func (job *Job) recordRestart() {
+	restartsCollector.WithLabelValues(job.Name).Inc()
+}
+
+func (job *Job) recordHealthCheck() {
+	if job.healthCheckExec == nil {
+		return
+	}
+	duration := job.healthCheckExec.State().Duration
+	healthCheckCollector.WithLabelValues(job.Name).Observe(duration.Seconds())
+}
@@ -7,6 +7,7 @@ import (
 	"os"
 	"runtime"
 
+	"github.com/asokolov365/containerpilot/commands"
 	"github.com/asokolov365/containerpilot/core"
 	"github.com/asokolov365/containerpilot/sup"
 	log "github.com/sirupsen/logrus"
@@ -14,6 +15,10 @@ import (
 
 // Main executes the containerpilot CLI
 func main() {
+	// ContainerPilot runs itself to set the rlimits of a job's process
+	// before exec'ing it, in which case this never returns
+	commands.ExecWithRlimits(os.Args)
+
 	// make sure we use only a single CPU so as not to cause
 	// contention on the main application
 	runtime.GOMAXPROCS(1)
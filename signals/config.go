@@ -0,0 +1,106 @@
+package signals
+
+import (
+	"syscall"
+
+	"github.com/asokolov365/containerpilot/config/decode"
+	"github.com/asokolov365/containerpilot/config/validation"
+)
+
+// Action is an enum of the things ContainerPilot can do on receiving
+// a signal
+type Action string
+
+// Action enum
+const (
+	Forward     Action = "forward"     // send the signal to a job's process group
+	Trigger     Action = "trigger"     // start a job's exec
+	Maintenance Action = "maintenance" // toggle maintenance mode
+	Reload      Action = "reload"      // reload the ContainerPilot config
+)
+
+// Config maps a signal to the action ContainerPilot takes when it
+// receives that signal
+type Config struct {
+	Name   string `mapstructure:"signal"`
+	Action Action `mapstructure:"action"`
+	Job    string `mapstructure:"job"`
+
+	signal syscall.Signal
+}
+
+// NewConfigs parses json config into a validated slice of Configs. The
+// jobNames are the names of all configured jobs, so that we can ensure
+// that forward and trigger actions refer to a real job. All the problems
+// found are returned together as validation.Errors, at paths like
+// `signals[0].job`.
+func NewConfigs(raw []interface{}, jobNames []string) ([]*Config, error) {
+	var signals []*Config
+	if raw == nil {
+		return signals, nil
+	}
+	var errs validation.Errors
+	for i, rawSignal := range raw {
+		path := validation.Index("signals", i)
+		sig := &Config{}
+		if err := decode.ToStruct(rawSignal, sig); err != nil {
+			errs = append(errs, validation.FromDecode(path, err)...)
+			continue
+		}
+		errs.Add(path, sig.Validate(jobNames))
+		signals = append(signals, sig)
+	}
+	if len(errs) > 0 {
+		return nil, errs
+	}
+	return signals, nil
+}
+
+// Validate ensures that a Config meets all constraints. It returns
+// validation.Errors with every problem found, at paths relative to the
+// signal.
+func (cfg *Config) Validate(jobNames []string) error {
+	var errs validation.Errors
+	sig, ok := FromString(cfg.Name)
+	if !ok {
+		errs.Addf("signal", "'%s' is not a supported signal", cfg.Name)
+	}
+	cfg.signal = sig
+
+	switch cfg.Action {
+	case Forward, Trigger:
+		if cfg.Job == "" {
+			errs.Addf("job", "must be set for action '%s'", cfg.Action)
+		} else if !contains(jobNames, cfg.Job) {
+			errs.Addf("job", "'%s' is not a configured job", cfg.Job)
+		}
+	case Maintenance, Reload:
+		if cfg.Job != "" {
+			errs.Addf("job", "may not be set for action '%s'", cfg.Action)
+		}
+	default:
+		errs.Addf("action",
+			"must be one of 'forward', 'trigger', 'maintenance', or 'reload' but got '%s'",
+			cfg.Action)
+	}
+	return errs.ErrorOrNil()
+}
+
+func contains(names []string, name string) bool {
+	for _, n := range names {
+		if n == name {
+			return true
+		}
+	}
+	return false
+}
+
+// Signal returns the validated signal for this Config
+func (cfg *Config) Signal() syscall.Signal {
+	return cfg.signal
+}
+
+// String implements the stdlib fmt.Stringer interface for pretty-printing
+func (cfg *Config) String() string {
+	return "signals.Config[" + cfg.Name + "]"
+}
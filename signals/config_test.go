@@ -0,0 +1,60 @@
+package signals
+
+import (
+	"syscall"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/asokolov365/containerpilot/tests"
+)
+
+func TestSignalsParse(t *testing.T) {
+	testCfg := tests.DecodeRawToSlice(`[
+	{signal: "SIGHUP", action: "forward", job: "nginx"},
+	{signal: "SIGUSR1", action: "trigger", job: "dump"},
+	{signal: "SIGWINCH", action: "maintenance"},
+	{signal: "SIGQUIT", action: "reload"}]`)
+	signals, err := NewConfigs(testCfg, []string{"nginx", "dump"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert := assert.New(t)
+	assert.Equal(4, len(signals), "number of signals")
+	assert.Equal(Forward, signals[0].Action, "config for signal[0].Action")
+	assert.Equal("nginx", signals[0].Job, "config for signal[0].Job")
+	assert.Equal(syscall.SIGHUP, signals[0].Signal(), "config for signal[0].Signal()")
+	assert.Equal(Trigger, signals[1].Action, "config for signal[1].Action")
+	assert.Equal(syscall.SIGUSR1, signals[1].Signal(), "config for signal[1].Signal()")
+	assert.Equal(Maintenance, signals[2].Action, "config for signal[2].Action")
+	assert.Equal(syscall.SIGWINCH, signals[2].Signal(), "config for signal[2].Signal()")
+	assert.Equal(Reload, signals[3].Action, "config for signal[3].Action")
+	assert.Equal(syscall.SIGQUIT, signals[3].Signal(), "config for signal[3].Signal()")
+}
+
+func TestSignalsConfigError(t *testing.T) {
+	expectErr := func(test, errMsg string) {
+		testCfg := tests.DecodeRawToSlice(test)
+		_, err := NewConfigs(testCfg, []string{"nginx"})
+		assert.EqualError(t, err, errMsg)
+	}
+	expectErr(`[{signal: "SIGTERM", action: "reload"}]`,
+		"signals[0].signal: 'SIGTERM' is not a supported signal")
+	expectErr(`[{signal: "SIGHUP", action: "forward"}]`,
+		"signals[0].job: must be set for action 'forward'")
+	expectErr(`[{signal: "SIGHUP", action: "trigger", job: "app"}]`,
+		"signals[0].job: 'app' is not a configured job")
+	expectErr(`[{signal: "SIGHUP", action: "reload", job: "nginx"}]`,
+		"signals[0].job: may not be set for action 'reload'")
+	expectErr(`[{signal: "SIGHUP", action: "restart"}]`,
+		"signals[0].action: must be one of 'forward', 'trigger', 'maintenance', or 'reload' but got 'restart'")
+	expectErr(`[{signal: "SIGTERM", action: "forward"}, {signal: "SIGHUP", action: "reload", job: "nginx"}]`,
+		"signals[0].signal: 'SIGTERM' is not a supported signal\n"+
+			"signals[0].job: must be set for action 'forward'\n"+
+			"signals[1].job: may not be set for action 'reload'")
+}
+
+func TestToString(t *testing.T) {
+	assert.Equal(t, "SIGWINCH", ToString(syscall.SIGWINCH))
+	assert.Equal(t, "", ToString(syscall.SIGTERM))
+}
@@ -0,0 +1,48 @@
+// Package signals manages the configuration of the actions ContainerPilot
+// takes when it receives a UNIX signal.
+package signals
+
+import (
+	"os"
+	"syscall"
+)
+
+// supported is the set of signals that can be mapped to actions. SIGINT and
+// SIGTERM are reserved for shutting down ContainerPilot and SIGCHLD is
+// reserved for reaping, so they can't be remapped.
+var supported = map[string]syscall.Signal{
+	"SIGHUP":   syscall.SIGHUP,
+	"SIGQUIT":  syscall.SIGQUIT,
+	"SIGUSR1":  syscall.SIGUSR1,
+	"SIGUSR2":  syscall.SIGUSR2,
+	"SIGALRM":  syscall.SIGALRM,
+	"SIGWINCH": syscall.SIGWINCH,
+	"SIGTTIN":  syscall.SIGTTIN,
+	"SIGTTOU":  syscall.SIGTTOU,
+}
+
+// Supported returns all the signals that can be mapped to actions
+func Supported() []os.Signal {
+	sigs := make([]os.Signal, 0, len(supported))
+	for _, sig := range supported {
+		sigs = append(sigs, sig)
+	}
+	return sigs
+}
+
+// FromString returns the signal for a supported signal name
+func FromString(name string) (syscall.Signal, bool) {
+	sig, ok := supported[name]
+	return sig, ok
+}
+
+// ToString returns the name of a supported signal, or an empty string
+// if the signal isn't one we support
+func ToString(sig os.Signal) string {
+	for name, s := range supported {
+		if s == sig {
+			return name
+		}
+	}
+	return ""
+}
@@ -5,9 +5,11 @@ package subcommands
 import (
 	"encoding/json"
 	"fmt"
+	"os"
 
 	"github.com/asokolov365/containerpilot/client"
 	"github.com/asokolov365/containerpilot/config"
+	"github.com/asokolov365/containerpilot/config/validation"
 )
 
 // Params ...
@@ -18,6 +20,8 @@ type Params struct {
 	ConfigPath      string
 	RenderFlag      string
 	MaintenanceFlag string
+	JobFlag         string
+	TemplateVars    bool
 
 	Metrics map[string]string
 	Env     map[string]string
@@ -35,7 +39,24 @@ func VersionHandler(params Params) error {
 // RenderHandler asks the configuration package to render the
 // configuration to the path provided
 func RenderHandler(params Params) error {
-	return config.RenderConfig(params.ConfigPath, params.RenderFlag)
+	return config.RenderConfig(params.ConfigPath, params.RenderFlag, params.TemplateVars)
+}
+
+// ValidateHandler loads and validates the configuration without running
+// it, and prints every problem it finds
+func ValidateHandler(params Params) error {
+	err := config.ValidateConfig(params.ConfigPath)
+	if errs, ok := err.(validation.Errors); ok {
+		for _, err := range errs {
+			fmt.Fprintln(os.Stderr, err)
+		}
+		return fmt.Errorf("-validate: found %d error(s) in config", len(errs))
+	}
+	if err != nil {
+		return fmt.Errorf("-validate: %v", err)
+	}
+	fmt.Println("config is valid")
+	return nil
 }
 
 // ReloadHandler fires a Reload request through the HTTPClient.
@@ -51,7 +72,7 @@ func ReloadHandler(params Params) error {
 }
 
 // MaintenanceHandler fires either an enable or disable SetMaintenance
-// request through the HTTPClient.
+// request through the HTTPClient, for all the jobs or for a single job.
 func MaintenanceHandler(params Params) error {
 	client, err := initClient(params.ConfigPath)
 	if err != nil {
@@ -61,7 +82,7 @@ func MaintenanceHandler(params Params) error {
 	if params.MaintenanceFlag == "enable" {
 		flag = true
 	}
-	if err := client.SetMaintenance(flag); err != nil {
+	if err := client.SetMaintenance(flag, params.JobFlag); err != nil {
 		return fmt.Errorf("-maintenance: failed to run subcommand: %v", err)
 	}
 	return nil
@@ -27,30 +27,27 @@ func Run() {
 	proc.Wait()
 }
 
-// passThroughSignals listens for signals used to gracefully shutdown and
-// passes them thru to the ContainerPilot worker process.
+// passThroughSignals listens for signals used to gracefully shutdown,
+// reload, or run signal actions and passes them thru to the ContainerPilot
+// worker process.
 func passThroughSignals(pid int) {
 	sigRecv := make(chan os.Signal, 1)
 	signal.Notify(sigRecv,
 		syscall.SIGINT,
 		syscall.SIGTERM,
 		syscall.SIGHUP,
+		syscall.SIGQUIT,
 		syscall.SIGUSR1,
 		syscall.SIGUSR2,
+		syscall.SIGALRM,
+		syscall.SIGWINCH,
+		syscall.SIGTTIN,
+		syscall.SIGTTOU,
 	)
 	go func() {
 		for sig := range sigRecv {
-			switch sig {
-			case syscall.SIGINT:
-				syscall.Kill(pid, syscall.SIGINT)
-			case syscall.SIGTERM:
-				syscall.Kill(pid, syscall.SIGTERM)
-			case syscall.SIGHUP:
-				syscall.Kill(pid, syscall.SIGHUP)
-			case syscall.SIGUSR1:
-				syscall.Kill(pid, syscall.SIGUSR1)
-			case syscall.SIGUSR2:
-				syscall.Kill(pid, syscall.SIGUSR2)
+			if s, ok := sig.(syscall.Signal); ok {
+				syscall.Kill(pid, s)
 			}
 		}
 	}()
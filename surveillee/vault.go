@@ -57,6 +57,12 @@ func NewVault(config interface{}) (*Vault, error) {
 	return vault, nil
 }
 
+// Ping checks that the Vault server is reachable
+func (v *Vault) Ping() error {
+	_, err := v.Sys().Health()
+	return err
+}
+
 // CheckForUpstreamChanges requests the set of healthy instances of a
 // service from Consul and checks whether there has been a change since
 // the last check.
@@ -0,0 +1,127 @@
+package telemetry
+
+import (
+	"time"
+
+	"github.com/asokolov365/containerpilot/jobs"
+	"github.com/prometheus/client_golang/prometheus"
+	log "github.com/sirupsen/logrus"
+)
+
+// jobsCollector is a prometheus.Collector that reports the state and
+// resource usage of the jobs monitored by the telemetry server when it's
+// scraped
+type jobsCollector struct {
+	jobs []*jobs.Job
+
+	exitCode *prometheus.Desc
+	uptime   *prometheus.Desc
+	cpu      *prometheus.Desc
+	rss      *prometheus.Desc
+	fds      *prometheus.Desc
+
+	cgroupMemory *prometheus.Desc
+	cgroupCPU    *prometheus.Desc
+}
+
+func newJobsCollector(jobs []*jobs.Job) *jobsCollector {
+	desc := func(name, help string) *prometheus.Desc {
+		return prometheus.NewDesc(name, help, []string{"job"}, nil)
+	}
+	return &jobsCollector{
+		jobs: jobs,
+		exitCode: desc("containerpilot_job_last_exit_code",
+			"exit code of the job's last process, or -1 if it was killed by a signal, partitioned by job"),
+		uptime: desc("containerpilot_job_uptime_seconds",
+			"time since the job's running process started, partitioned by job"),
+		cpu: desc("containerpilot_job_cpu_seconds",
+			"CPU time used by the processes currently in the job's running process group, partitioned by job"),
+		rss: desc("containerpilot_job_resident_memory_bytes",
+			"resident memory of the job's running process group, partitioned by job"),
+		fds: desc("containerpilot_job_open_fds",
+			"open file descriptors of the job's running process group, partitioned by job"),
+		cgroupMemory: desc("containerpilot_job_cgroup_memory_bytes",
+			"current memory usage of the job's cgroup, partitioned by job"),
+		cgroupCPU: desc("containerpilot_job_cgroup_cpu_seconds_total",
+			"total CPU time used by the job's cgroup, partitioned by job"),
+	}
+}
+
+// Describe implements prometheus.Collector
+func (c *jobsCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.exitCode
+	ch <- c.uptime
+	ch <- c.cpu
+	ch <- c.rss
+	ch <- c.fds
+	ch <- c.cgroupMemory
+	ch <- c.cgroupCPU
+}
+
+// Collect implements prometheus.Collector
+func (c *jobsCollector) Collect(ch chan<- prometheus.Metric) {
+	for _, job := range c.jobs {
+		c.collectProcess(ch, job)
+		c.collectCgroup(ch, job)
+	}
+}
+
+func (c *jobsCollector) collectProcess(ch chan<- prometheus.Metric, job *jobs.Job) {
+	state, ok := job.ProcessState()
+	if !ok {
+		return // job doesn't have an exec
+	}
+	if state.Exited {
+		ch <- prometheus.MustNewConstMetric(c.exitCode, prometheus.GaugeValue,
+			float64(state.ExitCode), job.Name)
+	}
+	var uptime float64
+	if state.Pid != 0 {
+		uptime = time.Since(state.StartTime).Seconds()
+	}
+	ch <- prometheus.MustNewConstMetric(c.uptime, prometheus.GaugeValue,
+		uptime, job.Name)
+
+	usage, err := job.ProcessUsage()
+	if err != nil {
+		log.Debugf("telemetry: unable to get process usage for %s: %v",
+			job.Name, err)
+		return
+	}
+	if usage == nil {
+		return // job isn't running
+	}
+	// this drops when a process in the group exits, so it isn't a counter
+	ch <- prometheus.MustNewConstMetric(c.cpu, prometheus.GaugeValue,
+		usage.CPUSeconds, job.Name)
+	ch <- prometheus.MustNewConstMetric(c.rss, prometheus.GaugeValue,
+		float64(usage.RSSBytes), job.Name)
+	ch <- prometheus.MustNewConstMetric(c.fds, prometheus.GaugeValue,
+		float64(usage.OpenFDs), job.Name)
+}
+
+func (c *jobsCollector) collectCgroup(ch chan<- prometheus.Metric, job *jobs.Job) {
+	usage, err := job.ResourceUsage()
+	if err != nil {
+		log.Debugf("telemetry: unable to get resource usage for %s: %v",
+			job.Name, err)
+		return
+	}
+	if usage == nil {
+		return // job doesn't have a cgroup
+	}
+	ch <- prometheus.MustNewConstMetric(c.cgroupMemory, prometheus.GaugeValue,
+		float64(usage.MemoryBytes), job.Name)
+	ch <- prometheus.MustNewConstMetric(c.cgroupCPU, prometheus.CounterValue,
+		usage.CPUSeconds, job.Name)
+}
+
+// registerJobsCollector replaces the collector for any previously
+// monitored jobs, so that we can reload config
+func registerJobsCollector(jobs []*jobs.Job) {
+	collector := newJobsCollector(jobs)
+	prometheus.Unregister(collector)
+	if err := prometheus.Register(collector); err != nil {
+		log.Errorf("telemetry: unable to register job metrics: %v", err)
+	}
+}
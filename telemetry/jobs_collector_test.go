@@ -0,0 +1,74 @@
+package telemetry
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/asokolov365/containerpilot/events"
+	"github.com/asokolov365/containerpilot/jobs"
+	"github.com/asokolov365/containerpilot/tests"
+	"github.com/asokolov365/containerpilot/tests/mocks"
+)
+
+func TestJobsCollector(t *testing.T) {
+	cfgs, err := jobs.NewConfigs(tests.DecodeRawToSlice(
+		`[{name: "job1", exec: "sleep 2"}, {name: "job2", exec: "true"}]`),
+		&mocks.NoopDiscoveryBackend{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	testJobs := jobs.FromConfigs(cfgs)
+	bus := events.NewEventBus()
+	ctx, cancel := context.WithCancel(context.Background())
+	completedCh := make(chan struct{}, len(testJobs))
+	for _, job := range testJobs {
+		job.Subscribe(bus)
+		job.Register(bus)
+		job.Run(ctx, completedCh)
+	}
+	bus.Publish(events.GlobalStartup)
+	time.Sleep(200 * time.Millisecond)
+
+	reg := prometheus.NewPedanticRegistry()
+	reg.MustRegister(newJobsCollector(testJobs))
+	families, err := reg.Gather()
+	cancel()
+	bus.Wait()
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := map[string]map[string]float64{}
+	for _, family := range families {
+		for _, metric := range family.GetMetric() {
+			job := metric.GetLabel()[0].GetValue()
+			if got[job] == nil {
+				got[job] = map[string]float64{}
+			}
+			val := metric.GetGauge().GetValue() + metric.GetCounter().GetValue()
+			got[job][family.GetName()] = val
+		}
+	}
+	assert := assert.New(t)
+	// job1 is running
+	assert.True(got["job1"]["containerpilot_job_uptime_seconds"] > 0, "job1 uptime")
+	assert.True(got["job1"]["containerpilot_job_resident_memory_bytes"] > 0, "job1 rss")
+	assert.True(got["job1"]["containerpilot_job_open_fds"] > 0, "job1 fds")
+	assert.NotContains(got["job1"], "containerpilot_job_last_exit_code")
+	// job2 has exited
+	assert.Equal(map[string]float64{
+		"containerpilot_job_uptime_seconds": 0,
+		"containerpilot_job_last_exit_code": 0,
+	}, got["job2"])
+}
+
+func TestJobsCollectorRegister(t *testing.T) {
+	// re-registering after a reload replaces the old collector
+	registerJobsCollector([]*jobs.Job{})
+	registerJobsCollector([]*jobs.Job{})
+	assert.True(t, prometheus.Unregister(newJobsCollector(nil)),
+		"expected collector to be registered")
+}
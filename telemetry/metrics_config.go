@@ -1,10 +1,10 @@
 package telemetry
 
 import (
-	"fmt"
 	"strings"
 
 	"github.com/asokolov365/containerpilot/config/decode"
+	"github.com/asokolov365/containerpilot/config/validation"
 	"github.com/prometheus/client_golang/prometheus"
 )
 
@@ -21,18 +21,23 @@ type MetricConfig struct {
 	collector  prometheus.Collector
 }
 
-// NewMetricConfigs creates new metrics from a raw config
+// NewMetricConfigs creates new metrics from a raw config. All the
+// problems found in any of the metrics are returned together as
+// validation.Errors, at paths like `metrics[0].type`.
 func NewMetricConfigs(raw []interface{}) ([]*MetricConfig, error) {
 	var metrics []*MetricConfig
-	if err := decode.ToStruct(raw, &metrics); err != nil {
-		return nil, fmt.Errorf("MetricConfig configuration error: %v", err)
-	}
-	for _, metric := range metrics {
-		if err := metric.Validate(); err != nil {
-			return metrics, err
+	var errs validation.Errors
+	for i, rawMetric := range raw {
+		path := validation.Index("metrics", i)
+		metric := &MetricConfig{}
+		if err := decode.ToStruct(rawMetric, metric); err != nil {
+			errs = append(errs, validation.FromDecode(path, err)...)
+			continue
 		}
+		errs.Add(path, metric.Validate())
+		metrics = append(metrics, metric)
 	}
-	return metrics, nil
+	return metrics, errs.ErrorOrNil()
 }
 
 // Validate ensures Metric meets all requirements
@@ -77,7 +82,7 @@ func (cfg *MetricConfig) Validate() error {
 			Help:      cfg.Help,
 		})
 	default:
-		return fmt.Errorf("invalid metric type: %s", cfg.Type)
+		return validation.Errorf("type", "invalid metric type: %s", cfg.Type)
 	}
 	// we're going to unregister before every attempt to register
 	// so that we can reload config
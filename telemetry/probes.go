@@ -0,0 +1,133 @@
+package telemetry
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/asokolov365/containerpilot/events"
+	"github.com/asokolov365/containerpilot/jobs"
+)
+
+// how long the event bus can take to accept an event before '/healthz'
+// reports ContainerPilot as unhealthy
+const eventBusTimeout = time.Second
+
+// probeResponse is the body of the '/healthz' and '/readyz' endpoints
+type probeResponse struct {
+	Status string
+	Checks []*probeCheck
+}
+
+type probeCheck struct {
+	Name    string
+	Status  string
+	Passing bool
+}
+
+// HealthHandler implements http.Handler for the '/healthz' endpoint,
+// which checks that ContainerPilot's event loop is responsive
+type HealthHandler struct {
+	telem *Telemetry
+}
+
+// NewHealthHandler constructs a HealthHandler with a pointer
+// to the Telemetry server
+func NewHealthHandler(t *Telemetry) HealthHandler {
+	return HealthHandler{telem: t}
+}
+
+// ServeHTTP implements http.Handler for HealthHandler
+func (hh HealthHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		failedStatus := http.StatusMethodNotAllowed
+		http.Error(w, http.StatusText(failedStatus), failedStatus)
+		return
+	}
+	check := &probeCheck{Name: "events", Status: "responsive", Passing: true}
+	bus := hh.telem.eventBus()
+	switch {
+	case bus == nil:
+		check.Status, check.Passing = "stopped", false
+	case !bus.Responsive(eventBusTimeout):
+		check.Status, check.Passing = "unresponsive", false
+	}
+	writeProbeResponse(w, "healthy", "unhealthy", []*probeCheck{check})
+}
+
+// ReadyHandler implements http.Handler for the '/readyz' endpoint, which
+// checks that the jobs configured by 'ready' are healthy
+type ReadyHandler struct {
+	telem *Telemetry
+}
+
+// NewReadyHandler constructs a ReadyHandler with a pointer
+// to the Telemetry server
+func NewReadyHandler(t *Telemetry) ReadyHandler {
+	return ReadyHandler{telem: t}
+}
+
+// ServeHTTP implements http.Handler for ReadyHandler
+func (rh ReadyHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		failedStatus := http.StatusMethodNotAllowed
+		http.Error(w, http.StatusText(failedStatus), failedStatus)
+		return
+	}
+	checks := []*probeCheck{}
+	for _, job := range rh.telem.readyJobs() {
+		status := job.GetStatus().String()
+		checks = append(checks, &probeCheck{
+			Name:    job.Name,
+			Status:  status,
+			Passing: status == "healthy", // not in maintenance either
+		})
+	}
+	writeProbeResponse(w, "ready", "not ready", checks)
+}
+
+// readyJobs returns the jobs checked by '/readyz': the jobs configured
+// by 'ready', or all the jobs with a health check by default
+func (t *Telemetry) readyJobs() []*jobs.Job {
+	ready := []*jobs.Job{}
+	for _, job := range t.status().jobs {
+		if (t.ready == nil && job.HasHealthCheck()) || contains(t.ready, job.Name) {
+			ready = append(ready, job)
+		}
+	}
+	return ready
+}
+
+// MonitorEvents sets the event bus whose responsiveness is checked by
+// the '/healthz' handler, replacing any previous one when the bus is
+// replaced on a config reload
+func (t *Telemetry) MonitorEvents(bus *events.EventBus) {
+	if t != nil {
+		t.lock.Lock()
+		defer t.lock.Unlock()
+		t.bus = bus
+	}
+}
+
+// eventBus returns the event bus checked by the '/healthz' handler
+func (t *Telemetry) eventBus() *events.EventBus {
+	t.lock.RLock()
+	defer t.lock.RUnlock()
+	return t.bus
+}
+
+// writeProbeResponse responds with 200 if all the checks pass, and
+// with 503 otherwise, so that it can be used as a Kubernetes probe
+func writeProbeResponse(w http.ResponseWriter, passing, failing string, checks []*probeCheck) {
+	resp := &probeResponse{Status: passing, Checks: checks}
+	code := http.StatusOK
+	for _, check := range checks {
+		if !check.Passing {
+			resp.Status = failing
+			code = http.StatusServiceUnavailable
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(resp)
+}
@@ -0,0 +1,103 @@
+package telemetry
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/asokolov365/containerpilot/events"
+	"github.com/asokolov365/containerpilot/jobs"
+	"github.com/asokolov365/containerpilot/tests"
+	"github.com/asokolov365/containerpilot/tests/mocks"
+)
+
+func TestHealthz(t *testing.T) {
+	cfg := &Config{Port: 9090, Interfaces: []interface{}{"lo", "lo0", "inet"}}
+	cfg.Validate(&mocks.NoopDiscoveryBackend{})
+	telem := NewTelemetry(cfg)
+
+	resp := getProbe(t, telem, "/healthz")
+	assert.Equal(t, http.StatusServiceUnavailable, resp.code,
+		"expected unhealthy before the event bus is running")
+	assert.Equal(t, "unhealthy", resp.body.Status)
+	assert.Equal(t, "stopped", resp.body.Checks[0].Status)
+
+	telem.MonitorEvents(events.NewEventBus())
+	resp = getProbe(t, telem, "/healthz")
+	assert.Equal(t, http.StatusOK, resp.code)
+	assert.Equal(t, "healthy", resp.body.Status)
+	assert.Equal(t, &probeCheck{Name: "events", Status: "responsive", Passing: true},
+		resp.body.Checks[0])
+
+	req := httptest.NewRequest(http.MethodPost, "/healthz", nil)
+	rec := httptest.NewRecorder()
+	telem.Handler.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusMethodNotAllowed, rec.Code)
+}
+
+func TestReadyz(t *testing.T) {
+	noop := &mocks.NoopDiscoveryBackend{}
+	jobCfgs, err := jobs.NewConfigs(
+		tests.DecodeRawToSlice(
+			`[
+				{name: "myjob1", exec: "sleep 10"},
+				{
+					name: "myjob2",
+					exec: "sleep 10",
+					port: 80,
+					health: {exec: "true", interval: 1, ttl: 10}
+				}
+			]`),
+		noop)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg := &Config{Port: 9090, Interfaces: []interface{}{"lo", "lo0", "inet"}}
+	cfg.Validate(noop)
+	monitored := jobs.FromConfigs(append(jobCfgs, cfg.JobConfig))
+
+	// by default only the jobs with a health check are checked
+	telem := NewTelemetry(cfg)
+	telem.MonitorJobs(monitored)
+	resp := getProbe(t, telem, "/readyz")
+	assert.Equal(t, http.StatusServiceUnavailable, resp.code)
+	assert.Equal(t, "not ready", resp.body.Status)
+	assert.Equal(t, []*probeCheck{{Name: "myjob2", Status: "unknown", Passing: false}},
+		resp.body.Checks)
+
+	// the telemetry job is always healthy
+	cfg.Ready = []string{"containerpilot"}
+	telem = NewTelemetry(cfg)
+	telem.MonitorJobs(monitored)
+	resp = getProbe(t, telem, "/readyz")
+	assert.Equal(t, http.StatusOK, resp.code)
+	assert.Equal(t, "ready", resp.body.Status)
+	assert.Equal(t, []*probeCheck{{Name: "containerpilot", Status: "healthy", Passing: true}},
+		resp.body.Checks)
+}
+
+func TestValidateReady(t *testing.T) {
+	cfg := &Config{Ready: []string{"myjob1", "nope"}}
+	assert.NoError(t, (&Config{}).ValidateReady([]string{"myjob1"}))
+	assert.EqualError(t, cfg.ValidateReady([]string{"myjob1"}),
+		"ready[1]: 'nope' is not a configured job")
+}
+
+type probeResult struct {
+	code int
+	body probeResponse
+}
+
+func getProbe(t *testing.T, telem *Telemetry, path string) probeResult {
+	req := httptest.NewRequest(http.MethodGet, path, nil)
+	rec := httptest.NewRecorder()
+	telem.Handler.ServeHTTP(rec, req)
+	result := probeResult{code: rec.Code}
+	if err := json.NewDecoder(rec.Body).Decode(&result.body); err != nil {
+		t.Fatal(err)
+	}
+	return result
+}
@@ -30,6 +30,7 @@ type serviceStatusResponse struct {
 	Address string
 	Port    int
 	Status  string
+	job     string // the services of a job share its status
 }
 
 // StatusHandler implements http.Handler
@@ -50,57 +51,93 @@ func (sh StatusHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, http.StatusText(failedStatus), failedStatus)
 		return
 	}
-	for _, job := range sh.telem.Status.jobs {
-		status := fmt.Sprintf("%s", job.GetStatus())
-		for _, service := range sh.telem.Status.Services {
-			if service.Name == job.Name {
-				service.Status = status
-			}
-		}
-		for _, jobStatus := range sh.telem.Status.Jobs {
-			if jobStatus.Name == job.Name {
-				jobStatus.Status = status
-			}
-		}
-	}
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusOK)
-	json.NewEncoder(w).Encode(sh.telem.Status)
+	json.NewEncoder(w).Encode(sh.telem.status().current())
 }
 
-// MonitorJobs adds a list of Jobs for the /status handler to monitor
+// current returns a copy of the Status with the current status of each
+// job, so that concurrent requests don't share the responses
+func (s *Status) current() *Status {
+	statuses := map[string]string{}
+	for _, job := range s.jobs {
+		statuses[job.Name] = fmt.Sprintf("%s", job.GetStatus())
+	}
+	resp := &Status{Version: s.Version, Watches: s.Watches}
+	for _, service := range s.Services {
+		serviceResponse := *service
+		serviceResponse.Status = statuses[service.job]
+		resp.Services = append(resp.Services, &serviceResponse)
+	}
+	for _, job := range s.Jobs {
+		resp.Jobs = append(resp.Jobs,
+			&jobStatusResponse{Name: job.Name, Status: statuses[job.Name]})
+	}
+	return resp
+}
+
+// status returns the Status, which is replaced rather than modified when
+// the monitored Jobs or Watches change
+func (t *Telemetry) status() *Status {
+	t.lock.RLock()
+	defer t.lock.RUnlock()
+	return t.Status
+}
+
+// MonitorJobs sets the list of Jobs for the /status handler and the job
+// resource usage metrics to monitor, replacing any previous list when the
+// Jobs change on a config reload
 func (t *Telemetry) MonitorJobs(jobs []*jobs.Job) {
-	if t != nil {
-		for _, job := range jobs {
-			t.Status.jobs = append(t.Status.jobs, job)
-			if job.Service != nil && job.Service.Port != 0 {
-				serviceResponse := &serviceStatusResponse{
-					Name:    job.Name,
-					Address: job.Service.IPAddress,
-					Port:    job.Service.Port,
-					Status:  fmt.Sprintf("%s", job.GetStatus()),
-				}
-				t.Status.Services = append(t.Status.Services, serviceResponse)
-			} else {
-				jobResponse := &jobStatusResponse{
-					Name:   job.Name,
-					Status: fmt.Sprintf("%s", job.GetStatus()),
-				}
-				t.Status.Jobs = append(t.Status.Jobs, jobResponse)
+	if t == nil {
+		return
+	}
+	status := &Status{}
+	for _, job := range jobs {
+		status.jobs = append(status.jobs, job)
+		advertised := false
+		for _, service := range job.Services {
+			if service.Port == 0 {
+				continue
+			}
+			serviceResponse := &serviceStatusResponse{
+				Name:    service.Name,
+				Address: service.IPAddress,
+				Port:    service.Port,
+				Status:  fmt.Sprintf("%s", job.GetStatus()),
+				job:     job.Name,
+			}
+			status.Services = append(status.Services, serviceResponse)
+			advertised = true
+		}
+		if !advertised {
+			jobResponse := &jobStatusResponse{
+				Name:   job.Name,
+				Status: fmt.Sprintf("%s", job.GetStatus()),
 			}
+			status.Jobs = append(status.Jobs, jobResponse)
 		}
 	}
+	t.lock.Lock()
+	status.Version = t.Status.Version
+	status.Watches = t.Status.Watches
+	t.Status = status
+	t.lock.Unlock()
+	registerJobsCollector(status.jobs)
 }
 
-// MonitorWatches adds a list of Watches for the /status handler to monitor
+// MonitorWatches sets the list of Watches for the /status handler to
+// monitor, replacing any previous list
 func (t *Telemetry) MonitorWatches(watches []*watches.Watch) {
-
-	// these watch names are cached forever because they don't change
-	// unless we reload ContainerPilot itself (and this server)
-	if t != nil {
-		for _, watch := range watches {
-			name := strings.TrimPrefix(watch.Name, "watch.")
-			t.Status.Watches = append(t.Status.Watches, name)
-		}
+	if t == nil {
+		return
+	}
+	var names []string
+	for _, watch := range watches {
+		names = append(names, strings.TrimPrefix(watch.Name, "watch."))
 	}
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	status := *t.Status
+	status.Watches = names
+	t.Status = &status
 }
@@ -6,19 +6,26 @@ import (
 	"context"
 	"net"
 	"net/http"
+	"sync"
 	"time"
 
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 	log "github.com/sirupsen/logrus"
 
+	"github.com/asokolov365/containerpilot/events"
 	"github.com/asokolov365/containerpilot/version"
 )
 
 // Telemetry represents the service to advertise for finding the metrics
 // endpoint, and the collection of Metrics.
 type Telemetry struct {
-	Metrics []*Metric // supports '/metrics' endpoint fields
-	Status  *Status   // supports '/status' endpoint fields
+	Metrics []*Metric    // supports '/metrics' endpoint fields
+	Status  *Status      // supports '/status' endpoint fields
+	lock    sync.RWMutex // Status is replaced on a reload while we serve
+
+	// supports '/healthz' and '/readyz' endpoints
+	bus   *events.EventBus
+	ready []string
 
 	// server
 	router *http.ServeMux
@@ -37,10 +44,13 @@ func NewTelemetry(cfg *Config) *Telemetry {
 		Status:  &Status{Version: version.Version},
 	}
 	t.addr = cfg.addr
+	t.ready = cfg.Ready
 
 	router := http.NewServeMux()
 	router.Handle("/metrics", promhttp.Handler())
 	router.Handle("/status", NewStatusHandler(t))
+	router.Handle("/healthz", NewHealthHandler(t))
+	router.Handle("/readyz", NewReadyHandler(t))
 	t.Handler = router
 
 	for _, sensorCfg := range cfg.MetricConfigs {
@@ -1,11 +1,11 @@
 package telemetry
 
 import (
-	"fmt"
 	"net"
 
 	"github.com/asokolov365/containerpilot/config/decode"
 	"github.com/asokolov365/containerpilot/config/services"
+	"github.com/asokolov365/containerpilot/config/validation"
 	"github.com/asokolov365/containerpilot/discovery"
 	"github.com/asokolov365/containerpilot/jobs"
 	"github.com/asokolov365/containerpilot/version"
@@ -18,6 +18,7 @@ type Config struct {
 	Interfaces []interface{} `mapstructure:"interfaces"` // optional override
 	Tags       []string      `mapstructure:"tags"`
 	Metrics    []interface{} `mapstructure:"metrics"`
+	Ready      []string      `mapstructure:"ready"` // jobs checked by /readyz
 
 	// derived in Validate
 	MetricConfigs []*MetricConfig
@@ -26,28 +27,30 @@ type Config struct {
 }
 
 // NewConfig parses json config into a validated Config
-// including a validated Config and validated MetricConfigs
+// including a validated Config and validated MetricConfigs. All the
+// problems found are returned together as validation.Errors, at paths
+// like `telemetry.metrics[0].type`.
 func NewConfig(raw interface{}, disc discovery.Backend) (*Config, error) {
 	if raw == nil {
 		return nil, nil
 	}
 	cfg := &Config{Port: 9090} // default values
 	if err := decode.ToStruct(raw, cfg); err != nil {
-		return nil, fmt.Errorf("telemetry configuration error: %v", err)
-	}
-	if err := cfg.Validate(disc); err != nil {
-		return nil, fmt.Errorf("telemetry validation error: %v", err)
+		return nil, validation.FromDecode("telemetry", err)
 	}
+	var errs validation.Errors
+	errs.Add("telemetry", cfg.Validate(disc))
 	if cfg.Metrics != nil {
 		// note that we don't return an error if there are no metrics
 		// because the prometheus handler will still pick up metrics
 		// internal to ContainerPilot (i.e. the golang runtime)
 		metrics, err := NewMetricConfigs(cfg.Metrics)
-		if err != nil {
-			return nil, err
-		}
+		errs.Add("telemetry", err)
 		cfg.MetricConfigs = metrics
 	}
+	if len(errs) > 0 {
+		return nil, errs
+	}
 	return cfg, nil
 }
 
@@ -55,18 +58,42 @@ func NewConfig(raw interface{}, disc discovery.Backend) (*Config, error) {
 func (cfg *Config) Validate(disc discovery.Backend) error {
 	ipAddress, err := services.IPFromInterfaces(cfg.Interfaces)
 	if err != nil {
-		return err
+		return validation.New("interfaces", err)
 	}
 	ip := net.ParseIP(ipAddress)
 	cfg.addr = net.TCPAddr{IP: ip, Port: cfg.Port}
 	jobConfig := cfg.ToJobConfig()
 	if err := jobConfig.Validate(disc); err != nil {
-		return fmt.Errorf("could not validate telemetry service: %v", err)
+		// the telemetry job's fields are taken from the telemetry
+		// config, so its errors are at the same paths
+		return err
 	}
 	cfg.JobConfig = jobConfig
 	return nil
 }
 
+// ValidateReady checks that the jobs checked by the '/readyz' endpoint
+// are configured. It returns validation.Errors at paths relative to the
+// telemetry config.
+func (cfg *Config) ValidateReady(jobNames []string) error {
+	var errs validation.Errors
+	for i, name := range cfg.Ready {
+		if !contains(jobNames, name) {
+			errs.Addf(validation.Index("ready", i), "'%s' is not a configured job", name)
+		}
+	}
+	return errs.ErrorOrNil()
+}
+
+func contains(names []string, name string) bool {
+	for _, n := range names {
+		if n == name {
+			return true
+		}
+	}
+	return false
+}
+
 // ToJobConfig ...
 func (cfg *Config) ToJobConfig() *jobs.Config {
 	if version.Version != "" {
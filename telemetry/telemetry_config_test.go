@@ -31,7 +31,7 @@ func TestTelemetryConfigParse(t *testing.T) {
 func TestTelemetryConfigBadMetric(t *testing.T) {
 	testCfg := tests.DecodeRaw(`{"metrics": [{}], "interfaces": ["inet", "lo0"]}`)
 	_, err := NewConfig(testCfg, &mocks.NoopDiscoveryBackend{})
-	expected := "invalid metric type"
+	expected := "telemetry.metrics[0].type: invalid metric type"
 	if err == nil || !strings.Contains(err.Error(), expected) {
 		t.Fatalf("expected '%v' in error from bad metric type but got %v", expected, err)
 	}
@@ -1,6 +1,6 @@
 package mocks
 
-import "github.com/hashicorp/consul/api"
+import "github.com/asokolov365/containerpilot/discovery"
 
 // NoopDiscoveryBackend is a mock discovery.Backend
 type NoopDiscoveryBackend struct {
@@ -20,22 +20,17 @@ func (noop *NoopDiscoveryBackend) CheckForUpstreamChanges(fields ...string) (did
 	return didChange, isHealthy
 }
 
-// CheckRegister (required for mock interface)
-func (noop *NoopDiscoveryBackend) CheckRegister(check *api.AgentCheckRegistration) error {
+// RegisterService (required for mock interface)
+func (noop *NoopDiscoveryBackend) RegisterService(service *discovery.ServiceDefinition, status string) error {
 	return nil
 }
 
-// UpdateTTL (required for mock interface)
-func (noop *NoopDiscoveryBackend) UpdateTTL(checkID, output, status string) error {
+// UpdateServiceStatus (required for mock interface)
+func (noop *NoopDiscoveryBackend) UpdateServiceStatus(service *discovery.ServiceDefinition, status, output string) error {
 	return nil
 }
 
-// ServiceDeregister (required for mock interface)
-func (noop *NoopDiscoveryBackend) ServiceDeregister(serviceID string) error {
-	return nil
-}
-
-// ServiceRegister (required for mock interface)
-func (noop *NoopDiscoveryBackend) ServiceRegister(service *api.AgentServiceRegistration) error {
+// DeregisterService (required for mock interface)
+func (noop *NoopDiscoveryBackend) DeregisterService(service *discovery.ServiceDefinition) error {
 	return nil
 }
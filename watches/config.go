@@ -1,11 +1,12 @@
 package watches
 
 import (
-	"fmt"
 	"reflect"
 
 	"github.com/asokolov365/containerpilot/config/decode"
 	"github.com/asokolov365/containerpilot/config/services"
+	"github.com/asokolov365/containerpilot/config/validation"
+	"github.com/asokolov365/containerpilot/discovery"
 	"github.com/asokolov365/containerpilot/surveillee"
 )
 
@@ -20,61 +21,84 @@ type Config struct {
 	surveilService surveillee.Backend
 }
 
-// NewConfigs parses json config into a validated slice of Configs
+// NewConfigs parses json config into a validated slice of Configs. All
+// the problems found in any of the watches are returned together as
+// validation.Errors, at paths like `watches[0].interval`.
 func NewConfigs(raw []interface{}, survSvcs *surveillee.Services) ([]*Config, error) {
 	var watches []*Config
 	if raw == nil {
 		return watches, nil
 	}
-	if err := decode.ToStruct(raw, &watches); err != nil {
-		return watches, fmt.Errorf("Watch configuration error: %v", err)
-	}
-	for _, watch := range watches {
-		if err := watch.Validate(survSvcs); err != nil {
-			return watches, err
+	var errs validation.Errors
+	for i, rawWatch := range raw {
+		path := validation.Index("watches", i)
+		watch := &Config{}
+		if err := decode.ToStruct(rawWatch, watch); err != nil {
+			errs = append(errs, validation.FromDecode(path, err)...)
+			continue
 		}
+		errs.Add(path, watch.Validate(survSvcs))
+		watches = append(watches, watch)
 	}
-	return watches, nil
+	return watches, errs.ErrorOrNil()
 }
 
 // Validate ensures Config meets all requirements and assigns surveilService
-// accordingly to the Source field.
+// accordingly to the Source field. It returns validation.Errors with
+// every problem found, at paths relative to the watch.
 func (cfg *Config) Validate(survSvcs *surveillee.Services) error {
+	var errs validation.Errors
 	if cfg.Name == "" {
-		return fmt.Errorf("'name' must not be blank")
+		errs.Addf("name", "must not be blank")
 	}
+	backendSource := discoverySource(survSvcs.Discovery)
 	if cfg.Source == "" {
 		cfg.Source = "consul"
+		if backendSource != "" {
+			cfg.Source = backendSource
+		}
 	}
 	cfg.serviceName = cfg.Name
 	cfg.Name = "watch." + cfg.Name
 
 	if cfg.Poll < 1 {
-		return fmt.Errorf("watch[%s].interval must be > 0", cfg.serviceName)
+		errs.Addf("interval", "must be > 0")
 	}
 	switch cfg.Source {
-	case "consul":
-		if survSvcs.Discovery == nil || reflect.ValueOf(survSvcs.Discovery).IsNil() {
-			return fmt.Errorf("watch[%s].source is consul but consul config is not defined", cfg.serviceName)
+	case "consul", "etcd":
+		if cfg.Source != backendSource {
+			errs.Addf("source", "is %s but %s config is not defined", cfg.Source, cfg.Source)
 		}
 		cfg.surveilService = survSvcs.Discovery
 	case "vault":
 		if survSvcs.SecretStorage == nil || reflect.ValueOf(survSvcs.SecretStorage).IsNil() {
-			return fmt.Errorf("watch[%s].source is vault but vault config is not defined", cfg.serviceName)
+			errs.Addf("source", "is vault but vault config is not defined")
 		}
 		cfg.surveilService = survSvcs.SecretStorage
 	case "file":
 		cfg.surveilService = survSvcs.FileWatcher
 	default:
-		return fmt.Errorf("watch[%s].source must be consul|vault|file but got %s", cfg.serviceName, cfg.Source)
+		errs.Addf("source", "must be consul|etcd|vault|file but got %s", cfg.Source)
 	}
-	if err := services.ValidateName(cfg.serviceName, cfg.Source); err != nil {
-		return err
+	if cfg.serviceName != "" {
+		errs.Add("name", services.ValidateName(cfg.serviceName, cfg.Source))
 	}
-	return nil
+	return errs.ErrorOrNil()
 }
 
 // String implements the stdlib fmt.Stringer interface for pretty-printing
 func (cfg *Config) String() string {
 	return "watches.Config[" + cfg.Name + "]"
 }
+
+// discoverySource returns the watch source of the discovery backend, or
+// an empty string if there's no discovery backend
+func discoverySource(backend surveillee.Backend) string {
+	if backend == nil || reflect.ValueOf(backend).IsNil() {
+		return ""
+	}
+	if _, ok := backend.(*discovery.Etcd); ok {
+		return "etcd"
+	}
+	return "consul"
+}
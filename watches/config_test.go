@@ -7,6 +7,7 @@ import (
 
 	"github.com/stretchr/testify/assert"
 
+	"github.com/asokolov365/containerpilot/discovery"
 	"github.com/asokolov365/containerpilot/surveillee"
 	"github.com/asokolov365/containerpilot/tests"
 	"github.com/asokolov365/containerpilot/tests/mocks"
@@ -43,18 +44,42 @@ func TestWatchesConfigError(t *testing.T) {
 		assert.EqualError(t, err, errMsg)
 	}
 	expectErr(
-		`[{name: ""}]`,
-		"'name' must not be blank")
+		`[{name: "", interval: 10, source: "file"}]`,
+		"watches[0].name: must not be blank")
 	expectErr(
 		`[{name: "myName"}]`,
-		"watch[myName].interval must be > 0")
+		"watches[0].interval: must be > 0\n"+
+			"watches[0].source: is consul but consul config is not defined")
 	expectErr(
 		`[{name: "myName", interval: 10}]`,
-		"watch[myName].source is consul but consul config is not defined")
+		"watches[0].source: is consul but consul config is not defined")
 	expectErr(
 		`[{name: "myName", interval: "xx"}]`,
-		"Watch configuration error: 1 error(s) decoding:\n\n* cannot parse '[0].interval' as int: strconv.ParseInt: parsing \"xx\": invalid syntax")
+		"watches[0].interval: cannot parse as int: strconv.ParseInt: parsing \"xx\": invalid syntax")
 	expectErr(
-		`[{name: "myName", source: "vault", interval: 10}]`,
-		"watch[myName].source is vault but vault config is not defined")
+		`[{name: "myName", source: "vault", interval: 10}, {name: "other", source: "nfs", interval: 10}]`,
+		"watches[0].source: is vault but vault config is not defined\n"+
+			"watches[1].source: must be consul|etcd|vault|file but got nfs")
+}
+
+func TestWatchesEtcdSource(t *testing.T) {
+	etcd, err := discovery.NewEtcd("etcd:2379")
+	if err != nil {
+		t.Fatal(err)
+	}
+	testCfg := tests.DecodeRawToSlice(
+		`[{name: "upstreamA", interval: 10}, {name: "upstreamB", source: "etcd", interval: 10}]`)
+	watches, err := NewConfigs(testCfg, surveillee.NewServices(etcd, nil, nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, "etcd", watches[0].Source, "expected the etcd source by default")
+	assert.Equal(t, "etcd", watches[1].Source)
+
+	testCfg = tests.DecodeRawToSlice(`[{name: "upstreamA", source: "consul", interval: 10}]`)
+	_, err = NewConfigs(testCfg, surveillee.NewServices(etcd, nil, nil))
+	assert.EqualError(t, err, "watches[0].source: is consul but consul config is not defined")
+	testCfg = tests.DecodeRawToSlice(`[{name: "upstreamA", source: "etcd", interval: 10}]`)
+	_, err = NewConfigs(testCfg, surveillee.NewServices(noop, nil, nil))
+	assert.EqualError(t, err, "watches[0].source: is etcd but etcd config is not defined")
 }
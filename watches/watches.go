@@ -19,6 +19,7 @@ type Watch struct {
 	poll           int
 	surveilService surveillee.Backend
 	rx             chan events.Event
+	cancel         context.CancelFunc
 
 	events.Publisher
 }
@@ -65,6 +66,7 @@ func (watch *Watch) Tick() time.Duration {
 func (watch *Watch) Run(pctx context.Context, bus *events.EventBus) {
 	watch.Register(bus)
 	ctx, cancel := context.WithCancel(pctx)
+	watch.cancel = cancel
 	timerSource := watch.Name + ".poll"
 
 	// TODO(justinwr@): this could be replaced by a simple Ticker
@@ -102,6 +104,14 @@ func (watch *Watch) Run(pctx context.Context, bus *events.EventBus) {
 	}()
 }
 
+// Stop halts the Watch if it's running, as when it has been removed or
+// changed by a config reload
+func (watch *Watch) Stop() {
+	if watch.cancel != nil {
+		watch.cancel()
+	}
+}
+
 // Receive receives an event into the internal control channel.
 func (watch *Watch) Receive(event events.Event) {
 	watch.rx <- event